@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/config"
+)
+
+// Build information, set at link time with
+// -ldflags "-X main.Version=... -X main.Commit=... -X main.BuildTime=...".
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+// versionInfo returns the build information, falling back to what the Go
+// toolchain recorded for binaries built without ldflags, e.g. with go install.
+func versionInfo() (version, commit, buildTime string) {
+	version, commit, buildTime = Version, Commit, BuildTime
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version, commit, buildTime
+	}
+	if version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		switch {
+		case setting.Key == "vcs.revision" && commit == "unknown":
+			commit = setting.Value
+		case setting.Key == "vcs.time" && buildTime == "unknown":
+			buildTime = setting.Value
+		}
+	}
+	return version, commit, buildTime
+}
+
+func printVersion(w io.Writer) {
+	version, commit, buildTime := versionInfo()
+	fmt.Fprintf(w, "cursor-admin-api-exporter, version %s (commit %s, built %s)\n", version, commit, buildTime)
+	fmt.Fprintf(w, "  go version: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// buildInfo returns a gauge that is always 1 and labelled with the build
+// information.
+func buildInfo() prometheus.Collector {
+	version, commit, _ := versionInfo()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cursor_exporter_build_info",
+		Help: "A metric with a constant '1' value labeled by the version and revision the exporter was built from",
+		ConstLabels: prometheus.Labels{
+			"version":   version,
+			"revision":  commit,
+			"goversion": runtime.Version(),
+		},
+	})
+	gauge.Set(1)
+	return gauge
+}
+
+// check validates the configuration, the pricing table and budget rules, and
+// that the API token of every team is accepted by the Cursor API. It returns
+// the exit code.
+func check(args []string) int {
+	fs := newFlagSet("check", "Validate the configuration and check the API token of every team with a single request to the Cursor API.")
+	cfg, _ := loadConfig(fs, args)
+
+	if _, err := exporterOptions(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println("Configuration is valid")
+
+	code := 0
+	for _, team := range cfg.TeamList() {
+		prefix := ""
+		if team.Name != "" {
+			prefix = "Team " + team.Name + ": "
+		}
+		if err := checkToken(cfg, team); err != nil {
+			fmt.Fprintf(os.Stderr, "%s%v\n", prefix, err)
+			code = 1
+			continue
+		}
+		fmt.Printf("%sAPI token is valid\n", prefix)
+	}
+	return code
+}
+
+// checkToken sends a single request to the Cursor API with the team's token.
+func checkToken(cfg *config.Config, team config.TeamConfig) error {
+	source, err := team.TokenSource()
+	if err != nil {
+		return err
+	}
+
+	api := client.NewCursorClient(cfg.Cursor.APIURL, team.APIToken)
+	api.Token = source
+	api.HTTPClient.Timeout = cfg.Cursor.RequestTimeout
+	api.Retry.MaxAttempts = 1
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Cursor.RequestTimeout)
+	defer cancel()
+
+	if _, err := api.GetTeamMembersContext(ctx); err != nil {
+		if client.IsAuthError(err) {
+			return fmt.Errorf("API token was rejected by %s: %w", cfg.Cursor.APIURL, err)
+		}
+		return fmt.Errorf("failed to reach the Cursor API at %s: %w", cfg.Cursor.APIURL, err)
+	}
+	return nil
+}
+
+// dump refreshes every enabled collector once and prints the metrics in the
+// Prometheus text format. It returns 1 if a collector failed.
+func dump(args []string) int {
+	fs := newFlagSet("dump", "Refresh every enabled collector once and print the metrics in the Prometheus text format.\nThe state file is read, if configured, but never written.")
+	cfg, _ := loadConfig(fs, args)
+
+	opts, err := exporterOptions(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	teams, err := newTeams(cfg, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := teams.LoadState(); err != nil {
+		logrus.WithError(err).Warn("Failed to load exporter state, counters start from zero")
+	}
+
+	gatherer, err := teams.Gatherer()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	families, err := gatherer.Gather()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	encoder := expfmt.NewEncoder(os.Stdout, expfmt.NewFormat(expfmt.TypeTextPlain))
+	code := 0
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if family.GetName() != "cursor_exporter_collector_success" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if metric.GetGauge().GetValue() == 0 {
+				code = 1
+			}
+		}
+	}
+	return code
+}
+
+// backfill fetches the daily usage history of every team and writes it in
+// the OpenMetrics format, one sample per day with the timestamp of the day,
+// for promtool tsdb create-blocks-from openmetrics. It returns the exit
+// code.
+func backfill(args []string) int {
+	fs := newFlagSet("backfill", "Fetch the daily usage history of every team and write it in the OpenMetrics format, one sample per day\nwith the timestamp of the day instead of a date label, for 'promtool tsdb create-blocks-from openmetrics'.")
+	startFlag := fs.String("start", "", "First day to fetch, as YYYY-MM-DD (default: the daily usage lookback before -end)")
+	endFlag := fs.String("end", "", "Last day to fetch, as YYYY-MM-DD (default: yesterday, as today's usage is not final)")
+	output := fs.String("output", "-", "File to write the metrics to, or - for stdout")
+	cfg, _ := loadConfig(fs, args)
+
+	end := startOfDay(time.Now()).AddDate(0, 0, -1)
+	if *endFlag != "" {
+		var err error
+		if end, err = time.ParseInLocation("2006-01-02", *endFlag, time.Local); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -end: %v\n", err)
+			return 2
+		}
+	}
+	start := end.AddDate(0, 0, 1-cfg.Collectors.DailyUsage.LookbackDays)
+	if *startFlag != "" {
+		var err error
+		if start, err = time.ParseInLocation("2006-01-02", *startFlag, time.Local); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -start: %v\n", err)
+			return 2
+		}
+	}
+	if start.After(end) {
+		fmt.Fprintf(os.Stderr, "-start %s is after -end %s\n", start.Format("2006-01-02"), end.Format("2006-01-02"))
+		return 2
+	}
+
+	opts, err := exporterOptions(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	teams, err := newTeams(cfg, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	families, err := teams.Backfill(context.Background(), start, end)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to fetch the daily usage history: %v\n", err)
+		return 1
+	}
+
+	if *output == "-" {
+		err = writeOpenMetrics(os.Stdout, families)
+	} else {
+		var file *os.File
+		if file, err = os.Create(*output); err == nil {
+			err = errors.Join(writeOpenMetrics(file, families), file.Close())
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write metrics: %v\n", err)
+		return 1
+	}
+
+	samples := 0
+	for _, family := range families {
+		samples += len(family.GetMetric())
+	}
+	logrus.WithFields(logrus.Fields{
+		"start":   start.Format("2006-01-02"),
+		"end":     end.Format("2006-01-02"),
+		"metrics": len(families),
+		"samples": samples,
+	}).Info("Wrote daily usage history")
+	return 0
+}
+
+// writeOpenMetrics writes families in the OpenMetrics text format, ending
+// with the # EOF line that promtool requires.
+func writeOpenMetrics(w io.Writer, families []*dto.MetricFamily) error {
+	encoder := expfmt.NewEncoder(w, expfmt.NewFormat(expfmt.TypeOpenMetrics))
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+	_, err := expfmt.FinalizeOpenMetrics(w)
+	return err
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
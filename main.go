@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,8 +15,14 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/budget"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/config"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/directory"
 	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/exporters"
-	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/utils"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/otlp"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/pricing"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/state"
 )
 
 func debugLoggingMiddleware(next http.Handler) http.Handler {
@@ -53,54 +61,181 @@ func (w *wrappedWriter) WriteHeader(statusCode int) {
 }
 
 func main() {
-	cursorAPIURL := utils.GetEnvWithDefault("CURSOR_API_URL", "https://api.cursor.com")
-	cursorAPIToken := os.Getenv("CURSOR_API_TOKEN")
-	listenAddr := utils.GetEnvWithDefault("LISTEN_ADDRESS", ":8080")
-	metricsPath := utils.GetEnvWithDefault("METRICS_PATH", "/metrics")
-	logLevel := utils.GetEnvWithDefault("LOG_LEVEL", "info")
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	} else if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		// Without a command, help lists the commands; "serve --help" lists
+		// the flags of serve.
+		usage()
+		return
+	}
 
-	helpFlag := false
-	for _, arg := range os.Args {
-		if arg == "--help" || arg == "-h" {
-			helpFlag = true
-			break
-		}
+	switch command {
+	case "serve":
+		serve(args)
+	case "check":
+		os.Exit(check(args))
+	case "dump":
+		os.Exit(dump(args))
+	case "backfill":
+		os.Exit(backfill(args))
+	case "version":
+		printVersion(os.Stdout)
+	case "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", command)
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  serve    Serve metrics over HTTP (default)\n")
+	fmt.Fprintf(os.Stderr, "  check    Validate the configuration and the API token, then exit\n")
+	fmt.Fprintf(os.Stderr, "  dump     Collect every enabled collector once and print the metrics\n")
+	fmt.Fprintf(os.Stderr, "  backfill Write the daily usage history as OpenMetrics for promtool\n")
+	fmt.Fprintf(os.Stderr, "  version  Print version information\n\n")
+	fmt.Fprintf(os.Stderr, "Run '%s <command> --help' to list the flags of a command. Settings are read\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "from the config file (see config.example.yaml), environment variables and\n")
+	fmt.Fprintf(os.Stderr, "flags, in increasing order of precedence.\n")
+}
+
+// newFlagSet returns the flag set of a command, whose usage message starts
+// with description.
+func newFlagSet(command, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags]\n\n%s\n\nFlags:\n", os.Args[0], command, description)
+		fs.PrintDefaults()
 	}
+	return fs
+}
 
-	level, err := logrus.ParseLevel(logLevel)
+// loadConfig parses the flags of a command and loads the configuration they
+// point to. Configuration errors can span several lines, so they are printed
+// as is rather than through the logger, and end the process.
+func loadConfig(fs *flag.FlagSet, args []string) (*config.Config, *config.Flags) {
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := flags.Load(flags.File)
 	if err != nil {
-		logrus.WithError(err).Warn("Invalid log level, using info")
-		level = logrus.InfoLevel
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	level, err := logrus.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		logrus.WithError(err).Fatal("Invalid log level")
 	}
 	logrus.SetLevel(level)
+	return cfg, flags
+}
 
-	if helpFlag {
-		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  This application is configured primarily via environment variables.\n")
-		fmt.Fprintf(os.Stderr, "  Key environment variables:\n")
-		fmt.Fprintf(os.Stderr, "    CURSOR_API_URL: Cursor API endpoint (default: https://api.cursor.com)\n")
-		fmt.Fprintf(os.Stderr, "    CURSOR_API_TOKEN: Cursor API token (required)\n")
-		fmt.Fprintf(os.Stderr, "    LISTEN_ADDRESS: HTTP server listen address (default: :8080)\n")
-		fmt.Fprintf(os.Stderr, "    METRICS_PATH: Metrics endpoint path (default: /metrics)\n")
-		fmt.Fprintf(os.Stderr, "    LOG_LEVEL: Logging level (default: info)\n")
-		fmt.Fprintf(os.Stderr, "  Use --help or -h to display this message.\n")
-		os.Exit(0)
+// serve runs the exporter until it receives SIGINT or SIGTERM.
+func serve(args []string) {
+	fs := newFlagSet("serve", "Serve metrics over HTTP until interrupted.")
+	printConfig := fs.Bool("print-config", false, "Print the effective configuration with secrets redacted and exit")
+	cfg, flags := loadConfig(fs, args)
+
+	if *printConfig {
+		out, err := cfg.Marshal()
+		if err != nil {
+			logrus.WithError(err).Fatal("Failed to print configuration")
+		}
+		if _, err := os.Stdout.Write(out); err != nil {
+			logrus.WithError(err).Fatal("Failed to print configuration")
+		}
+		return
 	}
 
-	if cursorAPIToken == "" {
-		logrus.Fatal("CURSOR_API_TOKEN environment variable is required")
+	opts, err := exporterOptions(cfg)
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to configure exporter")
+	}
+	teams, err := newTeams(cfg, opts)
+	if err != nil {
+		logrus.WithError(err).Fatal("Failed to configure exporter")
 	}
 
 	logrus.WithFields(logrus.Fields{
-		"cursor_api_url": cursorAPIURL,
-		"listen_addr":    listenAddr,
-		"metrics_path":   metricsPath,
-		"log_level":      logLevel,
+		"version":        Version,
+		"config_file":    flags.File,
+		"cursor_api_url": cfg.Cursor.APIURL,
+		"listen_addr":    cfg.Server.ListenAddress,
+		"metrics_path":   cfg.Server.MetricsPath,
+		"log_level":      cfg.LogLevel,
+		"state_file":     cfg.State.File,
+		"pricing_file":   cfg.PricingFile,
+		"budgets_file":   cfg.BudgetsFile,
+		"directory_file": cfg.DirectoryFile,
+		"identity":       cfg.Privacy.Identity,
+		"otlp_endpoint":  cfg.OTLP.Endpoint,
+		"teams":          len(teams),
 	}).Info("Starting Cursor Admin API Exporter")
 
-	exporter := exporters.NewCursorExporter(cursorAPIURL, cursorAPIToken)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	teams.Start(ctx)
 
-	prometheus.MustRegister(exporter)
+	reloader := config.NewReloader(flags.File, cfg, flags.Load, func(next *config.Config) error {
+		opts, err := exporterOptions(next)
+		if err != nil {
+			return err
+		}
+		level, err := logrus.ParseLevel(next.LogLevel)
+		if err != nil {
+			return err
+		}
+		logrus.SetLevel(level)
+		teams.Reload(opts)
+		logrus.Info("Configuration reloaded")
+		return nil
+	})
+	prometheus.MustRegister(reloader, buildInfo())
+	if cfg.WatchInterval > 0 {
+		go reloader.Watch(ctx, cfg.WatchInterval)
+	}
+
+	var pusher *otlp.Pusher
+	if cfg.OTLP.Endpoint != "" {
+		if pusher, err = newPusher(ctx, cfg, teams); err != nil {
+			logrus.WithError(err).Fatal("Failed to configure OTLP export")
+		}
+		logrus.WithFields(logrus.Fields{
+			"endpoint": cfg.OTLP.Endpoint,
+			"protocol": cfg.OTLP.Protocol,
+			"interval": cfg.OTLP.Interval,
+		}).Info("Pushing metrics over OTLP")
+	}
+
+	go func() {
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hupChan:
+				logrus.Info("Received SIGHUP, reloading configuration")
+				if err := reloader.Reload(); err != nil {
+					logrus.WithError(err).Error("Failed to reload configuration")
+				}
+			}
+		}
+	}()
 
 	mux := http.NewServeMux()
 
@@ -109,7 +244,14 @@ func main() {
 		handler = debugLoggingMiddleware(mux)
 	}
 
-	mux.Handle(metricsPath, promhttp.Handler())
+	metricsLink := ""
+	if cfg.Server.MetricsEnabled {
+		mux.Handle(cfg.Server.MetricsPath, promhttp.InstrumentMetricHandler(
+			prometheus.DefaultRegisterer,
+			teams.Handler(prometheus.DefaultGatherer),
+		))
+		metricsLink = fmt.Sprintf(`<li><a href="%s">Metrics</a></li>`, cfg.Server.MetricsPath)
+	}
 
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		logrus.Debug("Health check endpoint accessed")
@@ -130,7 +272,7 @@ func main() {
 		<h1>Cursor Admin API Exporter</h1>
 		<p>This is a Prometheus exporter for Cursor Admin API metrics.</p>
 		<ul>
-		<li><a href="%s">Metrics</a></li>
+		%s
 		<li><a href="/health">Health Check</a></li>
 		</ul>
 		<h2>Available Metrics</h2>
@@ -142,13 +284,13 @@ func main() {
 		</ul>
 		</body>
 		</html>
-		`, metricsPath); err != nil {
+		`, metricsLink); err != nil {
 			logrus.WithError(err).Error("Failed to write root page response")
 		}
 	})
 
 	server := &http.Server{
-		Addr:              listenAddr,
+		Addr:              cfg.Server.ListenAddress,
 		Handler:           handler,
 		ReadTimeout:       15 * time.Second,
 		WriteTimeout:      15 * time.Second,
@@ -156,9 +298,6 @@ func main() {
 		IdleTimeout:       60 * time.Second,
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	go func() {
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -174,11 +313,129 @@ func main() {
 		cancel()
 	}()
 
-	logrus.WithField("address", listenAddr).Info("Starting HTTP server")
+	logrus.WithField("address", cfg.Server.ListenAddress).Info("Starting HTTP server")
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		logrus.WithError(err).Fatal("HTTP server error")
 	}
 
 	<-ctx.Done()
+
+	if pusher != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.OTLP.Timeout)
+		if err := pusher.Shutdown(shutdownCtx); err != nil {
+			logrus.WithError(err).Error("Failed to push the last metrics over OTLP")
+		}
+		shutdownCancel()
+	}
+	if err := teams.SaveState(); err != nil {
+		logrus.WithError(err).Error("Failed to save exporter state")
+	}
 	logrus.Info("Server stopped")
 }
+
+// exporterOptions converts the configuration into the exporter options
+// shared by every team, loading the pricing table and budget rules it points
+// to.
+func exporterOptions(cfg *config.Config) (exporters.Options, error) {
+	opts := exporters.DefaultOptions()
+
+	for _, name := range exporters.CollectorNames {
+		c := cfg.Collector(name)
+		o := opts.Collector(name)
+		o.Enabled = c.Enabled
+		o.Interval = c.Interval
+		if c.LookbackDays > 0 {
+			o.LookbackDays = c.LookbackDays
+		}
+		if c.PageSize > 0 {
+			o.PageSize = c.PageSize
+		}
+	}
+	opts.Concurrency = cfg.Collectors.Concurrency
+	opts.CollectorTimeout = cfg.Collectors.Timeout
+
+	opts.RequestTimeout = cfg.Cursor.RequestTimeout
+	opts.Retry = client.RetryPolicy{
+		MaxAttempts:    cfg.Cursor.Retry.MaxAttempts,
+		InitialBackoff: cfg.Cursor.Retry.InitialBackoff,
+		MaxBackoff:     cfg.Cursor.Retry.MaxBackoff,
+	}
+	opts.RateLimit = client.RateLimit{
+		RequestsPerMinute: cfg.Cursor.RateLimit.RequestsPerMinute,
+		Burst:             cfg.Cursor.RateLimit.Burst,
+	}
+	opts.EndpointRateLimits = make(map[string]client.RateLimit, len(cfg.Cursor.RateLimit.Endpoints))
+	for endpoint, limit := range cfg.Cursor.RateLimit.Endpoints {
+		opts.EndpointRateLimits[endpoint] = client.RateLimit{RequestsPerMinute: limit.RequestsPerMinute, Burst: limit.Burst}
+	}
+
+	opts.StateFlushInterval = cfg.State.FlushInterval
+
+	var err error
+	if cfg.PricingFile != "" {
+		if opts.Pricing, err = pricing.LoadFile(cfg.PricingFile); err != nil {
+			return opts, fmt.Errorf("failed to load pricing table: %w", err)
+		}
+	}
+	if cfg.BudgetsFile != "" {
+		if opts.Budgets, err = budget.LoadFile(cfg.BudgetsFile); err != nil {
+			return opts, fmt.Errorf("failed to load budget rules: %w", err)
+		}
+	}
+	if cfg.DirectoryFile != "" {
+		if opts.Directory, err = directory.LoadFile(cfg.DirectoryFile); err != nil {
+			return opts, fmt.Errorf("failed to load user directory: %w", err)
+		}
+	}
+	if opts.Privacy, err = cfg.Privacy.Policy(); err != nil {
+		return opts, fmt.Errorf("failed to configure privacy: %w", err)
+	}
+	if opts.Limits, err = cfg.Cardinality.Limiter(); err != nil {
+		return opts, fmt.Errorf("failed to configure cardinality limits: %w", err)
+	}
+	return opts, nil
+}
+
+// newPusher starts pushing the metrics of every team over OTLP, each team
+// with its own resource, together with the exporter's own metrics.
+func newPusher(ctx context.Context, cfg *config.Config, teams exporters.Teams) (*otlp.Pusher, error) {
+	sources := []otlp.Source{{Gatherer: prometheus.DefaultGatherer}}
+	for _, team := range teams {
+		gatherer, err := team.Gatherer()
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, otlp.Source{Team: team.Name, Gatherer: gatherer})
+	}
+
+	opts := cfg.OTLP.Options()
+	opts.Version, _, _ = versionInfo()
+	return otlp.New(ctx, opts, sources)
+}
+
+// newTeams creates an exporter for every team in the configuration with opts
+// and the team's own token and state file.
+func newTeams(cfg *config.Config, opts exporters.Options) (exporters.Teams, error) {
+	var teams exporters.Teams
+	for _, team := range cfg.TeamList() {
+		teamOpts := opts
+		teamOpts.Team = team.Name
+
+		var err error
+		if teamOpts.TokenSource, err = team.TokenSource(); err != nil {
+			if team.Name != "" {
+				err = fmt.Errorf("team %s: %w", team.Name, err)
+			}
+			return nil, err
+		}
+		if file := cfg.StateFile(team.Name); file != "" {
+			teamOpts.StateStore = state.NewFileStore(file)
+		}
+
+		teams = append(teams, exporters.Team{
+			Name:     team.Name,
+			Exporter: exporters.NewCursorExporterWithOptions(cfg.Cursor.APIURL, team.APIToken, teamOpts),
+		})
+	}
+	return teams, nil
+}
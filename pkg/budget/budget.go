@@ -0,0 +1,224 @@
+package budget
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+)
+
+// Scope is what a budget rule limits.
+type Scope string
+
+const (
+	// ScopeUser limits the spend of a single team member.
+	ScopeUser Scope = "user"
+	// ScopeRole limits the spend of every team member with a role, each
+	// member on their own.
+	ScopeRole Scope = "role"
+	// ScopeTeam limits the spend of the whole team.
+	ScopeTeam Scope = "team"
+)
+
+// defaultThresholds are the utilization ratios that trigger a notification
+// when neither the rule nor the config sets its own.
+var defaultThresholds = []float64{0.8, 1}
+
+// Config is a set of budget rules, evaluated against the spend of the current
+// billing cycle.
+type Config struct {
+	// Thresholds are the utilization ratios that trigger a notification for
+	// rules that do not set their own.
+	Thresholds []float64      `yaml:"thresholds"`
+	Webhook    *WebhookConfig `yaml:"webhook"`
+	Rules      []Rule         `yaml:"rules"`
+}
+
+// WebhookConfig is where notifications are sent.
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Timeout time.Duration     `yaml:"timeout"`
+}
+
+// Rule is a spend limit for the current billing cycle.
+type Rule struct {
+	Name         string    `yaml:"name"`
+	Scope        Scope     `yaml:"scope"`
+	UserEmail    string    `yaml:"user_email"`
+	Role         string    `yaml:"role"`
+	LimitDollars float64   `yaml:"limit_dollars"`
+	Thresholds   []float64 `yaml:"thresholds"`
+}
+
+// LoadFile reads budget rules from a YAML file.
+func LoadFile(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read budgets file: %w", err)
+	}
+
+	config, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid budgets file %s: %w", filename, err)
+	}
+	return config, nil
+}
+
+// Parse decodes budget rules from YAML.
+func Parse(data []byte) (*Config, error) {
+	var config Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		return nil, err
+	}
+
+	if err := config.init(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (c *Config) init() error {
+	if len(c.Thresholds) == 0 {
+		c.Thresholds = slices.Clone(defaultThresholds)
+	}
+	if err := sortThresholds(c.Thresholds); err != nil {
+		return err
+	}
+
+	if c.Webhook != nil && c.Webhook.URL == "" {
+		return fmt.Errorf("webhook: url is required")
+	}
+
+	names := make(map[string]bool)
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d: name is required", i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rule %q: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+
+		switch rule.Scope {
+		case ScopeUser:
+			if rule.UserEmail == "" {
+				return fmt.Errorf("rule %q: user_email is required for scope %q", rule.Name, rule.Scope)
+			}
+		case ScopeRole:
+			if rule.Role == "" {
+				return fmt.Errorf("rule %q: role is required for scope %q", rule.Name, rule.Scope)
+			}
+		case ScopeTeam:
+		default:
+			return fmt.Errorf("rule %q: scope must be one of %q, %q or %q", rule.Name, ScopeUser, ScopeRole, ScopeTeam)
+		}
+
+		if rule.LimitDollars <= 0 {
+			return fmt.Errorf("rule %q: limit_dollars must be positive", rule.Name)
+		}
+
+		if len(rule.Thresholds) == 0 {
+			rule.Thresholds = slices.Clone(c.Thresholds)
+		}
+		if err := sortThresholds(rule.Thresholds); err != nil {
+			return fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func sortThresholds(thresholds []float64) error {
+	for _, t := range thresholds {
+		if t <= 0 {
+			return fmt.Errorf("thresholds must be positive, got %v", t)
+		}
+	}
+	sort.Float64s(thresholds)
+	return nil
+}
+
+// Result is the utilization of one rule for one member, or for the whole
+// team when MemberEmail is empty.
+type Result struct {
+	Rule         *Rule
+	MemberEmail  string
+	SpendDollars float64
+	Utilization  float64
+}
+
+// Exceeded reports whether the spend has reached the limit.
+func (r Result) Exceeded() bool {
+	return r.Utilization >= 1
+}
+
+// threshold returns the highest threshold the utilization has reached, or 0
+// if it has reached none.
+func (r Result) threshold() float64 {
+	reached := 0.0
+	for _, t := range r.Rule.Thresholds {
+		if r.Utilization >= t {
+			reached = t
+		}
+	}
+	return reached
+}
+
+// Evaluate applies every rule to the spend of the current billing cycle.
+// roles maps member emails to their team role and is only needed by role
+// rules; members missing from it are not covered by any role rule.
+func (c *Config) Evaluate(spending []client.SpendingData, roles map[string]string) []Result {
+	if c == nil {
+		return nil
+	}
+
+	spendByMember := make(map[string]int)
+	teamSpend := 0
+	for _, spend := range spending {
+		spendByMember[spend.MemberEmail] += spend.SpendCents
+		teamSpend += spend.SpendCents
+	}
+
+	members := make([]string, 0, len(spendByMember))
+	for email := range spendByMember {
+		members = append(members, email)
+	}
+	sort.Strings(members)
+
+	var results []Result
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		switch rule.Scope {
+		case ScopeUser:
+			results = append(results, rule.result(rule.UserEmail, spendByMember[rule.UserEmail]))
+		case ScopeRole:
+			for _, email := range members {
+				if roles[email] == rule.Role {
+					results = append(results, rule.result(email, spendByMember[email]))
+				}
+			}
+		case ScopeTeam:
+			results = append(results, rule.result("", teamSpend))
+		}
+	}
+	return results
+}
+
+func (r *Rule) result(memberEmail string, spendCents int) Result {
+	spend := float64(spendCents) / 100
+	return Result{
+		Rule:         r,
+		MemberEmail:  memberEmail,
+		SpendDollars: spend,
+		Utilization:  spend / r.LimitDollars,
+	}
+}
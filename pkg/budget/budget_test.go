@@ -0,0 +1,133 @@
+package budget
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+)
+
+const testConfig = `
+thresholds: [0.5, 1]
+rules:
+  - name: alice
+    scope: user
+    user_email: alice@example.com
+    limit_dollars: 20
+  - name: members
+    scope: role
+    role: member
+    limit_dollars: 10
+    thresholds: [1, 0.9]
+  - name: team
+    scope: team
+    limit_dollars: 100
+`
+
+func TestConfig_Evaluate(t *testing.T) {
+	config, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	spending := []client.SpendingData{
+		{MemberEmail: "alice@example.com", SpendCents: 1500},
+		{MemberEmail: "bob@example.com", SpendCents: 1200},
+		{MemberEmail: "carol@example.com", SpendCents: 300},
+		{MemberEmail: "dave@example.com", SpendCents: 5000},
+	}
+	roles := map[string]string{
+		"alice@example.com": "owner",
+		"bob@example.com":   "member",
+		"carol@example.com": "member",
+	}
+
+	results := config.Evaluate(spending, roles)
+
+	type want struct {
+		utilization float64
+		exceeded    bool
+	}
+	expected := map[[2]string]want{
+		{"alice", "alice@example.com"}:   {0.75, false},
+		{"members", "bob@example.com"}:   {1.2, true},
+		{"members", "carol@example.com"}: {0.3, false},
+		{"team", ""}:                     {0.8, false},
+	}
+
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got %d", len(expected), len(results))
+	}
+	for _, result := range results {
+		w, ok := expected[[2]string{result.Rule.Name, result.MemberEmail}]
+		if !ok {
+			t.Errorf("Unexpected result for rule %q member %q", result.Rule.Name, result.MemberEmail)
+			continue
+		}
+		if result.Utilization != w.utilization {
+			t.Errorf("Rule %q member %q: expected utilization %f, got %f", result.Rule.Name, result.MemberEmail, w.utilization, result.Utilization)
+		}
+		if result.Exceeded() != w.exceeded {
+			t.Errorf("Rule %q member %q: expected exceeded=%v", result.Rule.Name, result.MemberEmail, w.exceeded)
+		}
+	}
+}
+
+func TestParse_Thresholds(t *testing.T) {
+	config, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	if got := config.Rules[0].Thresholds; len(got) != 2 || got[0] != 0.5 {
+		t.Errorf("Expected rules without thresholds to inherit the config thresholds, got %v", got)
+	}
+	if got := config.Rules[1].Thresholds; got[0] != 0.9 || got[1] != 1 {
+		t.Errorf("Expected rule thresholds to be sorted, got %v", got)
+	}
+
+	config, err = Parse([]byte("rules:\n  - name: team\n    scope: team\n    limit_dollars: 1\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if got := config.Rules[0].Thresholds; len(got) != 2 || got[0] != 0.8 || got[1] != 1 {
+		t.Errorf("Expected default thresholds [0.8 1], got %v", got)
+	}
+
+	config.Thresholds[0] = 0.1
+	config.Rules[0].Thresholds[1] = 2
+	if got := defaultThresholds; got[0] != 0.8 || got[1] != 1 {
+		t.Errorf("Expected the defaults to be copied, not shared, got %v", got)
+	}
+	if got := config.Thresholds[1]; got != 1 {
+		t.Errorf("Expected rules to get their own copy of the thresholds, got %v", config.Thresholds)
+	}
+}
+
+func TestParse_RejectsInvalidConfig(t *testing.T) {
+	tests := map[string]string{
+		"missing name":        "rules:\n  - scope: team\n    limit_dollars: 1\n",
+		"duplicate name":      "rules:\n  - {name: a, scope: team, limit_dollars: 1}\n  - {name: a, scope: team, limit_dollars: 2}\n",
+		"unknown scope":       "rules:\n  - {name: a, scope: org, limit_dollars: 1}\n",
+		"user without email":  "rules:\n  - {name: a, scope: user, limit_dollars: 1}\n",
+		"role without role":   "rules:\n  - {name: a, scope: role, limit_dollars: 1}\n",
+		"zero limit":          "rules:\n  - {name: a, scope: team}\n",
+		"negative threshold":  "thresholds: [-1]\n",
+		"webhook without url": "webhook:\n  timeout: 5s\n",
+		"unknown field":       "rules:\n  - {name: a, scope: team, limit: 1}\n",
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse([]byte(data)); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestLoadFile_Example(t *testing.T) {
+	if _, err := LoadFile(filepath.Join("..", "..", "budgets.example.yaml")); err != nil {
+		t.Errorf("Failed to load example budgets file: %v", err)
+	}
+}
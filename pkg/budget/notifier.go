@@ -0,0 +1,195 @@
+package budget
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultWebhookTimeout = 10 * time.Second
+
+// Notification is the JSON body posted to the webhook when a rule crosses a
+// threshold.
+type Notification struct {
+	Rule         string    `json:"rule"`
+	Scope        Scope     `json:"scope"`
+	MemberEmail  string    `json:"member_email,omitempty"`
+	Role         string    `json:"role,omitempty"`
+	Threshold    float64   `json:"threshold"`
+	Utilization  float64   `json:"utilization"`
+	SpendDollars float64   `json:"spend_dollars"`
+	LimitDollars float64   `json:"limit_dollars"`
+	Exceeded     bool      `json:"exceeded"`
+	CycleStart   time.Time `json:"cycle_start"`
+}
+
+type notifyKey struct {
+	rule        string
+	memberEmail string
+}
+
+type notified struct {
+	cycleStart time.Time
+	threshold  float64
+}
+
+// Notifier posts a notification the first time a rule reaches each of its
+// thresholds within a billing cycle. It remembers what it has sent so that
+// a threshold fires once per cycle, not on every refresh.
+type Notifier struct {
+	webhook *WebhookConfig
+	client  *http.Client
+
+	mu   sync.Mutex
+	sent map[notifyKey]notified
+}
+
+// NewNotifier returns a Notifier for the webhook in config, or nil if config
+// has no webhook. A nil *Notifier sends nothing.
+func NewNotifier(config *Config) *Notifier {
+	if config == nil || config.Webhook == nil {
+		return nil
+	}
+
+	timeout := config.Webhook.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+
+	return &Notifier{
+		webhook: config.Webhook,
+		client:  &http.Client{Timeout: timeout},
+		sent:    make(map[notifyKey]notified),
+	}
+}
+
+// Notify sends a notification for every result that reached a higher
+// threshold than the last one notified in the cycle starting at cycleStart.
+// A notification that fails to send is retried on the next call.
+func (n *Notifier) Notify(ctx context.Context, cycleStart time.Time, results []Result) error {
+	if n == nil {
+		return nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var errs []error
+	for _, result := range results {
+		key := notifyKey{rule: result.Rule.Name, memberEmail: result.MemberEmail}
+		last, ok := n.sent[key]
+		if !ok || !last.cycleStart.Equal(cycleStart) {
+			last = notified{cycleStart: cycleStart}
+		}
+
+		threshold := result.threshold()
+		if threshold <= last.threshold {
+			// Spend can drop, for example after a refund. Forget the higher
+			// threshold so it fires again if spend climbs back.
+			if threshold < last.threshold {
+				n.sent[key] = notified{cycleStart: cycleStart, threshold: threshold}
+			}
+			continue
+		}
+
+		notification := Notification{
+			Rule:         result.Rule.Name,
+			Scope:        result.Rule.Scope,
+			MemberEmail:  result.MemberEmail,
+			Role:         result.Rule.Role,
+			Threshold:    threshold,
+			Utilization:  result.Utilization,
+			SpendDollars: result.SpendDollars,
+			LimitDollars: result.Rule.LimitDollars,
+			Exceeded:     result.Exceeded(),
+			CycleStart:   cycleStart,
+		}
+		if err := n.send(ctx, notification); err != nil {
+			errs = append(errs, fmt.Errorf("rule %q: %w", result.Rule.Name, err))
+			continue
+		}
+		n.sent[key] = notified{cycleStart: cycleStart, threshold: threshold}
+	}
+	return errors.Join(errs...)
+}
+
+func (n *Notifier) send(ctx context.Context, notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range n.webhook.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SentState is the JSON form of what a Notifier has sent.
+type SentState struct {
+	Rule        string    `json:"rule"`
+	MemberEmail string    `json:"member_email,omitempty"`
+	CycleStart  time.Time `json:"cycle_start"`
+	Threshold   float64   `json:"threshold"`
+}
+
+// State returns what the notifier has sent so it can be persisted.
+func (n *Notifier) State() []SentState {
+	if n == nil {
+		return nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	s := make([]SentState, 0, len(n.sent))
+	for key, sent := range n.sent {
+		s = append(s, SentState{
+			Rule:        key.rule,
+			MemberEmail: key.memberEmail,
+			CycleStart:  sent.cycleStart,
+			Threshold:   sent.threshold,
+		})
+	}
+	return s
+}
+
+// Restore replaces what the notifier has sent with state saved by State.
+func (n *Notifier) Restore(s []SentState) {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.sent = make(map[notifyKey]notified, len(s))
+	for _, sent := range s {
+		n.sent[notifyKey{rule: sent.Rule, memberEmail: sent.MemberEmail}] = notified{
+			cycleStart: sent.CycleStart,
+			threshold:  sent.Threshold,
+		}
+	}
+}
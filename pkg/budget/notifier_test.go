@@ -0,0 +1,165 @@
+package budget
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookRecorder struct {
+	mu            sync.Mutex
+	notifications []Notification
+	status        int
+}
+
+func (w *webhookRecorder) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.status != 0 {
+		rw.WriteHeader(w.status)
+		return
+	}
+
+	var n Notification
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.notifications = append(w.notifications, n)
+}
+
+func (w *webhookRecorder) thresholds() []float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var thresholds []float64
+	for _, n := range w.notifications {
+		thresholds = append(thresholds, n.Threshold)
+	}
+	return thresholds
+}
+
+func TestNotifier_NotifiesOncePerThresholdAndCycle(t *testing.T) {
+	recorder := &webhookRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	config, err := Parse([]byte("webhook:\n  url: " + server.URL + "\nrules:\n  - {name: team, scope: team, limit_dollars: 100}\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	notifier := NewNotifier(config)
+	rule := &config.Rules[0]
+	cycle := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	notify := func(cycleStart time.Time, utilization float64) {
+		t.Helper()
+		if err := notifier.Notify(context.Background(), cycleStart, []Result{{Rule: rule, Utilization: utilization}}); err != nil {
+			t.Fatalf("Notify failed: %v", err)
+		}
+	}
+
+	notify(cycle, 0.5)
+	notify(cycle, 0.85)
+	notify(cycle, 0.9)
+	notify(cycle, 1.2)
+	notify(cycle, 1.3)
+
+	got := recorder.thresholds()
+	if len(got) != 2 || got[0] != 0.8 || got[1] != 1 {
+		t.Fatalf("Expected notifications for thresholds [0.8 1], got %v", got)
+	}
+
+	// A new billing cycle starts over.
+	notify(cycle.AddDate(0, 1, 0), 0.9)
+	if got := recorder.thresholds(); len(got) != 3 || got[2] != 0.8 {
+		t.Errorf("Expected a new notification in the next cycle, got %v", got)
+	}
+
+	recorder.mu.Lock()
+	last := recorder.notifications[len(recorder.notifications)-1]
+	recorder.mu.Unlock()
+	if last.Rule != "team" || last.Scope != ScopeTeam || last.LimitDollars != 100 || last.Exceeded {
+		t.Errorf("Unexpected notification body: %+v", last)
+	}
+}
+
+func TestNotifier_RetriesFailedNotifications(t *testing.T) {
+	recorder := &webhookRecorder{status: http.StatusInternalServerError}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	config, err := Parse([]byte("webhook:\n  url: " + server.URL + "\nrules:\n  - {name: team, scope: team, limit_dollars: 100}\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	notifier := NewNotifier(config)
+	results := []Result{{Rule: &config.Rules[0], Utilization: 1}}
+	cycle := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if err := notifier.Notify(context.Background(), cycle, results); err == nil {
+		t.Fatal("Expected error from failing webhook")
+	}
+
+	recorder.mu.Lock()
+	recorder.status = 0
+	recorder.mu.Unlock()
+
+	if err := notifier.Notify(context.Background(), cycle, results); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if got := recorder.thresholds(); len(got) != 1 || got[0] != 1 {
+		t.Errorf("Expected the failed notification to be retried, got %v", got)
+	}
+}
+
+func TestNotifier_StateRoundTrip(t *testing.T) {
+	recorder := &webhookRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	config, err := Parse([]byte("webhook:\n  url: " + server.URL + "\nrules:\n  - {name: team, scope: team, limit_dollars: 100}\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	results := []Result{{Rule: &config.Rules[0], Utilization: 1}}
+	cycle := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	first := NewNotifier(config)
+	if err := first.Notify(context.Background(), cycle, results); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	data, err := json.Marshal(first.State())
+	if err != nil {
+		t.Fatalf("Failed to marshal state: %v", err)
+	}
+	var saved []SentState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("Failed to unmarshal state: %v", err)
+	}
+
+	second := NewNotifier(config)
+	second.Restore(saved)
+	if err := second.Notify(context.Background(), cycle, results); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	if got := recorder.thresholds(); len(got) != 1 {
+		t.Errorf("Expected restored notifier not to notify again, got %v", got)
+	}
+}
+
+func TestNewNotifier_WithoutWebhook(t *testing.T) {
+	if n := NewNotifier(&Config{}); n != nil {
+		t.Error("Expected nil notifier without a webhook")
+	}
+	var n *Notifier
+	if err := n.Notify(context.Background(), time.Now(), nil); err != nil {
+		t.Errorf("Expected nil notifier to do nothing, got %v", err)
+	}
+}
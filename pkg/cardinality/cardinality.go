@@ -0,0 +1,286 @@
+// Package cardinality caps the number of values a label may take in a
+// metric, so large teams and ever-changing model lists do not explode the
+// number of series.
+package cardinality
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/utils"
+)
+
+// Mode is how a Limit chooses the label values it keeps.
+type Mode string
+
+const (
+	// Top keeps the values whose series add up to the most, such as the
+	// heaviest users, and folds the others into Other.
+	Top Mode = "top"
+	// Latest keeps the greatest values in lexical order, such as the most
+	// recent dates, and drops the series of the others.
+	Latest Mode = "latest"
+)
+
+// Other replaces the label values folded by Top limits.
+const Other = "other"
+
+// FoldedMetric is the self-metric reporting how many label values each
+// limit folded or dropped.
+const FoldedMetric = "cursor_exporter_label_values_folded"
+
+// Limit caps the number of values of Label in the metrics matching Metric,
+// a metric name or a glob pattern such as "cursor_daily_user_*".
+type Limit struct {
+	Metric    string
+	Label     string
+	Mode      Mode
+	MaxValues int
+}
+
+// Limiter applies limits to gathered metrics. A nil Limiter applies none.
+type Limiter struct {
+	limits []Limit
+
+	mu sync.Mutex
+	// counters is the state of Top limits on counters, see counterState.
+	counters map[counterKey]*counterState
+}
+
+// counterKey identifies the state of a limit on one counter family, for one
+// set of labels passed to Apply, such as one team.
+type counterKey struct {
+	limit  int
+	family string
+	labels string
+}
+
+// counterState keeps the counter of every Other series monotonic. Summing
+// the folded series would make Other drop whenever a value moves back into
+// the top, which Prometheus reads as a counter reset. Instead, Other grows
+// by the increase of the series folded into it since the previous Apply.
+type counterState struct {
+	// last is the value of every series, by labels, before folding.
+	last map[string]float64
+	// other is the value of every Other series, by labels.
+	other map[string]float64
+}
+
+// New returns a Limiter applying limits. A metric matched by several limits
+// gets all of them, in order.
+func New(limits []Limit) (*Limiter, error) {
+	for i, limit := range limits {
+		if _, err := path.Match(limit.Metric, ""); err != nil || limit.Metric == "" {
+			return nil, fmt.Errorf("limit %d: invalid metric pattern %q", i+1, limit.Metric)
+		}
+		if limit.Label == "" {
+			return nil, fmt.Errorf("limit %d: label is required", i+1)
+		}
+		if limit.Mode != Top && limit.Mode != Latest {
+			return nil, fmt.Errorf("limit %d: mode must be %q or %q, got %q", i+1, Top, Latest, limit.Mode)
+		}
+		if limit.MaxValues <= 0 {
+			return nil, fmt.Errorf("limit %d: max_values must be positive", i+1)
+		}
+	}
+	return &Limiter{limits: limits, counters: make(map[counterKey]*counterState)}, nil
+}
+
+// Equal reports whether l and other apply the same limits. Both may be nil.
+func (l *Limiter) Equal(other *Limiter) bool {
+	if l == nil || other == nil {
+		return l == other
+	}
+	return slices.Equal(l.limits, other.limits)
+}
+
+// Apply applies the limits to families, in place, and appends FoldedMetric
+// with one series per matching metric and limited label, labelled with
+// labels in addition to the metric and label names. Empty label values are
+// never folded. Other series of counters never decrease, see counterState.
+func (l *Limiter) Apply(families []*dto.MetricFamily, labels map[string]string) []*dto.MetricFamily {
+	if l == nil || len(l.limits) == 0 {
+		return families
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	labelsKey := make([]string, 0, len(labels))
+	for name, value := range labels {
+		labelsKey = append(labelsKey, name+"\x00"+value)
+	}
+	sort.Strings(labelsKey)
+
+	type key struct{ metric, label string }
+	counts := make(map[key]int)
+	var keys []key
+	for _, family := range families {
+		for i, limit := range l.limits {
+			if matched, _ := path.Match(limit.Metric, family.GetName()); !matched {
+				continue
+			}
+			k := key{family.GetName(), limit.Label}
+			if _, ok := counts[k]; !ok {
+				keys = append(keys, k)
+			}
+			var state *counterState
+			if limit.Mode == Top && family.GetType() == dto.MetricType_COUNTER {
+				state = l.counterState(counterKey{i, family.GetName(), strings.Join(labelsKey, "\x01")})
+			}
+			counts[k] += limit.apply(family, state)
+		}
+	}
+	if len(keys) == 0 {
+		return families
+	}
+
+	folded := &dto.MetricFamily{
+		Name: proto.String(FoldedMetric),
+		Help: proto.String("Label values folded into \"other\" or dropped by cardinality limits in the last scrape"),
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+	for _, k := range keys {
+		metricLabels := map[string]string{"metric": k.metric, "label": k.label}
+		for name, value := range labels {
+			metricLabels[name] = value
+		}
+		folded.Metric = append(folded.Metric, gauge(float64(counts[k]), metricLabels))
+	}
+	return append(families, folded)
+}
+
+func (l *Limiter) counterState(key counterKey) *counterState {
+	state, ok := l.counters[key]
+	if !ok {
+		state = &counterState{last: make(map[string]float64), other: make(map[string]float64)}
+		l.counters[key] = state
+	}
+	return state
+}
+
+// increases returns the increase of every series of metrics, by labels,
+// since the previous call, and remembers their values for the next one.
+// New series and series whose counter was reset increase by their value.
+func (s *counterState) increases(metrics []*dto.Metric) map[string]float64 {
+	increases := make(map[string]float64, len(metrics))
+	last := make(map[string]float64, len(metrics))
+	for _, metric := range metrics {
+		key := utils.LabelKey(metric.Label)
+		value := metric.GetCounter().GetValue()
+		if previous, ok := s.last[key]; ok && value >= previous {
+			increases[key] = value - previous
+		} else {
+			increases[key] = value
+		}
+		last[key] = value
+	}
+	s.last = last
+	return increases
+}
+
+// apply applies the limit to family and returns how many label values it
+// folded or dropped. state is nil unless family is a counter with a Top
+// limit.
+func (limit Limit) apply(family *dto.MetricFamily, state *counterState) int {
+	var increases map[string]float64
+	if state != nil {
+		increases = state.increases(family.Metric)
+	}
+
+	totals := make(map[string]float64)
+	for _, metric := range family.Metric {
+		if value := labelValue(metric, limit.Label); value != "" {
+			v, _ := utils.MetricValue(metric)
+			totals[value] += v
+		}
+	}
+	if len(totals) <= limit.MaxValues {
+		return 0
+	}
+
+	values := make([]string, 0, len(totals))
+	for value := range totals {
+		values = append(values, value)
+	}
+	if limit.Mode == Latest {
+		sort.Sort(sort.Reverse(sort.StringSlice(values)))
+	} else {
+		sort.Slice(values, func(i, j int) bool {
+			if totals[values[i]] != totals[values[j]] {
+				return totals[values[i]] > totals[values[j]]
+			}
+			return values[i] < values[j]
+		})
+	}
+	kept := make(map[string]bool, limit.MaxValues)
+	for _, value := range values[:limit.MaxValues] {
+		kept[value] = true
+	}
+
+	metrics := family.Metric[:0]
+	for _, metric := range family.Metric {
+		value := labelValue(metric, limit.Label)
+		if value == "" || kept[value] {
+			metrics = append(metrics, metric)
+			continue
+		}
+		if limit.Mode == Latest {
+			continue
+		}
+		if _, ok := utils.MetricValue(metric); !ok {
+			// Only counters, gauges and untyped metrics can be summed.
+			continue
+		}
+		var increase float64
+		if state != nil {
+			increase = increases[utils.LabelKey(metric.Label)]
+		}
+		setLabel(metric, limit.Label, Other)
+		if state != nil {
+			state.other[utils.LabelKey(metric.Label)] += increase
+		}
+		metrics = append(metrics, metric)
+	}
+	family.Metric = utils.MergeMetrics(metrics, utils.Sum)
+	if state != nil {
+		for _, metric := range family.Metric {
+			if labelValue(metric, limit.Label) == Other {
+				metric.Counter.Value = proto.Float64(state.other[utils.LabelKey(metric.Label)])
+			}
+		}
+	}
+	return len(values) - limit.MaxValues
+}
+
+func labelValue(metric *dto.Metric, name string) string {
+	for _, label := range metric.Label {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
+
+func setLabel(metric *dto.Metric, name, value string) {
+	for _, label := range metric.Label {
+		if label.GetName() == name {
+			label.Value = proto.String(value)
+		}
+	}
+}
+
+func gauge(value float64, labels map[string]string) *dto.Metric {
+	metric := &dto.Metric{Gauge: &dto.Gauge{Value: proto.Float64(value)}}
+	for name, value := range labels {
+		metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	}
+	utils.SortLabels(metric.Label)
+	return metric
+}
@@ -0,0 +1,209 @@
+package cardinality
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func counter(value float64, labels ...string) *dto.Metric {
+	metric := &dto.Metric{Counter: &dto.Counter{Value: proto.Float64(value)}}
+	for i := 0; i < len(labels); i += 2 {
+		metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(labels[i]), Value: proto.String(labels[i+1])})
+	}
+	return metric
+}
+
+func family(name string, metrics ...*dto.Metric) *dto.MetricFamily {
+	return &dto.MetricFamily{Name: proto.String(name), Type: dto.MetricType_COUNTER.Enum(), Metric: metrics}
+}
+
+// values maps the value of label to the metric value in f.
+func values(f *dto.MetricFamily, label string) map[string]float64 {
+	values := make(map[string]float64)
+	for _, metric := range f.Metric {
+		for _, pair := range metric.Label {
+			if pair.GetName() == label {
+				values[pair.GetValue()] += metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return values
+}
+
+// folded returns the value of the folded self-metric for metric.
+func folded(t *testing.T, families []*dto.MetricFamily, metric string) float64 {
+	t.Helper()
+	for _, f := range families {
+		if f.GetName() != FoldedMetric {
+			continue
+		}
+		for _, m := range f.Metric {
+			for _, pair := range m.Label {
+				if pair.GetName() == "metric" && pair.GetValue() == metric {
+					return m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	t.Fatalf("Expected %s for %s", FoldedMetric, metric)
+	return 0
+}
+
+func TestNew_Invalid(t *testing.T) {
+	for _, limit := range []Limit{
+		{Metric: "[", Label: "user_email", Mode: Top, MaxValues: 1},
+		{Metric: "cursor_*", Mode: Top, MaxValues: 1},
+		{Metric: "cursor_*", Label: "date", Mode: "oldest", MaxValues: 1},
+		{Metric: "cursor_*", Label: "date", Mode: Latest},
+	} {
+		if _, err := New([]Limit{limit}); err == nil {
+			t.Errorf("Expected an error for %+v", limit)
+		}
+	}
+}
+
+func TestLimiter_Apply_Top(t *testing.T) {
+	limiter, err := New([]Limit{{Metric: "cursor_tokens_consumed_by_user_total", Label: "user_email", Mode: Top, MaxValues: 2}})
+	if err != nil {
+		t.Fatalf("Failed to create limiter: %v", err)
+	}
+
+	tokens := family("cursor_tokens_consumed_by_user_total",
+		counter(100, "token_type", "input", "user_email", "alice@example.com"),
+		counter(50, "token_type", "output", "user_email", "alice@example.com"),
+		counter(80, "token_type", "input", "user_email", "bob@example.com"),
+		counter(10, "token_type", "input", "user_email", "carol@example.com"),
+		counter(5, "token_type", "input", "user_email", "dave@example.com"),
+		counter(7, "token_type", "output", "user_email", "dave@example.com"),
+	)
+	families := limiter.Apply([]*dto.MetricFamily{tokens, family("cursor_usage_events_total", counter(3))}, map[string]string{"team": "sales"})
+
+	got := values(tokens, "user_email")
+	want := map[string]float64{"alice@example.com": 150, "bob@example.com": 80, Other: 22}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("Expected %s=%v, got %v", k, v, got[k])
+		}
+	}
+	if len(tokens.Metric) != 5 {
+		t.Errorf("Expected the folded series to be merged by token type, got %d series", len(tokens.Metric))
+	}
+
+	if got := folded(t, families, "cursor_tokens_consumed_by_user_total"); got != 2 {
+		t.Errorf("Expected 2 folded values, got %v", got)
+	}
+	for _, f := range families {
+		if f.GetName() == FoldedMetric && len(f.Metric) != 1 {
+			t.Errorf("Expected the folded metric only for the limited metric, got %d series", len(f.Metric))
+		}
+		if f.GetName() == FoldedMetric && f.Metric[0].Label[2].GetValue() != "sales" {
+			t.Errorf("Expected the team label on the folded metric, got %v", f.Metric[0].Label)
+		}
+	}
+}
+
+func TestLimiter_Apply_TopCountersNeverDecrease(t *testing.T) {
+	limiter, err := New([]Limit{{Metric: "cursor_usage_events_by_user_total", Label: "user_email", Mode: Top, MaxValues: 2}})
+	if err != nil {
+		t.Fatalf("Failed to create limiter: %v", err)
+	}
+	scrape := func(alice, bob, carol, dave float64) map[string]float64 {
+		events := family("cursor_usage_events_by_user_total",
+			counter(alice, "user_email", "alice@example.com"),
+			counter(bob, "user_email", "bob@example.com"),
+			counter(carol, "user_email", "carol@example.com"),
+			counter(dave, "user_email", "dave@example.com"),
+		)
+		limiter.Apply([]*dto.MetricFamily{events}, map[string]string{"team": "sales"})
+		return values(events, "user_email")
+	}
+
+	if got := scrape(100, 80, 10, 5); got[Other] != 15 {
+		t.Fatalf("Expected carol and dave folded into 15, got %v", got)
+	}
+	// carol moves into the top and bob is folded in her place: other only
+	// grows by the increase of the series folded into it.
+	got := scrape(100, 81, 200, 7)
+	if got[Other] != 18 {
+		t.Errorf("Expected other to grow from 15 by bob's and dave's increases to 18, got %v", got)
+	}
+	if got["carol@example.com"] != 200 || got["alice@example.com"] != 100 {
+		t.Errorf("Expected carol and alice in the top, got %v", got)
+	}
+
+	// Another team has its own state.
+	other := family("cursor_usage_events_by_user_total",
+		counter(3, "user_email", "erin@example.com"),
+		counter(2, "user_email", "frank@example.com"),
+		counter(1, "user_email", "grace@example.com"),
+	)
+	limiter.Apply([]*dto.MetricFamily{other}, map[string]string{"team": "support"})
+	if got := values(other, "user_email"); got[Other] != 1 {
+		t.Errorf("Expected the other team's other to start from its own values, got %v", got)
+	}
+}
+
+func TestLimiter_Equal(t *testing.T) {
+	limits := []Limit{{Metric: "cursor_*", Label: "user_email", Mode: Top, MaxValues: 1}}
+	a, _ := New(limits)
+	b, _ := New(limits)
+	c, _ := New([]Limit{{Metric: "cursor_*", Label: "user_email", Mode: Top, MaxValues: 2}})
+	var none *Limiter
+	if !a.Equal(b) || a.Equal(c) || a.Equal(none) || !none.Equal(nil) {
+		t.Error("Expected limiters to be equal exactly when their limits are")
+	}
+}
+
+func TestLimiter_Apply_Latest(t *testing.T) {
+	limiter, err := New([]Limit{{Metric: "cursor_daily_user_*", Label: "date", Mode: Latest, MaxValues: 2}})
+	if err != nil {
+		t.Fatalf("Failed to create limiter: %v", err)
+	}
+
+	lines := family("cursor_daily_user_lines_added_total",
+		counter(1, "date", "2025-01-01", "user_email", "alice@example.com"),
+		counter(2, "date", "2025-01-02", "user_email", "alice@example.com"),
+		counter(3, "date", "2025-01-03", "user_email", "alice@example.com"),
+		counter(4, "date", "2025-01-03", "user_email", "bob@example.com"),
+	)
+	families := limiter.Apply([]*dto.MetricFamily{lines}, nil)
+
+	got := values(lines, "date")
+	if len(got) != 2 || got["2025-01-02"] != 2 || got["2025-01-03"] != 7 {
+		t.Errorf("Expected only the two latest dates, got %v", got)
+	}
+	if got := folded(t, families, "cursor_daily_user_lines_added_total"); got != 1 {
+		t.Errorf("Expected 1 dropped value, got %v", got)
+	}
+}
+
+func TestLimiter_Apply_UnderLimit(t *testing.T) {
+	limiter, _ := New([]Limit{{Metric: "cursor_spending_by_member_cents", Label: "member_email", Mode: Top, MaxValues: 2}})
+
+	spending := family("cursor_spending_by_member_cents",
+		counter(100, "member_email", "alice@example.com"),
+		counter(50, "member_email", ""),
+		counter(10, "member_email", "bob@example.com"),
+	)
+	families := limiter.Apply([]*dto.MetricFamily{spending}, nil)
+
+	if len(spending.Metric) != 3 {
+		t.Errorf("Expected nothing folded under the limit, empty values aside, got %d series", len(spending.Metric))
+	}
+	if got := folded(t, families, "cursor_spending_by_member_cents"); got != 0 {
+		t.Errorf("Expected 0 folded values, got %v", got)
+	}
+}
+
+func TestLimiter_Apply_Nil(t *testing.T) {
+	var limiter *Limiter
+	families := []*dto.MetricFamily{family("cursor_usage_events_total", counter(1))}
+	if got := limiter.Apply(families, nil); len(got) != 1 {
+		t.Errorf("Expected a nil limiter to leave metrics unchanged, got %d families", len(got))
+	}
+}
@@ -2,7 +2,9 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -18,6 +20,24 @@ type CursorClient struct {
 	BaseURL    string
 	APIToken   string
 	HTTPClient *http.Client
+
+	// Token, if set, supplies the token for every request instead of
+	// APIToken, so the token can change while the client is in use.
+	Token TokenSource
+
+	// Retry controls how failed requests are retried.
+	Retry RetryPolicy
+
+	// OnRetry, if set, is called before every retry with the endpoint and
+	// one of the RetryReason constants.
+	OnRetry func(endpoint, reason string)
+
+	// RateLimiter, if set, delays requests to stay within the API rate
+	// limits. It applies to every attempt, including retries.
+	RateLimiter *RateLimiter
+
+	// OnThrottle, if set, is called whenever RateLimiter delays a request.
+	OnThrottle func(endpoint string)
 }
 
 type TeamMember struct {
@@ -38,6 +58,20 @@ type DailyUsage struct {
 	MostUsedExtension        string  `json:"most_used_extension"`
 }
 
+type UserDailyUsage struct {
+	Date              string `json:"date"`
+	UserEmail         string `json:"user_email"`
+	LinesAdded        int    `json:"lines_added"`
+	LinesDeleted      int    `json:"lines_deleted"`
+	Accepts           int    `json:"accepts"`
+	Rejects           int    `json:"rejects"`
+	TabsAccepted      int    `json:"tabs_accepted"`
+	ComposerRequests  int    `json:"composer_requests"`
+	ChatRequests      int    `json:"chat_requests"`
+	MostUsedModel     string `json:"most_used_model"`
+	MostUsedExtension string `json:"most_used_extension"`
+}
+
 type SpendingData struct {
 	MemberEmail     string `json:"member_email"`
 	SpendCents      int    `json:"spend_cents"`
@@ -46,11 +80,15 @@ type SpendingData struct {
 }
 
 type UsageEvent struct {
-	EventType      string    `json:"event_type"`
-	UserEmail      string    `json:"user_email"`
-	TokensConsumed int       `json:"tokens_consumed"`
-	Model          string    `json:"model"`
-	Timestamp      time.Time `json:"timestamp"`
+	EventType        string    `json:"event_type"`
+	UserEmail        string    `json:"user_email"`
+	TokensConsumed   int       `json:"tokens_consumed"`
+	InputTokens      int       `json:"input_tokens"`
+	OutputTokens     int       `json:"output_tokens"`
+	CacheWriteTokens int       `json:"cache_write_tokens"`
+	CacheReadTokens  int       `json:"cache_read_tokens"`
+	Model            string    `json:"model"`
+	Timestamp        time.Time `json:"timestamp"`
 }
 
 type TeamMembersResponse struct {
@@ -90,29 +128,113 @@ func NewCursorClient(baseURL, apiToken string) *CursorClient {
 		HTTPClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		Retry: DefaultRetryPolicy(),
 	}
 }
 
-func (c *CursorClient) makeRequest(method string, endpoint string, params url.Values, body io.Reader) ([]byte, error) {
+// makeRequest sends a request and returns the response body. Requests are
+// paced by c.RateLimiter, and those that fail with a rate limit, a server
+// error or a transport error are retried according to c.Retry.
+func (c *CursorClient) makeRequest(ctx context.Context, method string, endpoint string, params url.Values, body []byte) ([]byte, error) {
 	fullURL := fmt.Sprintf("%s%s", c.BaseURL, endpoint)
 
 	if params != nil {
 		fullURL = fmt.Sprintf("%s?%s", fullURL, params.Encode())
 	}
 
-	req, err := http.NewRequest(method, fullURL, body)
+	policy := c.Retry
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		throttled, err := c.RateLimiter.Wait(ctx, endpoint)
+		if throttled && c.OnThrottle != nil {
+			c.OnThrottle(endpoint)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		token, err := c.token()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, respBody, err := c.doRequest(ctx, method, fullURL, token, body)
+
+		var reason string
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			reason = RetryReasonTransportError
+		case resp.StatusCode == http.StatusOK:
+			return respBody, nil
+		default:
+			err = newAPIError(method, endpoint, resp, respBody)
+			reason = retryReason(resp.StatusCode)
+		}
+
+		if reason == "" || attempt >= policy.MaxAttempts {
+			return nil, err
+		}
+
+		wait := policy.backoff(attempt)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			if apiErr.RetryAfter > policy.MaxBackoff {
+				return nil, fmt.Errorf("%w (Retry-After %s exceeds the maximum backoff)", err, apiErr.RetryAfter)
+			}
+			wait = apiErr.RetryAfter
+		}
+
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"endpoint": endpoint,
+			"attempt":  attempt,
+			"reason":   reason,
+			"wait":     wait,
+		}).Debug("Retrying API request")
+
+		if c.OnRetry != nil {
+			c.OnRetry(endpoint, reason)
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// token returns the token to send with the next request.
+func (c *CursorClient) token() (string, error) {
+	if c.Token == nil {
+		return c.APIToken, nil
+	}
+	return c.Token.Token()
+}
+
+// doRequest sends a single attempt of a request. The body is sent from the
+// start on every attempt.
+func (c *CursorClient) doRequest(ctx context.Context, method, fullURL, token string, body []byte) (*http.Response, []byte, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, fullURL, bodyReader)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.APIToken))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	req.Header.Set("Content-Type", "application/json")
 
 	logrus.WithField("url", fullURL).Debug("Making API request")
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return nil, nil, fmt.Errorf("failed to make request: %w", err)
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
@@ -122,18 +244,20 @@ func (c *CursorClient) makeRequest(method string, endpoint string, params url.Va
 
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
-	}
-
-	return bodyBytes, nil
+	return resp, bodyBytes, nil
 }
 
 func (c *CursorClient) GetTeamMembers() ([]TeamMember, error) {
-	body, err := c.makeRequest("GET", "/teams/members", nil, nil)
+	return c.GetTeamMembersContext(context.Background())
+}
+
+// GetTeamMembersContext is like GetTeamMembers but aborts the request when ctx
+// is done.
+func (c *CursorClient) GetTeamMembersContext(ctx context.Context) ([]TeamMember, error) {
+	body, err := c.makeRequest(ctx, "GET", "/teams/members", nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get team members: %w", err)
 	}
@@ -149,6 +273,31 @@ func (c *CursorClient) GetTeamMembers() ([]TeamMember, error) {
 }
 
 func (c *CursorClient) GetDailyUsage(startDate, endDate string) ([]DailyUsage, error) {
+	return c.GetDailyUsageContext(context.Background(), startDate, endDate)
+}
+
+// GetDailyUsageContext is like GetDailyUsage but aborts the request when ctx
+// is done.
+func (c *CursorClient) GetDailyUsageContext(ctx context.Context, startDate, endDate string) ([]DailyUsage, error) {
+	userUsage, err := c.GetUserDailyUsageContext(ctx, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	return AggregateDailyUsage(userUsage), nil
+}
+
+// GetUserDailyUsage returns one record per user and day. Rows reported more
+// than once for the same user and day are summed, and their most used model
+// and extension are taken from the row with the most requests and tab
+// completions respectively.
+func (c *CursorClient) GetUserDailyUsage(startDate, endDate string) ([]UserDailyUsage, error) {
+	return c.GetUserDailyUsageContext(context.Background(), startDate, endDate)
+}
+
+// GetUserDailyUsageContext is like GetUserDailyUsage but aborts the request
+// when ctx is done.
+func (c *CursorClient) GetUserDailyUsageContext(ctx context.Context, startDate, endDate string) ([]UserDailyUsage, error) {
 	startT, err := time.Parse("2006-01-02", startDate)
 	if err != nil {
 		return nil, fmt.Errorf("invalid start date: %w", err)
@@ -172,7 +321,7 @@ func (c *CursorClient) GetDailyUsage(startDate, endDate string) ([]DailyUsage, e
 		return nil, fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
-	body, err := c.makeRequest("POST", "/teams/daily-usage-data", nil, bytes.NewReader(reqJson))
+	body, err := c.makeRequest(ctx, "POST", "/teams/daily-usage-data", nil, reqJson)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get daily usage: %w", err)
 	}
@@ -180,6 +329,7 @@ func (c *CursorClient) GetDailyUsage(startDate, endDate string) ([]DailyUsage, e
 	var response struct {
 		Data []struct {
 			Date                 int64  `json:"date"`
+			Email                string `json:"email"`
 			TotalLinesAdded      int    `json:"totalLinesAdded"`
 			TotalLinesDeleted    int    `json:"totalLinesDeleted"`
 			TotalAccepts         int    `json:"totalAccepts"`
@@ -195,28 +345,89 @@ func (c *CursorClient) GetDailyUsage(startDate, endDate string) ([]DailyUsage, e
 		return nil, fmt.Errorf("failed to unmarshal daily usage response: %w", err)
 	}
 
-	aggMap := make(map[string]*aggregatedData)
+	type userDay struct {
+		date  string
+		email string
+	}
+
+	// picked holds the request and tab counts of the rows the most used model
+	// and extension were taken from.
+	type picked struct {
+		requests int
+		tabs     int
+	}
+
+	byUserDay := make(map[userDay]*UserDailyUsage)
+	pickedFrom := make(map[userDay]*picked)
 	for _, d := range response.Data {
-		dateStr := time.UnixMilli(d.Date).Format("2006-01-02")
-		if _, ok := aggMap[dateStr]; !ok {
-			aggMap[dateStr] = &aggregatedData{
+		key := userDay{
+			date:  time.UnixMilli(d.Date).Format("2006-01-02"),
+			email: d.Email,
+		}
+		u, ok := byUserDay[key]
+		if !ok {
+			u = &UserDailyUsage{Date: key.date, UserEmail: key.email}
+			byUserDay[key] = u
+			pickedFrom[key] = &picked{requests: -1, tabs: -1}
+		}
+		u.LinesAdded += d.TotalLinesAdded
+		u.LinesDeleted += d.TotalLinesDeleted
+		u.Accepts += d.TotalAccepts
+		u.Rejects += d.TotalRejects
+		u.TabsAccepted += d.TotalTabsAccepted
+		u.ComposerRequests += d.ComposerRequests
+		u.ChatRequests += d.ChatRequests
+		// Ties go to the lesser name, so the result does not depend on the
+		// order of the rows.
+		p := pickedFrom[key]
+		requests := d.ComposerRequests + d.ChatRequests
+		if d.MostUsedModel != "" && (requests > p.requests ||
+			requests == p.requests && d.MostUsedModel < u.MostUsedModel) {
+			u.MostUsedModel, p.requests = d.MostUsedModel, requests
+		}
+		if d.TabMostUsedExtension != "" && (d.TotalTabsAccepted > p.tabs ||
+			d.TotalTabsAccepted == p.tabs && d.TabMostUsedExtension < u.MostUsedExtension) {
+			u.MostUsedExtension, p.tabs = d.TabMostUsedExtension, d.TotalTabsAccepted
+		}
+	}
+
+	usage := make([]UserDailyUsage, 0, len(byUserDay))
+	for _, u := range byUserDay {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Date != usage[j].Date {
+			return usage[i].Date < usage[j].Date
+		}
+		return usage[i].UserEmail < usage[j].UserEmail
+	})
+
+	return usage, nil
+}
+
+// AggregateDailyUsage folds per-user records into team-wide totals per day.
+func AggregateDailyUsage(userUsage []UserDailyUsage) []DailyUsage {
+	aggMap := make(map[string]*aggregatedData)
+	for _, d := range userUsage {
+		if _, ok := aggMap[d.Date]; !ok {
+			aggMap[d.Date] = &aggregatedData{
 				modelCounts:     make(map[string]int),
 				extensionCounts: make(map[string]int),
 			}
 		}
-		agg := aggMap[dateStr]
-		agg.linesAdded += d.TotalLinesAdded
-		agg.linesDeleted += d.TotalLinesDeleted
-		agg.totalAccepts += d.TotalAccepts
-		agg.totalRejects += d.TotalRejects
-		agg.tabsUsed += d.TotalTabsAccepted
+		agg := aggMap[d.Date]
+		agg.linesAdded += d.LinesAdded
+		agg.linesDeleted += d.LinesDeleted
+		agg.totalAccepts += d.Accepts
+		agg.totalRejects += d.Rejects
+		agg.tabsUsed += d.TabsAccepted
 		agg.composerUsed += d.ComposerRequests
 		agg.chatRequests += d.ChatRequests
 		if d.MostUsedModel != "" {
 			agg.modelCounts[d.MostUsedModel]++
 		}
-		if d.TabMostUsedExtension != "" {
-			agg.extensionCounts[d.TabMostUsedExtension]++
+		if d.MostUsedExtension != "" {
+			agg.extensionCounts[d.MostUsedExtension]++
 		}
 	}
 
@@ -234,24 +445,6 @@ func (c *CursorClient) GetDailyUsage(startDate, endDate string) ([]DailyUsage, e
 			rate = float64(agg.totalAccepts) / float64(totalSuggestions)
 		}
 
-		var mostModel string
-		maxModel := 0
-		for m, count := range agg.modelCounts {
-			if count > maxModel {
-				maxModel = count
-				mostModel = m
-			}
-		}
-
-		var mostExt string
-		maxExt := 0
-		for e, count := range agg.extensionCounts {
-			if count > maxExt {
-				maxExt = count
-				mostExt = e
-			}
-		}
-
 		usage = append(usage, DailyUsage{
 			Date:                     date,
 			LinesAdded:               agg.linesAdded,
@@ -260,15 +453,35 @@ func (c *CursorClient) GetDailyUsage(startDate, endDate string) ([]DailyUsage, e
 			TabsUsed:                 agg.tabsUsed,
 			ComposerUsed:             agg.composerUsed,
 			ChatRequests:             agg.chatRequests,
-			MostUsedModel:            mostModel,
-			MostUsedExtension:        mostExt,
+			MostUsedModel:            mostCounted(agg.modelCounts),
+			MostUsedExtension:        mostCounted(agg.extensionCounts),
 		})
 	}
 
-	return usage, nil
+	return usage
+}
+
+// mostCounted returns the key with the highest count, breaking ties by name so
+// the result is stable across scrapes.
+func mostCounted(counts map[string]int) string {
+	var most string
+	maxCount := 0
+	for key, count := range counts {
+		if count > maxCount || (count == maxCount && key < most) {
+			maxCount = count
+			most = key
+		}
+	}
+	return most
 }
 
 func (c *CursorClient) GetSpending(limit int, offset int) ([]SpendingData, error) {
+	return c.GetSpendingContext(context.Background(), limit, offset)
+}
+
+// GetSpendingContext is like GetSpending but stops paginating and aborts the
+// request in flight when ctx is done.
+func (c *CursorClient) GetSpendingContext(ctx context.Context, limit int, offset int) ([]SpendingData, error) {
 	var allSpending []SpendingData
 	page := 1
 	for {
@@ -284,7 +497,7 @@ func (c *CursorClient) GetSpending(limit int, offset int) ([]SpendingData, error
 			return nil, fmt.Errorf("failed to marshal request body: %w", err)
 		}
 
-		body, err := c.makeRequest("POST", "/teams/spend", nil, bytes.NewReader(reqJson))
+		body, err := c.makeRequest(ctx, "POST", "/teams/spend", nil, reqJson)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get spending data: %w", err)
 		}
@@ -323,6 +536,45 @@ func (c *CursorClient) GetSpending(limit int, offset int) ([]SpendingData, error
 }
 
 func (c *CursorClient) GetUsageEvents(userEmail string, limit int, offset int, startDate, endDate string) ([]UsageEvent, error) {
+	return c.GetUsageEventsContext(context.Background(), userEmail, limit, offset, startDate, endDate)
+}
+
+// GetUsageEventsContext is like GetUsageEvents but stops paginating and
+// aborts the request in flight when ctx is done.
+func (c *CursorClient) GetUsageEventsContext(ctx context.Context, userEmail string, limit int, offset int, startDate, endDate string) ([]UsageEvent, error) {
+	var startMs, endMs *int64
+	if startDate != "" {
+		sT, err := time.Parse("2006-01-02", startDate)
+		if err == nil {
+			ms := sT.UnixMilli()
+			startMs = &ms
+		}
+	}
+	if endDate != "" {
+		eT, err := time.Parse("2006-01-02", endDate)
+		if err == nil {
+			ms := eT.Add(24*time.Hour - time.Millisecond).UnixMilli()
+			endMs = &ms
+		}
+	}
+
+	return c.getUsageEvents(ctx, userEmail, limit, startMs, endMs)
+}
+
+// GetUsageEventsSince returns every usage event at or after since, with
+// millisecond precision, for incremental ingestion.
+func (c *CursorClient) GetUsageEventsSince(since time.Time, limit int) ([]UsageEvent, error) {
+	return c.GetUsageEventsSinceContext(context.Background(), since, limit)
+}
+
+// GetUsageEventsSinceContext is like GetUsageEventsSince but stops paginating
+// and aborts the request in flight when ctx is done.
+func (c *CursorClient) GetUsageEventsSinceContext(ctx context.Context, since time.Time, limit int) ([]UsageEvent, error) {
+	startMs := since.UnixMilli()
+	return c.getUsageEvents(ctx, "", limit, &startMs, nil)
+}
+
+func (c *CursorClient) getUsageEvents(ctx context.Context, userEmail string, limit int, startMs, endMs *int64) ([]UsageEvent, error) {
 	var allEvents []UsageEvent
 	page := 1
 	for {
@@ -333,32 +585,20 @@ func (c *CursorClient) GetUsageEvents(userEmail string, limit int, offset int, s
 			Page      int     `json:"page"`
 			PageSize  int     `json:"pageSize"`
 		}{
-			Page:     page,
-			PageSize: limit,
+			StartDate: startMs,
+			EndDate:   endMs,
+			Page:      page,
+			PageSize:  limit,
 		}
 		if userEmail != "" {
 			reqBody.Email = &userEmail
 		}
-		if startDate != "" {
-			sT, err := time.Parse("2006-01-02", startDate)
-			if err == nil {
-				ms := sT.UnixMilli()
-				reqBody.StartDate = &ms
-			}
-		}
-		if endDate != "" {
-			eT, err := time.Parse("2006-01-02", endDate)
-			if err == nil {
-				ms := eT.Add(24*time.Hour - time.Millisecond).UnixMilli()
-				reqBody.EndDate = &ms
-			}
-		}
 		reqJson, err := json.Marshal(reqBody)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal request body: %w", err)
 		}
 
-		body, err := c.makeRequest("POST", "/teams/filtered-usage-events", nil, bytes.NewReader(reqJson))
+		body, err := c.makeRequest(ctx, "POST", "/teams/filtered-usage-events", nil, reqJson)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get usage events: %w", err)
 		}
@@ -392,18 +632,21 @@ func (c *CursorClient) GetUsageEvents(userEmail string, limit int, offset int, s
 			}
 			ts := time.UnixMilli(tsMs)
 
-			tokens := 0
+			event := UsageEvent{
+				EventType: e.KindLabel,
+				UserEmail: e.UserEmail,
+				Model:     e.Model,
+				Timestamp: ts,
+			}
 			if e.TokenUsage != nil {
-				tokens = e.TokenUsage.InputTokens + e.TokenUsage.OutputTokens + e.TokenUsage.CacheReadTokens + e.TokenUsage.CacheWriteTokens
+				event.InputTokens = e.TokenUsage.InputTokens
+				event.OutputTokens = e.TokenUsage.OutputTokens
+				event.CacheWriteTokens = e.TokenUsage.CacheWriteTokens
+				event.CacheReadTokens = e.TokenUsage.CacheReadTokens
+				event.TokensConsumed = event.InputTokens + event.OutputTokens + event.CacheReadTokens + event.CacheWriteTokens
 			}
 
-			allEvents = append(allEvents, UsageEvent{
-				EventType:      e.KindLabel,
-				UserEmail:      e.UserEmail,
-				TokensConsumed: tokens,
-				Model:          e.Model,
-				Timestamp:      ts,
-			})
+			allEvents = append(allEvents, event)
 		}
 
 		if !response.Pagination.HasNextPage {
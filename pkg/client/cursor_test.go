@@ -1,10 +1,14 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -175,6 +179,74 @@ func TestCursorClient_GetDailyUsage(t *testing.T) {
 	}
 }
 
+func TestCursorClient_GetUserDailyUsage(t *testing.T) {
+	day := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		type row struct {
+			Date              int64  `json:"date"`
+			Email             string `json:"email"`
+			TotalLinesAdded   int    `json:"totalLinesAdded"`
+			TotalAccepts      int    `json:"totalAccepts"`
+			TotalRejects      int    `json:"totalRejects"`
+			TotalTabsAccepted int    `json:"totalTabsAccepted"`
+			ChatRequests      int    `json:"chatRequests"`
+			MostUsedModel     string `json:"mostUsedModel"`
+		}
+		response := struct {
+			Data []row `json:"data"`
+		}{
+			Data: []row{
+				{Date: day, Email: "jane@example.com", TotalLinesAdded: 40, TotalAccepts: 1, TotalRejects: 3, MostUsedModel: "claude-3"},
+				{Date: day, Email: "john@example.com", TotalLinesAdded: 100, TotalAccepts: 8, TotalRejects: 2, TotalTabsAccepted: 20, ChatRequests: 10, MostUsedModel: "gpt-4"},
+				{Date: day, Email: "john@example.com", TotalLinesAdded: 5, ChatRequests: 1, MostUsedModel: "o3"},
+			},
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Logf("Failed to encode response: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	client := NewCursorClient(server.URL, "test-token")
+	usage, err := client.GetUserDailyUsage("2023-01-01", "2023-01-01")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(usage) != 2 {
+		t.Fatalf("Expected 2 per-user records, got %d", len(usage))
+	}
+
+	if usage[0].UserEmail != "jane@example.com" || usage[1].UserEmail != "john@example.com" {
+		t.Errorf("Expected records sorted by email, got %s, %s", usage[0].UserEmail, usage[1].UserEmail)
+	}
+
+	john := usage[1]
+	if john.LinesAdded != 105 {
+		t.Errorf("Expected duplicate rows to be summed to 105 lines added, got %d", john.LinesAdded)
+	}
+	if john.ChatRequests != 11 {
+		t.Errorf("Expected 11 chat requests, got %d", john.ChatRequests)
+	}
+	if john.MostUsedModel != "gpt-4" {
+		t.Errorf("Expected the most used model of the row with the most requests, 'gpt-4', got %s", john.MostUsedModel)
+	}
+
+	team := AggregateDailyUsage(usage)
+	if len(team) != 1 {
+		t.Fatalf("Expected 1 aggregated record, got %d", len(team))
+	}
+	if team[0].LinesAdded != 145 {
+		t.Errorf("Expected 145 team lines added, got %d", team[0].LinesAdded)
+	}
+	if team[0].SuggestionAcceptanceRate != 9.0/14.0 {
+		t.Errorf("Expected acceptance rate %f, got %f", 9.0/14.0, team[0].SuggestionAcceptanceRate)
+	}
+}
+
 func TestCursorClient_GetSpending(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/teams/spend" {
@@ -360,6 +432,11 @@ func TestCursorClient_GetUsageEvents(t *testing.T) {
 	if events[0].TokensConsumed != 100 {
 		t.Errorf("Expected 100 tokens consumed, got %d", events[0].TokensConsumed)
 	}
+
+	if events[0].InputTokens != 50 || events[0].OutputTokens != 40 || events[0].CacheWriteTokens != 10 || events[0].CacheReadTokens != 0 {
+		t.Errorf("Expected token breakdown 50/40/10/0, got %d/%d/%d/%d",
+			events[0].InputTokens, events[0].OutputTokens, events[0].CacheWriteTokens, events[0].CacheReadTokens)
+	}
 }
 
 func TestCursorClient_ErrorHandling(t *testing.T) {
@@ -390,3 +467,39 @@ func TestCursorClient_ErrorHandling(t *testing.T) {
 		t.Error("Expected error for unauthorized request")
 	}
 }
+
+func TestCursorClient_ContextCancelsPagination(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 2 {
+			// The exporter is shutting down while the second page is in flight.
+			_, _ = io.Copy(io.Discard, r.Body)
+			cancel()
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"usageEvents":[{"timestamp":"1700000000000","model":"gpt-4","kindLabel":"Included","userEmail":"a@example.com"}],"pagination":{"hasNextPage":true}}`))
+	}))
+	defer server.Close()
+
+	client := NewCursorClient(server.URL, "test-token")
+
+	_, err := client.GetUsageEventsSinceContext(ctx, time.UnixMilli(0), 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("Expected pagination to stop after 2 requests, got %d", got)
+	}
+
+	if _, err := client.GetTeamMembersContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from a done context, got %v", err)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("Expected no request with a done context, got %d requests", got)
+	}
+}
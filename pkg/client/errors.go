@@ -0,0 +1,148 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Sentinel errors matched by *APIError through errors.Is, so callers can
+// react to a class of failure without inspecting status codes.
+var (
+	// ErrUnauthorized means the API token is missing, invalid or expired.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden means the API token lacks a permission the request needs.
+	ErrForbidden = errors.New("forbidden")
+	// ErrRateLimited means the API rejected the request with 429.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrServerError means the API failed with a 5xx status.
+	ErrServerError = errors.New("server error")
+)
+
+// maxErrorBodyLength caps how much of an unparsed error body is kept.
+const maxErrorBodyLength = 512
+
+// APIError is returned when the Cursor API answers with a non-200 status.
+type APIError struct {
+	StatusCode int
+	Method     string
+	Endpoint   string
+
+	// RequestID is the X-Request-Id response header, if any, for reporting
+	// problems to Cursor.
+	RequestID string
+
+	// Code and Message come from the JSON error body, when the API sent one.
+	Code    string
+	Message string
+
+	// Body is the raw response body, truncated, when it could not be parsed.
+	Body string
+
+	// RetryAfter is the wait the API asked for in a Retry-After header, or
+	// zero if it did not send one.
+	RetryAfter time.Duration
+}
+
+func newAPIError(method, endpoint string, resp *http.Response, body []byte) *APIError {
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		Method:     method,
+		Endpoint:   endpoint,
+		RequestID:  resp.Header.Get("X-Request-Id"),
+	}
+	if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		e.RetryAfter = retryAfter
+	}
+
+	e.Code, e.Message = parseErrorBody(body)
+	if e.Message == "" {
+		e.Body = strings.TrimSpace(string(body))
+		if len(e.Body) > maxErrorBodyLength {
+			e.Body = e.Body[:maxErrorBodyLength] + "..."
+		}
+	}
+	return e
+}
+
+// parseErrorBody understands the error shapes the API uses:
+// {"error": "message"}, {"message": "...", "code": "..."} and
+// {"error": {"message": "...", "code": "..."}}.
+func parseErrorBody(body []byte) (code, message string) {
+	var parsed struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+		Code    string          `json:"code"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return "", ""
+	}
+	code, message = parsed.Code, parsed.Message
+
+	var nested struct {
+		Message string `json:"message"`
+		Code    string `json:"code"`
+	}
+	var text string
+	switch {
+	case json.Unmarshal(parsed.Error, &text) == nil:
+		if message == "" {
+			message = text
+		} else if code == "" {
+			code = text
+		}
+	case json.Unmarshal(parsed.Error, &nested) == nil:
+		if nested.Message != "" {
+			message = nested.Message
+		}
+		if nested.Code != "" {
+			code = nested.Code
+		}
+	}
+	return code, message
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "API request %s %s failed with status %d", e.Method, e.Endpoint, e.StatusCode)
+	if e.RequestID != "" {
+		fmt.Fprintf(&b, " (request ID %s)", e.RequestID)
+	}
+
+	switch {
+	case e.Message != "" && e.Code != "":
+		fmt.Fprintf(&b, ": %s: %s", e.Code, e.Message)
+	case e.Message != "":
+		fmt.Fprintf(&b, ": %s", e.Message)
+	case e.Body != "":
+		fmt.Fprintf(&b, ": %s", e.Body)
+	}
+	return b.String()
+}
+
+// Is reports whether the status code of e belongs to the class of target,
+// one of ErrUnauthorized, ErrForbidden, ErrRateLimited or ErrServerError.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServerError:
+		return e.StatusCode >= 500
+	default:
+		return false
+	}
+}
+
+// IsAuthError reports whether err means the API token is not accepted or
+// not allowed to make the request. Retrying will not help until the token
+// or its permissions change.
+func IsAuthError(err error) bool {
+	return errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrForbidden)
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCursorClient_ReturnsAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "req-123")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error":{"code":"missing_scope","message":"Admin API access required"}}`))
+	}))
+	defer server.Close()
+
+	_, err := NewCursorClient(server.URL, "test-token").GetSpending(100, 0)
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected *APIError, got %T: %v", err, err)
+	}
+	if apiErr.StatusCode != http.StatusForbidden || apiErr.Method != "POST" || apiErr.Endpoint != "/teams/spend" {
+		t.Errorf("Unexpected request details: %+v", apiErr)
+	}
+	if apiErr.RequestID != "req-123" || apiErr.Code != "missing_scope" || apiErr.Message != "Admin API access required" {
+		t.Errorf("Unexpected error details: %+v", apiErr)
+	}
+
+	if !errors.Is(err, ErrForbidden) || !IsAuthError(err) {
+		t.Error("Expected error to match ErrForbidden and be an auth error")
+	}
+	if errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrRateLimited) || errors.Is(err, ErrServerError) {
+		t.Error("Expected error not to match other classes")
+	}
+
+	msg := err.Error()
+	for _, want := range []string{"/teams/spend", "403", "req-123", "Admin API access required"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("Expected error message to contain %q, got %q", want, msg)
+		}
+	}
+}
+
+func TestAPIError_Classes(t *testing.T) {
+	tests := []struct {
+		status int
+		target error
+	}{
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusForbidden, ErrForbidden},
+		{http.StatusTooManyRequests, ErrRateLimited},
+		{http.StatusInternalServerError, ErrServerError},
+		{http.StatusBadGateway, ErrServerError},
+	}
+
+	for _, tt := range tests {
+		err := error(&APIError{StatusCode: tt.status})
+		if !errors.Is(err, tt.target) {
+			t.Errorf("Expected status %d to match %v", tt.status, tt.target)
+		}
+	}
+
+	if errors.Is(&APIError{StatusCode: http.StatusNotFound}, ErrServerError) {
+		t.Error("Expected 404 not to be a server error")
+	}
+}
+
+func TestNewAPIError_Body(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		code    string
+		message string
+		raw     string
+	}{
+		{name: "error string", body: `{"error":"Invalid API key"}`, message: "Invalid API key"},
+		{name: "message and code", body: `{"message":"Too many requests","code":"rate_limited"}`, code: "rate_limited", message: "Too many requests"},
+		{name: "error code and message", body: `{"error":"unauthorized","message":"Token expired"}`, code: "unauthorized", message: "Token expired"},
+		{name: "nested error", body: `{"error":{"message":"Boom"}}`, message: "Boom"},
+		{name: "plain text", body: "Internal Server Error\n", raw: "Internal Server Error"},
+		{name: "long plain text", body: strings.Repeat("x", 600), raw: strings.Repeat("x", maxErrorBodyLength) + "..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: http.StatusInternalServerError, Header: http.Header{}}
+			e := newAPIError("GET", "/teams/members", resp, []byte(tt.body))
+			if e.Code != tt.code || e.Message != tt.message || e.Body != tt.raw {
+				t.Errorf("Got code=%q message=%q body=%q", e.Code, e.Message, e.Body)
+			}
+		})
+	}
+}
+
+func TestNewAPIError_RetryAfter(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"90"}}}
+	if e := newAPIError("POST", "/teams/spend", resp, nil); e.RetryAfter != 90*time.Second {
+		t.Errorf("Expected RetryAfter 90s, got %s", e.RetryAfter)
+	}
+}
@@ -0,0 +1,164 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is a request budget for a token bucket. A zero RequestsPerMinute
+// means unlimited.
+type RateLimit struct {
+	// RequestsPerMinute is the sustained request rate.
+	RequestsPerMinute float64
+
+	// Burst is how many requests can be sent at once after a quiet period.
+	// Values below 1 are treated as 1.
+	Burst int
+}
+
+// RateLimiter spreads API requests out so the exporter stays within the
+// Admin API rate limits. Every request takes a token from the bucket of its
+// endpoint, if it has one, and from the global bucket. A nil *RateLimiter
+// never waits.
+type RateLimiter struct {
+	global    *tokenBucket
+	endpoints map[string]*tokenBucket
+}
+
+// NewRateLimiter returns a limiter with a global budget shared by every
+// endpoint and optional tighter budgets for individual endpoints, keyed by
+// path such as "/teams/spend". It returns nil if no budget is limited.
+func NewRateLimiter(global RateLimit, endpoints map[string]RateLimit) *RateLimiter {
+	l := &RateLimiter{
+		global:    newTokenBucket(global),
+		endpoints: make(map[string]*tokenBucket),
+	}
+	for endpoint, limit := range endpoints {
+		if bucket := newTokenBucket(limit); bucket != nil {
+			l.endpoints[endpoint] = bucket
+		}
+	}
+
+	if l.global == nil && len(l.endpoints) == 0 {
+		return nil
+	}
+	return l
+}
+
+// Wait blocks until a request to endpoint may be sent. It reports whether
+// the request had to wait, and fails if ctx is done first. Both tokens are
+// reserved at once and handed back if ctx is done, so a request that is
+// never sent does not use up either budget.
+func (l *RateLimiter) Wait(ctx context.Context, endpoint string) (bool, error) {
+	if l == nil {
+		return false, nil
+	}
+
+	buckets := []*tokenBucket{l.endpoints[endpoint], l.global}
+	var delay time.Duration
+	for _, b := range buckets {
+		delay = max(delay, b.reserve())
+	}
+	if delay <= 0 {
+		return false, nil
+	}
+
+	if err := sleep(ctx, delay); err != nil {
+		// Hand the reserved tokens back for the callers queued behind us.
+		for _, b := range buckets {
+			b.cancel()
+		}
+		return true, err
+	}
+	return true, nil
+}
+
+type tokenBucket struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	if limit.RequestsPerMinute <= 0 {
+		return nil
+	}
+	burst := math.Max(1, float64(limit.Burst))
+	return &tokenBucket{
+		rate:   limit.RequestsPerMinute / 60,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token and returns how long to wait until it is available.
+// Tokens are reserved up front, so concurrent callers queue up in order.
+func (b *tokenBucket) reserve() time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel hands back a token taken by reserve.
+func (b *tokenBucket) cancel() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens++
+}
+
+// ParseEndpointRateLimits parses per-endpoint budgets written as a comma
+// separated list of endpoint=requests_per_minute[:burst], for example
+// "/teams/filtered-usage-events=20:5,/teams/spend=10".
+func ParseEndpointRateLimits(s string) (map[string]RateLimit, error) {
+	limits := make(map[string]RateLimit)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		endpoint, budget, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(endpoint, "/") {
+			return nil, fmt.Errorf("invalid rate limit %q: expected /endpoint=requests_per_minute[:burst]", entry)
+		}
+
+		rateStr, burstStr, hasBurst := strings.Cut(budget, ":")
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid rate limit %q: requests per minute must be a positive number", entry)
+		}
+
+		limit := RateLimit{RequestsPerMinute: rate}
+		if hasBurst {
+			if limit.Burst, err = strconv.Atoi(burstStr); err != nil || limit.Burst < 1 {
+				return nil, fmt.Errorf("invalid rate limit %q: burst must be a positive integer", entry)
+			}
+		}
+		limits[endpoint] = limit
+	}
+	return limits, nil
+}
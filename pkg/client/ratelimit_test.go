@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewRateLimiter_Unlimited(t *testing.T) {
+	if l := NewRateLimiter(RateLimit{}, nil); l != nil {
+		t.Error("Expected nil limiter without any budget")
+	}
+
+	var l *RateLimiter
+	throttled, err := l.Wait(context.Background(), "/teams/spend")
+	if throttled || err != nil {
+		t.Errorf("Expected nil limiter never to wait, got %v, %v", throttled, err)
+	}
+}
+
+func TestRateLimiter_Wait(t *testing.T) {
+	// 600 per minute is one token every 100ms.
+	l := NewRateLimiter(RateLimit{RequestsPerMinute: 600, Burst: 2}, nil)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if throttled, err := l.Wait(ctx, "/teams/spend"); throttled || err != nil {
+			t.Fatalf("Expected request %d to fit in the burst, got %v, %v", i+1, throttled, err)
+		}
+	}
+
+	start := time.Now()
+	throttled, err := l.Wait(ctx, "/teams/members")
+	if err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if !throttled {
+		t.Error("Expected the request after the burst to be throttled")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected to wait about 100ms for a token, waited %s", elapsed)
+	}
+}
+
+func TestRateLimiter_EndpointBudget(t *testing.T) {
+	l := NewRateLimiter(RateLimit{}, map[string]RateLimit{
+		"/teams/filtered-usage-events": {RequestsPerMinute: 1},
+	})
+	ctx := context.Background()
+
+	if throttled, _ := l.Wait(ctx, "/teams/filtered-usage-events"); throttled {
+		t.Error("Expected the first request to be sent at once")
+	}
+	for i := 0; i < 5; i++ {
+		if throttled, _ := l.Wait(ctx, "/teams/spend"); throttled {
+			t.Error("Expected endpoints without a budget not to be throttled")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	throttled, err := l.Wait(ctx, "/teams/filtered-usage-events")
+	if !throttled || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the second request to wait until the context expired, got %v, %v", throttled, err)
+	}
+}
+
+func TestRateLimiter_CancelledWaitReturnsTokens(t *testing.T) {
+	l := NewRateLimiter(RateLimit{RequestsPerMinute: 1}, map[string]RateLimit{
+		"/teams/spend": {RequestsPerMinute: 600, Burst: 1},
+	})
+	ctx := context.Background()
+
+	// Use up the global token so the next request waits on the global budget
+	// while holding an endpoint token.
+	if throttled, _ := l.Wait(ctx, "/teams/members"); throttled {
+		t.Fatal("Expected the first request to be sent at once")
+	}
+	cancelled, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Wait(cancelled, "/teams/spend"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the request to wait until the context expired, got %v", err)
+	}
+
+	if delay := l.endpoints["/teams/spend"].reserve(); delay > 0 {
+		t.Errorf("Expected the endpoint token of the cancelled request to be handed back, got a %s wait", delay)
+	}
+}
+
+func TestCursorClient_RateLimiterAppliesToRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"teamMembers":[]}`))
+	}))
+	defer server.Close()
+
+	client := NewCursorClient(server.URL, "test-token")
+	client.RateLimiter = NewRateLimiter(RateLimit{RequestsPerMinute: 1200}, nil)
+	var throttled []string
+	client.OnThrottle = func(endpoint string) {
+		throttled = append(throttled, endpoint)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.GetTeamMembers(); err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+	}
+
+	if len(throttled) != 2 || throttled[0] != "/teams/members" {
+		t.Errorf("Expected 2 throttled /teams/members requests, got %v", throttled)
+	}
+}
+
+func TestParseEndpointRateLimits(t *testing.T) {
+	limits, err := ParseEndpointRateLimits("/teams/filtered-usage-events=20:5, /teams/spend=10,")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if got := limits["/teams/filtered-usage-events"]; got.RequestsPerMinute != 20 || got.Burst != 5 {
+		t.Errorf("Unexpected usage events limit %+v", got)
+	}
+	if got := limits["/teams/spend"]; got.RequestsPerMinute != 10 || got.Burst != 0 {
+		t.Errorf("Unexpected spend limit %+v", got)
+	}
+
+	if limits, err := ParseEndpointRateLimits(""); err != nil || len(limits) != 0 {
+		t.Errorf("Expected no limits for an empty string, got %v, %v", limits, err)
+	}
+
+	for _, invalid := range []string{"teams/spend=10", "/teams/spend", "/teams/spend=fast", "/teams/spend=0", "/teams/spend=10:0"} {
+		if _, err := ParseEndpointRateLimits(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed API requests are retried. Every endpoint
+// the client calls only reads data, including the POST ones, so a request
+// can always be sent again.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts per request, including
+	// the first. 1 disables retries.
+	MaxAttempts int
+
+	// InitialBackoff is the upper bound of the wait before the first retry.
+	// It doubles for every further retry, up to MaxBackoff, and the actual
+	// wait is picked at random below it.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the wait between attempts. A Retry-After longer than
+	// MaxBackoff fails the request instead of waiting.
+	MaxBackoff time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+	}
+}
+
+// Retry reasons passed to CursorClient.OnRetry.
+const (
+	RetryReasonRateLimited    = "rate_limited"
+	RetryReasonServerError    = "server_error"
+	RetryReasonTransportError = "transport_error"
+)
+
+// retryReason returns why a response with status should be retried, or ""
+// if it should not.
+func retryReason(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return RetryReasonRateLimited
+	case status >= 500 && status != http.StatusNotImplemented:
+		return RetryReasonServerError
+	default:
+		return ""
+	}
+}
+
+// backoff returns the jittered wait before retry number retry, counting
+// from 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	ceiling := p.InitialBackoff
+	for i := 1; i < retry && ceiling < p.MaxBackoff; i++ {
+		ceiling *= 2
+	}
+	if ceiling > p.MaxBackoff {
+		ceiling = p.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as
+// an HTTP date. It reports false if the header is missing or invalid.
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		wait := date.Sub(now)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// sleep waits for d or until ctx is done, whichever comes first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newRetryTestClient(url string) *CursorClient {
+	client := NewCursorClient(url, "test-token")
+	client.Retry = RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	}
+	return client
+}
+
+func TestCursorClient_RetriesTransientErrors(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		attempt := len(bodies)
+		mu.Unlock()
+
+		switch attempt {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		case 2:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		default:
+			_, _ = w.Write([]byte(`{"teamMemberSpend":[{"spendCents":100,"email":"a@example.com"}],"totalPages":1}`))
+		}
+	}))
+	defer server.Close()
+
+	client := newRetryTestClient(server.URL)
+	var reasons []string
+	client.OnRetry = func(endpoint, reason string) {
+		if endpoint != "/teams/spend" {
+			t.Errorf("Expected endpoint /teams/spend, got %s", endpoint)
+		}
+		reasons = append(reasons, reason)
+	}
+
+	spending, err := client.GetSpending(100, 0)
+	if err != nil {
+		t.Fatalf("Expected the request to succeed after retries, got %v", err)
+	}
+	if len(spending) != 1 {
+		t.Errorf("Expected 1 spending record, got %d", len(spending))
+	}
+
+	if len(reasons) != 2 || reasons[0] != RetryReasonRateLimited || reasons[1] != RetryReasonServerError {
+		t.Errorf("Expected retries for [rate_limited server_error], got %v", reasons)
+	}
+	for i, body := range bodies {
+		if body != bodies[0] || !strings.Contains(body, `"page":1`) {
+			t.Errorf("Expected attempt %d to resend the same POST body, got %s", i+1, body)
+		}
+	}
+}
+
+func TestCursorClient_RetryGivesUp(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		attempts   int
+	}{
+		{name: "max attempts", status: http.StatusInternalServerError, attempts: 3},
+		{name: "client error", status: http.StatusUnauthorized, attempts: 1},
+		{name: "not implemented", status: http.StatusNotImplemented, attempts: 1},
+		{name: "retry after too long", status: http.StatusTooManyRequests, retryAfter: "3600", attempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				attempts++
+				mu.Unlock()
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				http.Error(w, "failed", tt.status)
+			}))
+			defer server.Close()
+
+			if _, err := newRetryTestClient(server.URL).GetTeamMembers(); err == nil {
+				t.Fatal("Expected error")
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if attempts != tt.attempts {
+				t.Errorf("Expected %d attempts, got %d", tt.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestCursorClient_RetryStopsWhenContextDone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := NewCursorClient(server.URL, "test-token")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := client.GetTeamMembersContext(ctx); err == nil {
+		t.Fatal("Expected error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the Retry-After wait to stop with the context, took %s", elapsed)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		header string
+		wait   time.Duration
+		ok     bool
+	}{
+		{header: "", ok: false},
+		{header: "120", wait: 2 * time.Minute, ok: true},
+		{header: "-1", ok: false},
+		{header: now.Add(30 * time.Second).Format(http.TimeFormat), wait: 30 * time.Second, ok: true},
+		{header: now.Add(-time.Minute).Format(http.TimeFormat), wait: 0, ok: true},
+		{header: "soon", ok: false},
+	}
+
+	for _, tt := range tests {
+		wait, ok := parseRetryAfter(tt.header, now)
+		if ok != tt.ok || wait != tt.wait {
+			t.Errorf("parseRetryAfter(%q) = %s, %v; expected %s, %v", tt.header, wait, ok, tt.wait, tt.ok)
+		}
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+
+	for retry, ceiling := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 300 * time.Millisecond,
+		8: 300 * time.Millisecond,
+	} {
+		for i := 0; i < 20; i++ {
+			if wait := policy.backoff(retry); wait < 0 || wait > ceiling {
+				t.Errorf("Expected retry %d to wait at most %s, got %s", retry, ceiling, wait)
+			}
+		}
+	}
+}
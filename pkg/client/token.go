@@ -0,0 +1,88 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TokenSource supplies the API token sent with every request. It must be
+// safe for concurrent use.
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// StaticToken is a TokenSource that always returns the same token.
+type StaticToken string
+
+func (t StaticToken) Token() (string, error) {
+	return string(t), nil
+}
+
+// FileTokenSource reads the API token from a file, such as a mounted
+// Kubernetes secret, and reads it again whenever the file changes so the
+// token can be rotated without a restart.
+type FileTokenSource struct {
+	path string
+
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+// NewFileTokenSource returns a FileTokenSource for the file at path, which
+// must contain a token. Surrounding whitespace is ignored.
+func NewFileTokenSource(path string) (*FileTokenSource, error) {
+	s := &FileTokenSource{path: path}
+	if _, err := s.Token(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Token returns the token in the file. If the file cannot be read or is
+// empty once a token has been read, for instance while a secret is being
+// replaced, the previous token is kept.
+func (s *FileTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return s.keep(fmt.Errorf("failed to read API token file: %w", err))
+	}
+	if s.token != "" && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.token, nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return s.keep(fmt.Errorf("failed to read API token file: %w", err))
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return s.keep(fmt.Errorf("API token file %s is empty", s.path))
+	}
+
+	if s.token != "" && token != s.token {
+		logrus.WithField("file", s.path).Info("API token file changed, using the new token")
+	}
+	s.token = token
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return s.token, nil
+}
+
+// keep returns the previous token, or err if no token has been read yet.
+func (s *FileTokenSource) keep(err error) (string, error) {
+	if s.token == "" {
+		return "", err
+	}
+	logrus.WithError(err).Warn("Failed to read API token file, keeping the previous token")
+	return s.token, nil
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeToken writes token to path and moves its modification time forward,
+// so rewrites within the file system's timestamp granularity are noticed.
+func writeToken(t *testing.T, path, token string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(token), 0o600); err != nil {
+		t.Fatalf("Failed to write token file: %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Failed to set token file time: %v", err)
+	}
+}
+
+func TestFileTokenSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	now := time.Now()
+	writeToken(t, path, "first-token\n", now)
+
+	source, err := NewFileTokenSource(path)
+	if err != nil {
+		t.Fatalf("Failed to create token source: %v", err)
+	}
+	if token, _ := source.Token(); token != "first-token" {
+		t.Errorf("Expected the trimmed token from the file, got %q", token)
+	}
+
+	writeToken(t, path, "second-token", now.Add(time.Second))
+	if token, _ := source.Token(); token != "second-token" {
+		t.Errorf("Expected the rotated token, got %q", token)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Failed to remove token file: %v", err)
+	}
+	if token, err := source.Token(); err != nil || token != "second-token" {
+		t.Errorf("Expected the previous token while the file is missing, got %q, %v", token, err)
+	}
+
+	writeToken(t, path, "  ", now.Add(2*time.Second))
+	if token, err := source.Token(); err != nil || token != "second-token" {
+		t.Errorf("Expected the previous token while the file is empty, got %q, %v", token, err)
+	}
+}
+
+func TestNewFileTokenSource_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewFileTokenSource(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected an error for a missing token file")
+	}
+
+	empty := filepath.Join(dir, "empty")
+	writeToken(t, empty, "\n", time.Now())
+	if _, err := NewFileTokenSource(empty); err == nil {
+		t.Error("Expected an error for an empty token file")
+	}
+}
+
+func TestCursorClient_TokenSource(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.Header.Get("Authorization")]++
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"teamMembers":[]}`))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "token")
+	now := time.Now()
+	writeToken(t, path, "old", now)
+	source, err := NewFileTokenSource(path)
+	if err != nil {
+		t.Fatalf("Failed to create token source: %v", err)
+	}
+
+	client := NewCursorClient(server.URL, "ignored")
+	client.Token = source
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := client.GetTeamMembers(); err != nil {
+					t.Errorf("Request failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	writeToken(t, path, "new-token", now.Add(time.Second))
+	if _, err := client.GetTeamMembers(); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if seen["Bearer old"] != 80 || seen["Bearer new-token"] != 1 {
+		t.Errorf("Expected 80 requests with the old token and 1 with the new one, got %v", seen)
+	}
+	if seen["Bearer ignored"] != 0 {
+		t.Error("Expected the token source to take precedence over APIToken")
+	}
+}
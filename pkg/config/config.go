@@ -0,0 +1,661 @@
+// Package config loads the exporter configuration from an optional YAML file
+// and environment variables.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/cardinality"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/exporters"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/otlp"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/privacy"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/utils"
+)
+
+// redacted replaces secrets in the output of Marshal.
+const redacted = "<redacted>"
+
+// Config is the effective configuration of the exporter.
+type Config struct {
+	Cursor      CursorConfig      `yaml:"cursor"`
+	Teams       []TeamConfig      `yaml:"teams,omitempty"`
+	Server      ServerConfig      `yaml:"server"`
+	LogLevel    string            `yaml:"log_level"`
+	Collectors  CollectorsConfig  `yaml:"collectors"`
+	State       StateConfig       `yaml:"state"`
+	Privacy     PrivacyConfig     `yaml:"privacy"`
+	Cardinality CardinalityConfig `yaml:"cardinality"`
+	OTLP        OTLPConfig        `yaml:"otlp"`
+
+	// PricingFile, BudgetsFile and DirectoryFile point to the model price
+	// table, the budget rules and the user directory. Each feature is
+	// disabled when its file is empty.
+	PricingFile   string `yaml:"pricing_file"`
+	BudgetsFile   string `yaml:"budgets_file"`
+	DirectoryFile string `yaml:"directory_file"`
+
+	// WatchInterval is how often the config, pricing, budgets and directory
+	// files are checked for changes to reload. Zero disables watching;
+	// SIGHUP still reloads.
+	WatchInterval time.Duration `yaml:"watch_interval"`
+}
+
+// CursorConfig configures access to the Cursor Admin API.
+type CursorConfig struct {
+	APIURL         string          `yaml:"api_url"`
+	APIToken       string          `yaml:"api_token"`
+	APITokenFile   string          `yaml:"api_token_file"`
+	RequestTimeout time.Duration   `yaml:"request_timeout"`
+	Retry          RetryConfig     `yaml:"retry"`
+	RateLimit      RateLimitConfig `yaml:"rate_limit"`
+}
+
+// TokenSource returns the source of the API token: the token file, re-read
+// whenever it changes, or else the token itself.
+func (c *CursorConfig) TokenSource() (client.TokenSource, error) {
+	return tokenSource(c.APIToken, c.APITokenFile)
+}
+
+// TeamConfig is one of several Cursor teams exported by a single exporter,
+// each with its own admin API token. Its metrics carry a team label with the
+// team's name.
+type TeamConfig struct {
+	Name         string `yaml:"name"`
+	APIToken     string `yaml:"api_token,omitempty"`
+	APITokenFile string `yaml:"api_token_file,omitempty"`
+}
+
+// TokenSource returns the source of the team's API token, like
+// CursorConfig.TokenSource.
+func (t *TeamConfig) TokenSource() (client.TokenSource, error) {
+	return tokenSource(t.APIToken, t.APITokenFile)
+}
+
+func tokenSource(token, file string) (client.TokenSource, error) {
+	if file != "" {
+		return client.NewFileTokenSource(file)
+	}
+	return client.StaticToken(token), nil
+}
+
+// teamNamePattern restricts team names to what is safe in file names, as
+// each team keeps its own state file.
+var teamNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// TeamList returns the teams to export. Without a teams section, that is a
+// single unnamed team using the token in the cursor section.
+func (c *Config) TeamList() []TeamConfig {
+	if len(c.Teams) > 0 {
+		return c.Teams
+	}
+	return []TeamConfig{{APIToken: c.Cursor.APIToken, APITokenFile: c.Cursor.APITokenFile}}
+}
+
+// StateFile returns the state file of the named team: the configured file
+// for a single unnamed team, and the team name inserted before its extension
+// otherwise, e.g. state.marketing.json.
+func (c *Config) StateFile(team string) string {
+	if c.State.File == "" || team == "" {
+		return c.State.File
+	}
+	ext := filepath.Ext(c.State.File)
+	return strings.TrimSuffix(c.State.File, ext) + "." + team + ext
+}
+
+// RetryConfig controls how failed Cursor API requests are retried.
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
+// RateLimitConfig is the client-side request budget shared by every
+// collector, with tighter budgets for individual endpoints keyed by path.
+type RateLimitConfig struct {
+	RequestsPerMinute float64              `yaml:"requests_per_minute"`
+	Burst             int                  `yaml:"burst"`
+	Endpoints         map[string]RateLimit `yaml:"endpoints,omitempty"`
+}
+
+// RateLimit is the request budget of a single endpoint.
+type RateLimit struct {
+	RequestsPerMinute float64 `yaml:"requests_per_minute"`
+	Burst             int     `yaml:"burst"`
+}
+
+// ServerConfig configures the HTTP server exposing the metrics.
+type ServerConfig struct {
+	ListenAddress string `yaml:"listen_address"`
+	MetricsPath   string `yaml:"metrics_path"`
+	// MetricsEnabled serves the metrics at MetricsPath. It can be disabled
+	// when metrics are only pushed over OTLP; the health endpoint is served
+	// either way.
+	MetricsEnabled bool `yaml:"metrics_enabled"`
+}
+
+// OTLPConfig configures pushing the metrics to an OpenTelemetry Collector
+// over OTLP. Pushing is disabled while Endpoint is empty.
+type OTLPConfig struct {
+	// Endpoint is the collector's host:port, or a URL.
+	Endpoint string `yaml:"endpoint"`
+	// Protocol is grpc or http/protobuf.
+	Protocol string            `yaml:"protocol"`
+	Interval time.Duration     `yaml:"interval"`
+	Timeout  time.Duration     `yaml:"timeout"`
+	Insecure bool              `yaml:"insecure"`
+	Headers  map[string]string `yaml:"headers,omitempty"`
+}
+
+// Options returns the options of the OTLP pusher.
+func (c *OTLPConfig) Options() otlp.Options {
+	return otlp.Options{
+		Endpoint: c.Endpoint,
+		Protocol: otlp.Protocol(c.Protocol),
+		Interval: c.Interval,
+		Timeout:  c.Timeout,
+		Insecure: c.Insecure,
+		Headers:  c.Headers,
+	}
+}
+
+func (c OTLPConfig) equal(other OTLPConfig) bool {
+	return c.Endpoint == other.Endpoint &&
+		c.Protocol == other.Protocol &&
+		c.Interval == other.Interval &&
+		c.Timeout == other.Timeout &&
+		c.Insecure == other.Insecure &&
+		maps.Equal(c.Headers, other.Headers)
+}
+
+// CollectorsConfig configures the collectors and how they are scheduled.
+type CollectorsConfig struct {
+	Concurrency int           `yaml:"concurrency"`
+	Timeout     time.Duration `yaml:"timeout"`
+
+	TeamMembers CollectorConfig `yaml:"team_members"`
+	DailyUsage  CollectorConfig `yaml:"daily_usage"`
+	Spending    CollectorConfig `yaml:"spending"`
+	UsageEvents CollectorConfig `yaml:"usage_events"`
+}
+
+// CollectorConfig configures a single collector. LookbackDays and PageSize
+// are only used by the collectors that support them.
+type CollectorConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Interval     time.Duration `yaml:"interval"`
+	LookbackDays int           `yaml:"lookback_days,omitempty"`
+	PageSize     int           `yaml:"page_size,omitempty"`
+}
+
+// Collector returns the configuration of the named collector, or nil if there
+// is no collector with that name.
+func (c *Config) Collector(name string) *CollectorConfig {
+	switch name {
+	case "team_members":
+		return &c.Collectors.TeamMembers
+	case "daily_usage":
+		return &c.Collectors.DailyUsage
+	case "spending":
+		return &c.Collectors.Spending
+	case "usage_events":
+		return &c.Collectors.UsageEvents
+	}
+	return nil
+}
+
+// StateConfig configures the state persisted across restarts. State is not
+// persisted when File is empty.
+type StateConfig struct {
+	File          string        `yaml:"file"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+// PrivacyConfig controls how user emails appear in metric labels. Identity
+// is one of the privacy modes: keep, hash, alias, domain or drop.
+type PrivacyConfig struct {
+	Identity string `yaml:"identity"`
+	// HashSalt or the content of HashSaltFile keys the hashes in hash mode.
+	HashSalt     string `yaml:"hash_salt,omitempty"`
+	HashSaltFile string `yaml:"hash_salt_file,omitempty"`
+	// Aliases maps emails to the value exported in alias mode. Other emails
+	// are exported as "other".
+	Aliases map[string]string `yaml:"aliases,omitempty"`
+}
+
+// Policy returns the privacy policy, reading the salt from HashSaltFile if
+// it is set.
+func (p *PrivacyConfig) Policy() (*privacy.Policy, error) {
+	salt := p.HashSalt
+	if p.HashSaltFile != "" {
+		data, err := os.ReadFile(p.HashSaltFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read hash salt file: %w", err)
+		}
+		if salt = strings.TrimSpace(string(data)); salt == "" {
+			return nil, fmt.Errorf("hash salt file %s is empty", p.HashSaltFile)
+		}
+	}
+	return privacy.NewPolicy(privacy.Mode(p.Identity), salt, p.Aliases)
+}
+
+// CardinalityConfig caps the number of values labels may take.
+type CardinalityConfig struct {
+	Limits []LimitConfig `yaml:"limits,omitempty"`
+}
+
+// LimitConfig caps the number of values of Label in the metrics matching
+// Metric, a name or glob pattern. Mode is top, the default, or latest.
+type LimitConfig struct {
+	Metric    string `yaml:"metric"`
+	Label     string `yaml:"label"`
+	Mode      string `yaml:"mode,omitempty"`
+	MaxValues int    `yaml:"max_values"`
+}
+
+// Limiter returns the limiter applying the configured limits, or nil if
+// there are none.
+func (c *CardinalityConfig) Limiter() (*cardinality.Limiter, error) {
+	if len(c.Limits) == 0 {
+		return nil, nil
+	}
+	limits := make([]cardinality.Limit, 0, len(c.Limits))
+	for _, limit := range c.Limits {
+		limits = append(limits, cardinality.Limit{
+			Metric:    limit.Metric,
+			Label:     limit.Label,
+			Mode:      limit.mode(),
+			MaxValues: limit.MaxValues,
+		})
+	}
+	return cardinality.New(limits)
+}
+
+func (l LimitConfig) mode() cardinality.Mode {
+	if l.Mode == "" {
+		return cardinality.Top
+	}
+	return cardinality.Mode(l.Mode)
+}
+
+// Default returns the configuration used when neither a file nor environment
+// variables set anything. Collector, retry and state settings default to
+// exporters.DefaultOptions.
+func Default() *Config {
+	opts := exporters.DefaultOptions()
+	cfg := &Config{
+		Cursor: CursorConfig{
+			APIURL:         "https://api.cursor.com",
+			RequestTimeout: opts.RequestTimeout,
+			Retry: RetryConfig{
+				MaxAttempts:    opts.Retry.MaxAttempts,
+				InitialBackoff: opts.Retry.InitialBackoff,
+				MaxBackoff:     opts.Retry.MaxBackoff,
+			},
+			RateLimit: RateLimitConfig{Burst: 1},
+		},
+		Server: ServerConfig{
+			ListenAddress:  ":8080",
+			MetricsPath:    "/metrics",
+			MetricsEnabled: true,
+		},
+		LogLevel: "info",
+		Collectors: CollectorsConfig{
+			Concurrency: opts.Concurrency,
+			Timeout:     opts.CollectorTimeout,
+		},
+		State:         StateConfig{FlushInterval: opts.StateFlushInterval},
+		Privacy:       PrivacyConfig{Identity: string(privacy.Keep)},
+		OTLP:          OTLPConfig{Protocol: string(otlp.GRPC), Interval: time.Minute, Timeout: 10 * time.Second},
+		WatchInterval: 30 * time.Second,
+	}
+	for _, name := range exporters.CollectorNames {
+		o := opts.Collector(name)
+		*cfg.Collector(name) = CollectorConfig{
+			Enabled:      o.Enabled,
+			Interval:     o.Interval,
+			LookbackDays: o.LookbackDays,
+			PageSize:     o.PageSize,
+		}
+	}
+	return cfg
+}
+
+// Load builds the configuration from the defaults, the YAML file at filename
+// if it is not empty, and environment variables, in increasing order of
+// precedence. The result is validated.
+func Load(filename string) (*Config, error) {
+	cfg, err := load(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// load is Load without validation.
+func load(filename string) (*Config, error) {
+	cfg := Default()
+
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := cfg.parse(data); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", filename, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Parse decodes a YAML configuration on top of the defaults and validates
+// it. Environment variables are not applied.
+func Parse(data []byte) (*Config, error) {
+	cfg := Default()
+	if err := cfg.parse(data); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) parse(data []byte) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// applyEnv overrides the configuration with the environment variables that
+// are set.
+func (c *Config) applyEnv() error {
+	c.Cursor.APIURL = utils.GetEnvWithDefault("CURSOR_API_URL", c.Cursor.APIURL)
+	c.Cursor.APIToken = utils.GetEnvWithDefault("CURSOR_API_TOKEN", c.Cursor.APIToken)
+	c.Cursor.APITokenFile = utils.GetEnvWithDefault("CURSOR_API_TOKEN_FILE", c.Cursor.APITokenFile)
+	c.Server.ListenAddress = utils.GetEnvWithDefault("LISTEN_ADDRESS", c.Server.ListenAddress)
+	c.Server.MetricsPath = utils.GetEnvWithDefault("METRICS_PATH", c.Server.MetricsPath)
+	c.LogLevel = utils.GetEnvWithDefault("LOG_LEVEL", c.LogLevel)
+	c.State.File = utils.GetEnvWithDefault("STATE_FILE", c.State.File)
+	c.PricingFile = utils.GetEnvWithDefault("PRICING_FILE", c.PricingFile)
+	c.BudgetsFile = utils.GetEnvWithDefault("BUDGETS_FILE", c.BudgetsFile)
+	c.DirectoryFile = utils.GetEnvWithDefault("DIRECTORY_FILE", c.DirectoryFile)
+	c.Privacy.Identity = utils.GetEnvWithDefault("PRIVACY_IDENTITY", c.Privacy.Identity)
+	c.Privacy.HashSalt = utils.GetEnvWithDefault("PRIVACY_HASH_SALT", c.Privacy.HashSalt)
+	c.Privacy.HashSaltFile = utils.GetEnvWithDefault("PRIVACY_HASH_SALT_FILE", c.Privacy.HashSaltFile)
+	c.OTLP.Endpoint = utils.GetEnvWithDefault("OTLP_ENDPOINT", c.OTLP.Endpoint)
+	c.OTLP.Protocol = utils.GetEnvWithDefault("OTLP_PROTOCOL", c.OTLP.Protocol)
+
+	var err error
+	for key, d := range map[string]*time.Duration{
+		"API_REQUEST_TIMEOUT":        &c.Cursor.RequestTimeout,
+		"API_RETRY_INITIAL_BACKOFF":  &c.Cursor.Retry.InitialBackoff,
+		"API_RETRY_MAX_BACKOFF":      &c.Cursor.Retry.MaxBackoff,
+		"COLLECTOR_TIMEOUT":          &c.Collectors.Timeout,
+		"TEAM_MEMBERS_POLL_INTERVAL": &c.Collectors.TeamMembers.Interval,
+		"DAILY_USAGE_POLL_INTERVAL":  &c.Collectors.DailyUsage.Interval,
+		"SPENDING_POLL_INTERVAL":     &c.Collectors.Spending.Interval,
+		"USAGE_EVENTS_POLL_INTERVAL": &c.Collectors.UsageEvents.Interval,
+		"STATE_FLUSH_INTERVAL":       &c.State.FlushInterval,
+		"CONFIG_WATCH_INTERVAL":      &c.WatchInterval,
+		"OTLP_INTERVAL":              &c.OTLP.Interval,
+		"OTLP_TIMEOUT":               &c.OTLP.Timeout,
+	} {
+		if *d, err = utils.GetDurationEnvWithDefault(key, *d); err != nil {
+			return err
+		}
+	}
+
+	for key, n := range map[string]*int{
+		"API_MAX_ATTEMPTS":           &c.Cursor.Retry.MaxAttempts,
+		"API_RATE_LIMIT_BURST":       &c.Cursor.RateLimit.Burst,
+		"COLLECTOR_CONCURRENCY":      &c.Collectors.Concurrency,
+		"DAILY_USAGE_LOOKBACK_DAYS":  &c.Collectors.DailyUsage.LookbackDays,
+		"USAGE_EVENTS_LOOKBACK_DAYS": &c.Collectors.UsageEvents.LookbackDays,
+		"SPENDING_PAGE_SIZE":         &c.Collectors.Spending.PageSize,
+		"USAGE_EVENTS_PAGE_SIZE":     &c.Collectors.UsageEvents.PageSize,
+	} {
+		if *n, err = utils.GetPositiveIntEnvWithDefault(key, *n); err != nil {
+			return err
+		}
+	}
+
+	for key, enabled := range map[string]*bool{
+		"TEAM_MEMBERS_ENABLED": &c.Collectors.TeamMembers.Enabled,
+		"DAILY_USAGE_ENABLED":  &c.Collectors.DailyUsage.Enabled,
+		"SPENDING_ENABLED":     &c.Collectors.Spending.Enabled,
+		"USAGE_EVENTS_ENABLED": &c.Collectors.UsageEvents.Enabled,
+		"METRICS_ENABLED":      &c.Server.MetricsEnabled,
+		"OTLP_INSECURE":        &c.OTLP.Insecure,
+	} {
+		if *enabled, err = utils.GetBoolEnvWithDefault(key, *enabled); err != nil {
+			return err
+		}
+	}
+
+	if c.Cursor.RateLimit.RequestsPerMinute, err = utils.GetNonNegativeFloatEnvWithDefault("API_RATE_LIMIT", c.Cursor.RateLimit.RequestsPerMinute); err != nil {
+		return err
+	}
+	if value := os.Getenv("API_ENDPOINT_RATE_LIMITS"); value != "" {
+		if c.Cursor.RateLimit.Endpoints, err = parseEndpointRateLimits(value); err != nil {
+			return fmt.Errorf("invalid API_ENDPOINT_RATE_LIMITS: %w", err)
+		}
+	}
+	if value := os.Getenv("CARDINALITY_LIMITS"); value != "" {
+		if c.Cardinality.Limits, err = parseLimits(value); err != nil {
+			return fmt.Errorf("invalid CARDINALITY_LIMITS: %w", err)
+		}
+	}
+	if value := os.Getenv("OTLP_HEADERS"); value != "" {
+		if c.OTLP.Headers, err = parseHeaders(value); err != nil {
+			return fmt.Errorf("invalid OTLP_HEADERS: %w", err)
+		}
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once, each prefixed with its
+// path in the YAML file.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, field, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, field+": "+fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Cursor.APIURL != "", "cursor.api_url", "is required")
+	if len(c.Teams) == 0 {
+		check(c.Cursor.APIToken != "" || c.Cursor.APITokenFile != "", "cursor.api_token", "is required, set it, api_token_file, CURSOR_API_TOKEN or CURSOR_API_TOKEN_FILE")
+		check(c.Cursor.APIToken == "" || c.Cursor.APITokenFile == "", "cursor.api_token_file", "cannot be combined with api_token")
+	} else {
+		check(c.Cursor.APIToken == "" && c.Cursor.APITokenFile == "", "cursor.api_token", "cannot be combined with teams, set the token of each team instead")
+	}
+	names := make(map[string]bool, len(c.Teams))
+	for i, team := range c.Teams {
+		field := fmt.Sprintf("teams[%d]", i)
+		check(teamNamePattern.MatchString(team.Name), field+".name", "must only contain letters, digits, _ and -, got %q", team.Name)
+		check(!names[team.Name], field+".name", "duplicates team %q", team.Name)
+		names[team.Name] = true
+		check(team.APIToken != "" || team.APITokenFile != "", field+".api_token", "is required, set it or api_token_file")
+		check(team.APIToken == "" || team.APITokenFile == "", field+".api_token_file", "cannot be combined with api_token")
+	}
+	check(c.Cursor.RequestTimeout > 0, "cursor.request_timeout", "must be positive, got %s", c.Cursor.RequestTimeout)
+	check(c.Cursor.Retry.MaxAttempts > 0, "cursor.retry.max_attempts", "must be positive, got %d", c.Cursor.Retry.MaxAttempts)
+	check(c.Cursor.Retry.InitialBackoff > 0, "cursor.retry.initial_backoff", "must be positive, got %s", c.Cursor.Retry.InitialBackoff)
+	check(c.Cursor.Retry.MaxBackoff >= c.Cursor.Retry.InitialBackoff, "cursor.retry.max_backoff", "must not be less than initial_backoff, got %s", c.Cursor.Retry.MaxBackoff)
+	check(c.Cursor.RateLimit.RequestsPerMinute >= 0, "cursor.rate_limit.requests_per_minute", "must not be negative, got %v", c.Cursor.RateLimit.RequestsPerMinute)
+	check(c.Cursor.RateLimit.Burst > 0, "cursor.rate_limit.burst", "must be positive, got %d", c.Cursor.RateLimit.Burst)
+	for _, endpoint := range sortedKeys(c.Cursor.RateLimit.Endpoints) {
+		limit := c.Cursor.RateLimit.Endpoints[endpoint]
+		field := fmt.Sprintf("cursor.rate_limit.endpoints[%q]", endpoint)
+		check(strings.HasPrefix(endpoint, "/"), field, "endpoint must start with /")
+		check(limit.RequestsPerMinute > 0, field+".requests_per_minute", "must be positive, got %v", limit.RequestsPerMinute)
+		check(limit.Burst >= 0, field+".burst", "must not be negative, got %d", limit.Burst)
+	}
+
+	check(c.Server.ListenAddress != "", "server.listen_address", "is required")
+	check(strings.HasPrefix(c.Server.MetricsPath, "/"), "server.metrics_path", "must start with /, got %q", c.Server.MetricsPath)
+	check(c.Server.MetricsEnabled || c.OTLP.Endpoint != "", "server.metrics_enabled", "cannot be false unless otlp.endpoint is set, or no metrics would be exported")
+	_, err := logrus.ParseLevel(c.LogLevel)
+	check(err == nil, "log_level", "must be one of panic, fatal, error, warn, info, debug or trace, got %q", c.LogLevel)
+
+	check(c.Collectors.Concurrency > 0, "collectors.concurrency", "must be positive, got %d", c.Collectors.Concurrency)
+	check(c.Collectors.Timeout > 0, "collectors.timeout", "must be positive, got %s", c.Collectors.Timeout)
+	for _, collector := range []struct {
+		name         string
+		config       CollectorConfig
+		lookbackDays bool
+		pageSize     bool
+	}{
+		{name: "team_members", config: c.Collectors.TeamMembers},
+		{name: "daily_usage", config: c.Collectors.DailyUsage, lookbackDays: true},
+		{name: "spending", config: c.Collectors.Spending, pageSize: true},
+		{name: "usage_events", config: c.Collectors.UsageEvents, lookbackDays: true, pageSize: true},
+	} {
+		field := "collectors." + collector.name
+		check(collector.config.Interval > 0, field+".interval", "must be positive, got %s", collector.config.Interval)
+		if collector.lookbackDays {
+			check(collector.config.LookbackDays > 0, field+".lookback_days", "must be positive, got %d", collector.config.LookbackDays)
+		} else {
+			check(collector.config.LookbackDays == 0, field+".lookback_days", "is not supported by this collector")
+		}
+		if collector.pageSize {
+			check(collector.config.PageSize > 0, field+".page_size", "must be positive, got %d", collector.config.PageSize)
+		} else {
+			check(collector.config.PageSize == 0, field+".page_size", "is not supported by this collector")
+		}
+	}
+
+	if c.State.File != "" {
+		check(c.State.FlushInterval > 0, "state.flush_interval", "must be positive, got %s", c.State.FlushInterval)
+	}
+
+	check(slices.Contains(privacy.Modes, privacy.Mode(c.Privacy.Identity)), "privacy.identity", "must be one of keep, hash, alias, domain or drop, got %q", c.Privacy.Identity)
+	if c.Privacy.Identity == string(privacy.Hash) {
+		check(c.Privacy.HashSalt != "" || c.Privacy.HashSaltFile != "", "privacy.hash_salt", "is required in hash mode, set it, hash_salt_file, PRIVACY_HASH_SALT or PRIVACY_HASH_SALT_FILE")
+	}
+	check(c.Privacy.HashSalt == "" || c.Privacy.HashSaltFile == "", "privacy.hash_salt_file", "cannot be combined with hash_salt")
+	if c.Privacy.Identity == string(privacy.Alias) {
+		check(len(c.Privacy.Aliases) > 0, "privacy.aliases", "is required in alias mode")
+	}
+
+	for i, limit := range c.Cardinality.Limits {
+		field := fmt.Sprintf("cardinality.limits[%d]", i)
+		_, err := path.Match(limit.Metric, "")
+		check(limit.Metric != "" && err == nil, field+".metric", "must be a metric name or glob pattern, got %q", limit.Metric)
+		check(limit.Label != "", field+".label", "is required")
+		mode := limit.mode()
+		check(mode == cardinality.Top || mode == cardinality.Latest, field+".mode", "must be top or latest, got %q", limit.Mode)
+		check(limit.MaxValues > 0, field+".max_values", "must be positive, got %d", limit.MaxValues)
+	}
+
+	if c.OTLP.Endpoint != "" {
+		check(slices.Contains(otlp.Protocols, otlp.Protocol(c.OTLP.Protocol)), "otlp.protocol", "must be grpc or http/protobuf, got %q", c.OTLP.Protocol)
+		check(c.OTLP.Interval > 0, "otlp.interval", "must be positive, got %s", c.OTLP.Interval)
+		check(c.OTLP.Timeout > 0, "otlp.timeout", "must be positive, got %s", c.OTLP.Timeout)
+	}
+
+	check(c.WatchInterval >= 0, "watch_interval", "must not be negative, got %s", c.WatchInterval)
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+}
+
+// RestartRequired returns the paths of the settings that differ between c
+// and next but cannot be applied without restarting the exporter.
+func (c *Config) RestartRequired(next *Config) []string {
+	var settings []string
+	for _, s := range []struct {
+		field   string
+		changed bool
+	}{
+		{"cursor.api_url", c.Cursor.APIURL != next.Cursor.APIURL},
+		{"cursor.api_token", c.Cursor.APIToken != next.Cursor.APIToken},
+		{"cursor.api_token_file", c.Cursor.APITokenFile != next.Cursor.APITokenFile},
+		{"teams", !slices.Equal(c.Teams, next.Teams)},
+		{"server.listen_address", c.Server.ListenAddress != next.Server.ListenAddress},
+		{"server.metrics_path", c.Server.MetricsPath != next.Server.MetricsPath},
+		{"server.metrics_enabled", c.Server.MetricsEnabled != next.Server.MetricsEnabled},
+		{"otlp", !c.OTLP.equal(next.OTLP)},
+		{"state.file", c.State.File != next.State.File},
+		{"state.flush_interval", c.State.FlushInterval != next.State.FlushInterval},
+		{"watch_interval", c.WatchInterval != next.WatchInterval},
+	} {
+		if s.changed {
+			settings = append(settings, s.field)
+		}
+	}
+	return settings
+}
+
+// Marshal encodes the configuration as YAML with secrets redacted.
+func (c *Config) Marshal() ([]byte, error) {
+	redactedConfig := *c
+	if redactedConfig.Cursor.APIToken != "" {
+		redactedConfig.Cursor.APIToken = redacted
+	}
+	redactedConfig.Teams = slices.Clone(c.Teams)
+	for i := range redactedConfig.Teams {
+		if redactedConfig.Teams[i].APIToken != "" {
+			redactedConfig.Teams[i].APIToken = redacted
+		}
+	}
+	if redactedConfig.Privacy.HashSalt != "" {
+		redactedConfig.Privacy.HashSalt = redacted
+	}
+	if len(c.OTLP.Headers) > 0 {
+		// Headers usually carry the collector's credentials.
+		redactedConfig.OTLP.Headers = make(map[string]string, len(c.OTLP.Headers))
+		for name := range c.OTLP.Headers {
+			redactedConfig.OTLP.Headers[name] = redacted
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&redactedConfig); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func sortedKeys(m map[string]RateLimit) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
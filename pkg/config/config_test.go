@@ -0,0 +1,466 @@
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/otlp"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(`
+cursor:
+  api_token: secret
+  rate_limit:
+    endpoints:
+      /teams/spend:
+        requests_per_minute: 10
+collectors:
+  spending:
+    interval: 2m
+    page_size: 250
+  usage_events:
+    enabled: false
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	if cfg.Collectors.Spending.Interval != 2*time.Minute || cfg.Collectors.Spending.PageSize != 250 {
+		t.Errorf("Expected spending interval 2m and page size 250, got %s and %d", cfg.Collectors.Spending.Interval, cfg.Collectors.Spending.PageSize)
+	}
+	if !cfg.Collectors.Spending.Enabled {
+		t.Error("Expected collectors to stay enabled unless disabled in the file")
+	}
+	if cfg.Collectors.UsageEvents.Enabled {
+		t.Error("Expected usage events to be disabled")
+	}
+	if cfg.Collectors.UsageEvents.PageSize != 5000 {
+		t.Errorf("Expected unset values to keep their default, got page size %d", cfg.Collectors.UsageEvents.PageSize)
+	}
+	if got := cfg.Cursor.RateLimit.Endpoints["/teams/spend"].RequestsPerMinute; got != 10 {
+		t.Errorf("Expected /teams/spend limit 10, got %v", got)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want []string
+	}{
+		{
+			name: "unknown field",
+			yaml: "cursor:\n  api_token: x\n  token: y\n",
+			want: []string{"field token not found"},
+		},
+		{
+			name: "missing token",
+			yaml: "log_level: info\n",
+			want: []string{"cursor.api_token: is required"},
+		},
+		{
+			name: "every problem is reported",
+			yaml: `
+cursor:
+  api_token: x
+  retry:
+    max_attempts: 0
+log_level: loud
+collectors:
+  timeout: -1s
+  team_members:
+    lookback_days: 7
+  daily_usage:
+    interval: 0s
+`,
+			want: []string{
+				"cursor.retry.max_attempts: must be positive, got 0",
+				`log_level: must be one of panic, fatal, error, warn, info, debug or trace, got "loud"`,
+				"collectors.timeout: must be positive, got -1s",
+				"collectors.team_members.lookback_days: is not supported by this collector",
+				"collectors.daily_usage.interval: must be positive, got 0s",
+			},
+		},
+		{
+			name: "invalid endpoint rate limit",
+			yaml: "cursor:\n  api_token: x\n  rate_limit:\n    endpoints:\n      teams/spend:\n        requests_per_minute: 0\n",
+			want: []string{
+				`cursor.rate_limit.endpoints["teams/spend"]: endpoint must start with /`,
+				`cursor.rate_limit.endpoints["teams/spend"].requests_per_minute: must be positive, got 0`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.yaml))
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Expected error to contain %q, got:\n%v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad_EnvOverridesFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(filename, []byte("cursor:\n  api_token: from-file\ncollectors:\n  spending:\n    interval: 2m\n    enabled: false\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	t.Setenv("CURSOR_API_TOKEN", "from-env")
+	t.Setenv("SPENDING_POLL_INTERVAL", "90s")
+	t.Setenv("API_ENDPOINT_RATE_LIMITS", "/teams/spend=5:2")
+
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Cursor.APIToken != "from-env" {
+		t.Errorf("Expected the token from the environment, got %q", cfg.Cursor.APIToken)
+	}
+	if cfg.Collectors.Spending.Interval != 90*time.Second {
+		t.Errorf("Expected the interval from the environment, got %s", cfg.Collectors.Spending.Interval)
+	}
+	if cfg.Collectors.Spending.Enabled {
+		t.Error("Expected the file to disable spending when the environment does not say otherwise")
+	}
+	if got := cfg.Cursor.RateLimit.Endpoints["/teams/spend"]; got != (RateLimit{RequestsPerMinute: 5, Burst: 2}) {
+		t.Errorf("Expected /teams/spend=5:2 from the environment, got %+v", got)
+	}
+}
+
+func TestLoad_InvalidEnv(t *testing.T) {
+	t.Setenv("CURSOR_API_TOKEN", "token")
+	t.Setenv("COLLECTOR_CONCURRENCY", "many")
+
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "COLLECTOR_CONCURRENCY") {
+		t.Errorf("Expected an error naming COLLECTOR_CONCURRENCY, got %v", err)
+	}
+}
+
+func TestConfig_Marshal_RedactsSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.Cursor.APIToken = "key_secret"
+
+	out, err := cfg.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	if strings.Contains(string(out), "key_secret") {
+		t.Error("Expected the API token to be redacted")
+	}
+	if !strings.Contains(string(out), "api_token: "+redacted) {
+		t.Errorf("Expected a redacted api_token, got:\n%s", out)
+	}
+	if cfg.Cursor.APIToken != "key_secret" {
+		t.Error("Expected Marshal to leave the config unchanged")
+	}
+
+	// The printed config must be loadable again.
+	if _, err := Parse(out); err != nil {
+		t.Errorf("Failed to parse marshalled config: %v", err)
+	}
+}
+
+func TestExampleFile(t *testing.T) {
+	t.Setenv("CURSOR_API_TOKEN", "token")
+	if _, err := Load(filepath.Join("..", "..", "config.example.yaml")); err != nil {
+		t.Errorf("Failed to load example config file: %v", err)
+	}
+}
+
+func TestLoad_TokenFile(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write token file: %v", err)
+	}
+	t.Setenv("CURSOR_API_TOKEN", "")
+	t.Setenv("CURSOR_API_TOKEN_FILE", tokenFile)
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	source, err := cfg.Cursor.TokenSource()
+	if err != nil {
+		t.Fatalf("Failed to create token source: %v", err)
+	}
+	if token, _ := source.Token(); token != "from-file" {
+		t.Errorf("Expected the token from the file, got %q", token)
+	}
+
+	t.Setenv("CURSOR_API_TOKEN", "from-env")
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "cursor.api_token_file: cannot be combined with api_token") {
+		t.Errorf("Expected an error for both a token and a token file, got %v", err)
+	}
+}
+
+func TestParse_Teams(t *testing.T) {
+	cfg, err := Parse([]byte(`
+teams:
+  - name: marketing
+    api_token: secret
+  - name: sales
+    api_token_file: /run/secrets/sales
+state:
+  file: /data/state.json
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	teams := cfg.TeamList()
+	if len(teams) != 2 || teams[0].Name != "marketing" || teams[1].APITokenFile != "/run/secrets/sales" {
+		t.Errorf("Expected the configured teams, got %+v", teams)
+	}
+	if got := cfg.StateFile("sales"); got != "/data/state.sales.json" {
+		t.Errorf("Expected a state file per team, got %q", got)
+	}
+
+	out, err := cfg.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	if strings.Contains(string(out), "api_token: secret") {
+		t.Errorf("Expected team tokens to be redacted, got:\n%s", out)
+	}
+	if cfg.Teams[0].APIToken != "secret" {
+		t.Error("Expected Marshal to leave the configuration unchanged")
+	}
+}
+
+func TestParse_InvalidTeams(t *testing.T) {
+	_, err := Parse([]byte(`
+cursor:
+  api_token: shared
+teams:
+  - name: marketing
+    api_token: a
+  - name: marketing
+  - name: sales/emea
+    api_token: b
+    api_token_file: /run/secrets/emea
+`))
+	if err == nil {
+		t.Fatal("Expected invalid teams to fail validation")
+	}
+	for _, want := range []string{
+		"cursor.api_token: cannot be combined with teams",
+		`teams[1].name: duplicates team "marketing"`,
+		"teams[1].api_token: is required",
+		`teams[2].name: must only contain letters, digits, _ and -, got "sales/emea"`,
+		"teams[2].api_token_file: cannot be combined with api_token",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in error:\n%v", want, err)
+		}
+	}
+}
+
+func TestConfig_TeamList_SingleTeam(t *testing.T) {
+	cfg := Default()
+	cfg.Cursor.APIToken = "token"
+	cfg.State.File = "/data/state.json"
+
+	teams := cfg.TeamList()
+	if len(teams) != 1 || teams[0].Name != "" || teams[0].APIToken != "token" {
+		t.Errorf("Expected a single unnamed team with the cursor token, got %+v", teams)
+	}
+	if got := cfg.StateFile(""); got != "/data/state.json" {
+		t.Errorf("Expected the configured state file for a single team, got %q", got)
+	}
+}
+
+func TestParse_Privacy(t *testing.T) {
+	cfg, err := Parse([]byte(`
+cursor:
+  api_token: token
+privacy:
+  identity: hash
+  hash_salt: pepper
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	policy, err := cfg.Privacy.Policy()
+	if err != nil {
+		t.Fatalf("Failed to create the privacy policy: %v", err)
+	}
+	if got := policy.Identity("alice@example.com"); got == "alice@example.com" || got == "" {
+		t.Errorf("Expected a hashed email, got %q", got)
+	}
+
+	out, err := cfg.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	if strings.Contains(string(out), "pepper") {
+		t.Errorf("Expected the hash salt to be redacted, got:\n%s", out)
+	}
+}
+
+func TestParse_InvalidPrivacy(t *testing.T) {
+	for config, want := range map[string]string{
+		"{identity: obfuscate}": `privacy.identity: must be one of keep, hash, alias, domain or drop, got "obfuscate"`,
+		"{identity: hash}":      "privacy.hash_salt: is required in hash mode",
+		"{identity: hash, hash_salt: a, hash_salt_file: /salt}": "privacy.hash_salt_file: cannot be combined with hash_salt",
+		"{identity: alias}": "privacy.aliases: is required in alias mode",
+	} {
+		_, err := Parse([]byte("cursor: {api_token: token}\nprivacy: " + config))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected %q, got %v", config, want, err)
+		}
+	}
+}
+
+func TestParse_CardinalityLimits(t *testing.T) {
+	cfg, err := Parse([]byte(`
+cursor:
+  api_token: token
+cardinality:
+  limits:
+    - metric: cursor_usage_events_by_user_total
+      label: user_email
+      max_values: 50
+    - metric: cursor_daily_user_*
+      label: date
+      mode: latest
+      max_values: 7
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	limiter, err := cfg.Cardinality.Limiter()
+	if err != nil || limiter == nil {
+		t.Fatalf("Expected a limiter, got %v", err)
+	}
+
+	_, err = Parse([]byte(`
+cursor:
+  api_token: token
+cardinality:
+  limits:
+    - {metric: "[", label: user_email, max_values: 1}
+    - {metric: cursor_*, mode: oldest, max_values: 0}
+`))
+	if err == nil {
+		t.Fatal("Expected invalid limits to fail validation")
+	}
+	for _, want := range []string{
+		`cardinality.limits[0].metric: must be a metric name or glob pattern, got "["`,
+		"cardinality.limits[1].label: is required",
+		`cardinality.limits[1].mode: must be top or latest, got "oldest"`,
+		"cardinality.limits[1].max_values: must be positive, got 0",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in error:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoad_CardinalityLimitsEnv(t *testing.T) {
+	t.Setenv("CURSOR_API_TOKEN", "token")
+	t.Setenv("CARDINALITY_LIMITS", "cursor_tokens_consumed_by_user_total:user_email=20, cursor_daily_user_*:date=7:latest")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	want := []LimitConfig{
+		{Metric: "cursor_tokens_consumed_by_user_total", Label: "user_email", MaxValues: 20},
+		{Metric: "cursor_daily_user_*", Label: "date", Mode: "latest", MaxValues: 7},
+	}
+	if !slices.Equal(cfg.Cardinality.Limits, want) {
+		t.Errorf("Expected %+v, got %+v", want, cfg.Cardinality.Limits)
+	}
+
+	t.Setenv("CARDINALITY_LIMITS", "cursor_tokens_consumed_by_user_total=20")
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "CARDINALITY_LIMITS") {
+		t.Errorf("Expected an error naming CARDINALITY_LIMITS, got %v", err)
+	}
+}
+
+func TestParse_OTLP(t *testing.T) {
+	cfg, err := Parse([]byte(`
+cursor:
+  api_token: token
+server:
+  metrics_enabled: false
+otlp:
+  endpoint: https://otel.example.com:4318
+  protocol: http/protobuf
+  interval: 30s
+  headers:
+    Authorization: Bearer secret
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	opts := cfg.OTLP.Options()
+	if opts.Endpoint != "https://otel.example.com:4318" || opts.Protocol != otlp.HTTP || opts.Interval != 30*time.Second || opts.Timeout != 10*time.Second {
+		t.Errorf("Expected the OTLP settings with the default timeout, got %+v", opts)
+	}
+
+	out, err := cfg.Marshal()
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	if strings.Contains(string(out), "secret") {
+		t.Errorf("Expected the OTLP headers to be redacted:\n%s", out)
+	}
+
+	next := *cfg
+	next.OTLP.Headers = map[string]string{"Authorization": "Bearer rotated"}
+	if got := cfg.RestartRequired(&next); !slices.Equal(got, []string{"otlp"}) {
+		t.Errorf("Expected a change of OTLP headers to require a restart, got %v", got)
+	}
+}
+
+func TestParse_InvalidOTLP(t *testing.T) {
+	for config, want := range map[string]string{
+		"server: {metrics_enabled: false}":                      "server.metrics_enabled: cannot be false unless otlp.endpoint is set",
+		"otlp: {endpoint: localhost:4317, protocol: http/json}": `otlp.protocol: must be grpc or http/protobuf, got "http/json"`,
+		"otlp: {endpoint: localhost:4317, interval: 0s}":        "otlp.interval: must be positive",
+		"otlp: {endpoint: localhost:4317, timeout: -1s}":        "otlp.timeout: must be positive",
+	} {
+		_, err := Parse([]byte("cursor: {api_token: token}\n" + config))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected %q, got %v", config, want, err)
+		}
+	}
+}
+
+func TestLoad_OTLPEnv(t *testing.T) {
+	t.Setenv("CURSOR_API_TOKEN", "token")
+	t.Setenv("OTLP_ENDPOINT", "otel-collector:4317")
+	t.Setenv("OTLP_INSECURE", "true")
+	t.Setenv("OTLP_HEADERS", "Authorization=Bearer a=b, X-Scope-OrgID=cursor")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.OTLP.Endpoint != "otel-collector:4317" || cfg.OTLP.Protocol != "grpc" || !cfg.OTLP.Insecure {
+		t.Errorf("Expected an insecure gRPC endpoint, got %+v", cfg.OTLP)
+	}
+	want := map[string]string{"Authorization": "Bearer a=b", "X-Scope-OrgID": "cursor"}
+	if !maps.Equal(cfg.OTLP.Headers, want) {
+		t.Errorf("Expected headers %v, got %v", want, cfg.OTLP.Headers)
+	}
+
+	t.Setenv("OTLP_HEADERS", "Authorization")
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "OTLP_HEADERS") {
+		t.Errorf("Expected an error naming OTLP_HEADERS, got %v", err)
+	}
+}
@@ -0,0 +1,313 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+)
+
+// Flags are the command-line flags for every setting. Flags that are given
+// take precedence over the config file and environment variables.
+type Flags struct {
+	// File is the YAML configuration file given with --config.file, or
+	// CONFIG_FILE.
+	File string
+
+	settings []setter
+}
+
+// setter is a flag that overrides a single setting once it has been given.
+type setter interface {
+	flag.Value
+	apply(cfg *Config)
+}
+
+// setting is a flag holding a value of type T for the field it points to.
+type setting[T any] struct {
+	field  func(cfg *Config) *T
+	parse  func(s string) (T, error)
+	format func(v T) string
+	isBool bool
+
+	value T
+	set   bool
+}
+
+func (s *setting[T]) String() string {
+	if s.format == nil {
+		return ""
+	}
+	return s.format(s.value)
+}
+
+func (s *setting[T]) Set(value string) error {
+	v, err := s.parse(value)
+	if err != nil {
+		return err
+	}
+	s.value, s.set = v, true
+	return nil
+}
+
+func (s *setting[T]) IsBoolFlag() bool { return s.isBool }
+
+func (s *setting[T]) apply(cfg *Config) {
+	if s.set {
+		*s.field(cfg) = s.value
+	}
+}
+
+// RegisterFlags defines a flag for every setting on fs. Flags are named
+// after the path of the setting in the YAML file, e.g. --cursor.retry.max-attempts,
+// and show the built-in defaults.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	defaults := Default()
+
+	fs.StringVar(&f.File, "config.file", os.Getenv("CONFIG_FILE"), "YAML configuration file (env CONFIG_FILE)")
+
+	f.string(fs, defaults, "cursor.api-url", "Cursor API endpoint (env CURSOR_API_URL)", func(c *Config) *string { return &c.Cursor.APIURL })
+	f.string(fs, defaults, "cursor.api-token", "Cursor API token; prefer CURSOR_API_TOKEN, as flags are visible to other users (env CURSOR_API_TOKEN)", func(c *Config) *string { return &c.Cursor.APIToken })
+	f.string(fs, defaults, "cursor.api-token-file", "File holding the Cursor API token, re-read when it changes (env CURSOR_API_TOKEN_FILE)", func(c *Config) *string { return &c.Cursor.APITokenFile })
+	f.duration(fs, defaults, "cursor.request-timeout", "Timeout of a single Cursor API request (env API_REQUEST_TIMEOUT)", func(c *Config) *time.Duration { return &c.Cursor.RequestTimeout })
+	f.int(fs, defaults, "cursor.retry.max-attempts", "Attempts per Cursor API request, 1 disables retries (env API_MAX_ATTEMPTS)", func(c *Config) *int { return &c.Cursor.Retry.MaxAttempts })
+	f.duration(fs, defaults, "cursor.retry.initial-backoff", "Backoff before the first retry, doubled for every further retry (env API_RETRY_INITIAL_BACKOFF)", func(c *Config) *time.Duration { return &c.Cursor.Retry.InitialBackoff })
+	f.duration(fs, defaults, "cursor.retry.max-backoff", "Longest wait between retries, including Retry-After (env API_RETRY_MAX_BACKOFF)", func(c *Config) *time.Duration { return &c.Cursor.Retry.MaxBackoff })
+	f.float(fs, defaults, "cursor.rate-limit.requests-per-minute", "Cursor API requests per minute across all endpoints, 0 is unlimited (env API_RATE_LIMIT)", func(c *Config) *float64 { return &c.Cursor.RateLimit.RequestsPerMinute })
+	f.int(fs, defaults, "cursor.rate-limit.burst", "Requests that may be sent at once under the rate limit (env API_RATE_LIMIT_BURST)", func(c *Config) *int { return &c.Cursor.RateLimit.Burst })
+	f.add(fs, "cursor.rate-limit.endpoints", "Per-endpoint budgets as /endpoint=requests_per_minute[:burst],... (env API_ENDPOINT_RATE_LIMITS)", &setting[map[string]RateLimit]{
+		field:  func(c *Config) *map[string]RateLimit { return &c.Cursor.RateLimit.Endpoints },
+		parse:  parseEndpointRateLimits,
+		format: formatEndpointRateLimits,
+		value:  defaults.Cursor.RateLimit.Endpoints,
+	})
+
+	f.string(fs, defaults, "server.listen-address", "HTTP server listen address (env LISTEN_ADDRESS)", func(c *Config) *string { return &c.Server.ListenAddress })
+	f.string(fs, defaults, "server.metrics-path", "Metrics endpoint path (env METRICS_PATH)", func(c *Config) *string { return &c.Server.MetricsPath })
+	f.bool(fs, defaults, "server.metrics-enabled", "Serve metrics at the metrics path; disable to only push them over OTLP (env METRICS_ENABLED)", func(c *Config) *bool { return &c.Server.MetricsEnabled })
+	f.string(fs, defaults, "log-level", "Logging level (env LOG_LEVEL)", func(c *Config) *string { return &c.LogLevel })
+
+	f.int(fs, defaults, "collectors.concurrency", "Collectors that may call the Cursor API at the same time (env COLLECTOR_CONCURRENCY)", func(c *Config) *int { return &c.Collectors.Concurrency })
+	f.duration(fs, defaults, "collectors.timeout", "Longest a single collector refresh may take (env COLLECTOR_TIMEOUT)", func(c *Config) *time.Duration { return &c.Collectors.Timeout })
+	for _, name := range []string{"team_members", "daily_usage", "spending", "usage_events"} {
+		collector := func(c *Config) *CollectorConfig { return c.Collector(name) }
+		flagName := CollectorFlag(name)
+		env := strings.ToUpper(name)
+
+		f.bool(fs, defaults, flagName, fmt.Sprintf("Enable the %s collector (env %s_ENABLED)", name, env), func(c *Config) *bool { return &collector(c).Enabled })
+		f.duration(fs, defaults, flagName+".interval", fmt.Sprintf("Refresh interval of the %s collector (env %s_POLL_INTERVAL)", name, env), func(c *Config) *time.Duration { return &collector(c).Interval })
+		if collector(defaults).LookbackDays > 0 {
+			f.int(fs, defaults, flagName+".lookback-days", fmt.Sprintf("Days of history the %s collector exports (env %s_LOOKBACK_DAYS)", name, env), func(c *Config) *int { return &collector(c).LookbackDays })
+		}
+		if collector(defaults).PageSize > 0 {
+			f.int(fs, defaults, flagName+".page-size", fmt.Sprintf("Items the %s collector requests per page (env %s_PAGE_SIZE)", name, env), func(c *Config) *int { return &collector(c).PageSize })
+		}
+	}
+
+	f.string(fs, defaults, "state.file", "File that persists counters and cursors across restarts (env STATE_FILE)", func(c *Config) *string { return &c.State.File })
+	f.duration(fs, defaults, "state.flush-interval", "How often state is saved (env STATE_FLUSH_INTERVAL)", func(c *Config) *time.Duration { return &c.State.FlushInterval })
+	f.string(fs, defaults, "pricing.file", "YAML model price table used to estimate cost (env PRICING_FILE)", func(c *Config) *string { return &c.PricingFile })
+	f.string(fs, defaults, "budgets.file", "YAML budget rules evaluated against cycle spend (env BUDGETS_FILE)", func(c *Config) *string { return &c.BudgetsFile })
+	f.string(fs, defaults, "directory.file", "YAML, CSV or SCIM JSON file mapping users to departments, managers and cost centres (env DIRECTORY_FILE)", func(c *Config) *string { return &c.DirectoryFile })
+	f.string(fs, defaults, "privacy.identity", "How user emails appear in labels: keep, hash, alias, domain or drop (env PRIVACY_IDENTITY)", func(c *Config) *string { return &c.Privacy.Identity })
+	f.string(fs, defaults, "privacy.hash-salt", "Secret salt of the email hashes in hash mode (env PRIVACY_HASH_SALT)", func(c *Config) *string { return &c.Privacy.HashSalt })
+	f.string(fs, defaults, "privacy.hash-salt-file", "File holding the salt of the email hashes (env PRIVACY_HASH_SALT_FILE)", func(c *Config) *string { return &c.Privacy.HashSaltFile })
+	f.add(fs, "cardinality.limits", "Caps on label values as metric:label=max_values[:top|latest],... (env CARDINALITY_LIMITS)", &setting[[]LimitConfig]{
+		field:  func(c *Config) *[]LimitConfig { return &c.Cardinality.Limits },
+		parse:  parseLimits,
+		format: formatLimits,
+		value:  defaults.Cardinality.Limits,
+	})
+	f.string(fs, defaults, "otlp.endpoint", "OpenTelemetry Collector to push metrics to over OTLP, as host:port or a URL; empty disables pushing (env OTLP_ENDPOINT)", func(c *Config) *string { return &c.OTLP.Endpoint })
+	f.string(fs, defaults, "otlp.protocol", "OTLP protocol: grpc or http/protobuf (env OTLP_PROTOCOL)", func(c *Config) *string { return &c.OTLP.Protocol })
+	f.duration(fs, defaults, "otlp.interval", "How often metrics are pushed over OTLP (env OTLP_INTERVAL)", func(c *Config) *time.Duration { return &c.OTLP.Interval })
+	f.duration(fs, defaults, "otlp.timeout", "Timeout of a single OTLP push (env OTLP_TIMEOUT)", func(c *Config) *time.Duration { return &c.OTLP.Timeout })
+	f.bool(fs, defaults, "otlp.insecure", "Push over OTLP without TLS (env OTLP_INSECURE)", func(c *Config) *bool { return &c.OTLP.Insecure })
+	f.add(fs, "otlp.headers", "Headers sent with every OTLP push as name=value,...; prefer OTLP_HEADERS for credentials (env OTLP_HEADERS)", &setting[map[string]string]{
+		field:  func(c *Config) *map[string]string { return &c.OTLP.Headers },
+		parse:  parseHeaders,
+		format: formatHeaders,
+		value:  defaults.OTLP.Headers,
+	})
+	f.duration(fs, defaults, "config.watch-interval", "How often the config, pricing, budgets and directory files are checked for changes, 0s disables (env CONFIG_WATCH_INTERVAL)", func(c *Config) *time.Duration { return &c.WatchInterval })
+
+	return f
+}
+
+// CollectorFlag returns the name of the flag that toggles a collector, e.g.
+// collector.usage-events.
+func CollectorFlag(name string) string {
+	return "collector." + strings.ReplaceAll(name, "_", "-")
+}
+
+// Load loads the configuration like the package-level Load and overrides it
+// with the flags that were given before validating it.
+func (f *Flags) Load(filename string) (*Config, error) {
+	cfg, err := load(filename)
+	if err != nil {
+		return nil, err
+	}
+	f.Apply(cfg)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Apply overrides cfg with the flags that were given.
+func (f *Flags) Apply(cfg *Config) {
+	for _, s := range f.settings {
+		s.apply(cfg)
+	}
+}
+
+func (f *Flags) add(fs *flag.FlagSet, name, usage string, s setter) {
+	f.settings = append(f.settings, s)
+	fs.Var(s, name, usage)
+}
+
+func (f *Flags) string(fs *flag.FlagSet, defaults *Config, name, usage string, field func(*Config) *string) {
+	f.add(fs, name, usage, &setting[string]{
+		field:  field,
+		parse:  func(s string) (string, error) { return s, nil },
+		format: func(v string) string { return v },
+		value:  *field(defaults),
+	})
+}
+
+func (f *Flags) bool(fs *flag.FlagSet, defaults *Config, name, usage string, field func(*Config) *bool) {
+	f.add(fs, name, usage, &setting[bool]{
+		field:  field,
+		parse:  strconv.ParseBool,
+		format: strconv.FormatBool,
+		isBool: true,
+		value:  *field(defaults),
+	})
+}
+
+func (f *Flags) duration(fs *flag.FlagSet, defaults *Config, name, usage string, field func(*Config) *time.Duration) {
+	f.add(fs, name, usage, &setting[time.Duration]{
+		field:  field,
+		parse:  time.ParseDuration,
+		format: time.Duration.String,
+		value:  *field(defaults),
+	})
+}
+
+func (f *Flags) int(fs *flag.FlagSet, defaults *Config, name, usage string, field func(*Config) *int) {
+	f.add(fs, name, usage, &setting[int]{
+		field:  field,
+		parse:  strconv.Atoi,
+		format: strconv.Itoa,
+		value:  *field(defaults),
+	})
+}
+
+func (f *Flags) float(fs *flag.FlagSet, defaults *Config, name, usage string, field func(*Config) *float64) {
+	f.add(fs, name, usage, &setting[float64]{
+		field:  field,
+		parse:  func(s string) (float64, error) { return strconv.ParseFloat(s, 64) },
+		format: func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) },
+		value:  *field(defaults),
+	})
+}
+
+func parseEndpointRateLimits(s string) (map[string]RateLimit, error) {
+	limits, err := client.ParseEndpointRateLimits(s)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make(map[string]RateLimit, len(limits))
+	for endpoint, limit := range limits {
+		endpoints[endpoint] = RateLimit{RequestsPerMinute: limit.RequestsPerMinute, Burst: limit.Burst}
+	}
+	return endpoints, nil
+}
+
+func formatEndpointRateLimits(endpoints map[string]RateLimit) string {
+	var parts []string
+	for _, endpoint := range sortedKeys(endpoints) {
+		limit := endpoints[endpoint]
+		part := endpoint + "=" + strconv.FormatFloat(limit.RequestsPerMinute, 'g', -1, 64)
+		if limit.Burst > 0 {
+			part += ":" + strconv.Itoa(limit.Burst)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseLimits parses cardinality limits written as
+// metric:label=max_values[:mode], separated by commas, such as
+// cursor_usage_events_by_user_total:user_email=50,cursor_daily_user_*:date=7:latest.
+func parseLimits(s string) ([]LimitConfig, error) {
+	var limits []LimitConfig
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		target, rest, ok := strings.Cut(part, "=")
+		i := strings.LastIndex(target, ":")
+		if !ok || i < 0 {
+			return nil, fmt.Errorf("limit %q must be metric:label=max_values[:mode]", part)
+		}
+		limit := LimitConfig{Metric: target[:i], Label: target[i+1:]}
+		value, mode, _ := strings.Cut(rest, ":")
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("limit %q: invalid max_values %q", part, value)
+		}
+		limit.MaxValues = n
+		limit.Mode = mode
+		limits = append(limits, limit)
+	}
+	return limits, nil
+}
+
+func formatLimits(limits []LimitConfig) string {
+	parts := make([]string, 0, len(limits))
+	for _, limit := range limits {
+		part := limit.Metric + ":" + limit.Label + "=" + strconv.Itoa(limit.MaxValues)
+		if limit.Mode != "" {
+			part += ":" + limit.Mode
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseHeaders parses OTLP headers written as name=value, separated by
+// commas, such as Authorization=Bearer token,X-Scope-OrgID=cursor.
+func parseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header %q, expected name=value", strings.TrimSpace(part))
+		}
+		headers[name] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// formatHeaders formats headers with their values redacted, so flag
+// defaults never show credentials.
+func formatHeaders(headers map[string]string) string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name+"="+redacted)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlags_OverrideFileAndEnv(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(filename, []byte("collectors:\n  spending:\n    interval: 2m\n  usage_events:\n    enabled: false\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	t.Setenv("CONFIG_FILE", filename)
+	t.Setenv("CURSOR_API_TOKEN", "from-env")
+	t.Setenv("LOG_LEVEL", "warn")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := RegisterFlags(fs)
+	if err := fs.Parse([]string{
+		"--log-level", "debug",
+		"--collector.spending.interval=45s",
+		"--collector.usage-events",
+		"--collector.team-members=false",
+		"--cursor.rate-limit.endpoints", "/teams/spend=5:2",
+	}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+	if flags.File != filename {
+		t.Errorf("Expected the config file from CONFIG_FILE, got %q", flags.File)
+	}
+
+	cfg, err := flags.Load(flags.File)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.LogLevel != "debug" {
+		t.Errorf("Expected the log level from the flag, got %q", cfg.LogLevel)
+	}
+	if cfg.Cursor.APIToken != "from-env" {
+		t.Errorf("Expected the token from the environment when no flag is given, got %q", cfg.Cursor.APIToken)
+	}
+	if cfg.Collectors.Spending.Interval != 45*time.Second {
+		t.Errorf("Expected the spending interval from the flag, got %s", cfg.Collectors.Spending.Interval)
+	}
+	if !cfg.Collectors.UsageEvents.Enabled || cfg.Collectors.TeamMembers.Enabled {
+		t.Errorf("Expected usage events enabled and team members disabled by flags, got %v and %v", cfg.Collectors.UsageEvents.Enabled, cfg.Collectors.TeamMembers.Enabled)
+	}
+	if !cfg.Collectors.DailyUsage.Enabled || cfg.Collectors.DailyUsage.Interval != 15*time.Minute {
+		t.Error("Expected settings without flags to keep their defaults")
+	}
+	if got := cfg.Cursor.RateLimit.Endpoints["/teams/spend"]; got != (RateLimit{RequestsPerMinute: 5, Burst: 2}) {
+		t.Errorf("Expected /teams/spend=5:2 from the flag, got %+v", got)
+	}
+}
+
+func TestFlags_ValidatesAfterOverrides(t *testing.T) {
+	t.Setenv("CURSOR_API_TOKEN", "")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := RegisterFlags(fs)
+	if err := fs.Parse([]string{"--cursor.api-token", "from-flag", "--collectors.concurrency", "0"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	_, err := flags.Load("")
+	if err == nil {
+		t.Fatal("Expected an invalid concurrency to fail validation")
+	}
+	if strings.Contains(err.Error(), "cursor.api_token") {
+		t.Errorf("Expected the token flag to satisfy validation, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "collectors.concurrency") {
+		t.Errorf("Expected an error naming collectors.concurrency, got %v", err)
+	}
+}
+
+func TestFlags_InvalidValue(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	RegisterFlags(fs)
+
+	if err := fs.Parse([]string{"--collectors.timeout", "soon"}); err == nil || !strings.Contains(err.Error(), "collectors.timeout") {
+		t.Errorf("Expected an error naming collectors.timeout, got %v", err)
+	}
+}
+
+func TestFlags_Usage_ShowsDefaults(t *testing.T) {
+	var buf bytes.Buffer
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(&buf)
+	RegisterFlags(fs)
+	fs.PrintDefaults()
+
+	for _, want := range []string{
+		"-collector.usage-events.page-size value",
+		"(default 5000)",
+		"(default 15m0s)",
+		"(env CURSOR_API_TOKEN)",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected usage to contain %q, got:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Reloader reloads the configuration on demand and when the config file or
+// a file it references changes, and reports how the last reload went.
+type Reloader struct {
+	filename string
+	load     func(filename string) (*Config, error)
+	apply    func(*Config) error
+
+	mu      sync.Mutex
+	current *Config
+	files   []string
+	digest  string
+
+	lastSuccess     prometheus.Gauge
+	lastSuccessTime prometheus.Gauge
+}
+
+// NewReloader returns a Reloader for the config file at filename, which may
+// be empty. cfg is the configuration already in use, load is Load or
+// Flags.Load, and apply is called with every configuration reloaded
+// successfully; if it fails, the reload is reported as failed. Reloads that
+// change settings which only take effect after a restart log a warning.
+func NewReloader(filename string, cfg *Config, load func(filename string) (*Config, error), apply func(*Config) error) *Reloader {
+	r := &Reloader{
+		filename: filename,
+		load:     load,
+		apply:    apply,
+		current:  cfg,
+
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "cursor_exporter_config_last_reload_success",
+			Help: "Whether the last configuration reload attempt was successful",
+		}),
+		lastSuccessTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "cursor_exporter_config_last_reload_success_timestamp_seconds",
+			Help: "Unix time of the last successful configuration reload",
+		}),
+	}
+	r.files = r.watchedFiles(cfg)
+	r.digest = digest(r.files)
+	r.lastSuccess.Set(1)
+	r.lastSuccessTime.SetToCurrentTime()
+	return r
+}
+
+// Reload loads the configuration again and applies it. The configuration in
+// use is kept if loading or applying fails.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := r.load(r.filename)
+	if err == nil {
+		err = r.apply(cfg)
+	}
+	if err != nil {
+		r.lastSuccess.Set(0)
+		// Remember what failed to load so Watch waits for the next change
+		// instead of retrying the same broken file.
+		r.digest = digest(r.files)
+		return err
+	}
+
+	// Compare with the last configuration applied rather than the one the
+	// exporter started with, so each change is only warned about once.
+	if settings := r.current.RestartRequired(cfg); len(settings) > 0 {
+		logrus.WithField("settings", settings).Warn("Changed settings only take effect after a restart")
+	}
+	r.current = cfg
+	r.files = r.watchedFiles(cfg)
+	r.digest = digest(r.files)
+	r.lastSuccess.Set(1)
+	r.lastSuccessTime.SetToCurrentTime()
+	return nil
+}
+
+// Watch checks the config file, the pricing file, the budgets file and the
+// directory file every interval and reloads when any of them has changed, until ctx is
+// cancelled. Files are compared by content, so touching a file or a
+// Kubernetes ConfigMap update that changes nothing does not reload.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		r.mu.Lock()
+		changed := digest(r.files) != r.digest
+		r.mu.Unlock()
+		if !changed {
+			continue
+		}
+
+		logrus.Info("Configuration changed, reloading")
+		if err := r.Reload(); err != nil {
+			logrus.WithError(err).Error("Failed to reload configuration")
+		}
+	}
+}
+
+func (r *Reloader) Describe(ch chan<- *prometheus.Desc) {
+	r.lastSuccess.Describe(ch)
+	r.lastSuccessTime.Describe(ch)
+}
+
+func (r *Reloader) Collect(ch chan<- prometheus.Metric) {
+	r.lastSuccess.Collect(ch)
+	r.lastSuccessTime.Collect(ch)
+}
+
+// watchedFiles returns the files whose changes trigger a reload.
+func (r *Reloader) watchedFiles(cfg *Config) []string {
+	var files []string
+	for _, f := range []string{r.filename, cfg.PricingFile, cfg.BudgetsFile, cfg.DirectoryFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// digest hashes the content of files. Files that cannot be read hash as
+// missing, so they count as changed once they can be read again.
+func digest(files []string) string {
+	h := sha256.New()
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			fmt.Fprintf(h, "%s\x00missing\x00", f)
+			continue
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", f, len(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func writeConfig(t *testing.T, filename, content string) {
+	t.Helper()
+	if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+}
+
+func TestReloader_Reload(t *testing.T) {
+	t.Setenv("CURSOR_API_TOKEN", "token")
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, filename, "collectors:\n  spending:\n    interval: 2m\n")
+
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	var applied []*Config
+	applyErr := error(nil)
+	r := NewReloader(filename, cfg, Load, func(c *Config) error {
+		if applyErr != nil {
+			return applyErr
+		}
+		applied = append(applied, c)
+		return nil
+	})
+	if got := testutil.ToFloat64(r.lastSuccess); got != 1 {
+		t.Errorf("Expected the initial configuration to count as loaded, got %f", got)
+	}
+
+	writeConfig(t, filename, "collectors:\n  spending:\n    interval: 3m\n")
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Expected reload to succeed, got %v", err)
+	}
+	if len(applied) != 1 || applied[0].Collectors.Spending.Interval != 3*time.Minute {
+		t.Fatalf("Expected the new interval to be applied, got %v", applied)
+	}
+
+	writeConfig(t, filename, "collectors:\n  spending:\n    interval: soon\n")
+	if err := r.Reload(); err == nil {
+		t.Fatal("Expected reload of an invalid file to fail")
+	}
+	if got := testutil.ToFloat64(r.lastSuccess); got != 0 {
+		t.Errorf("Expected last reload success 0, got %f", got)
+	}
+	if len(applied) != 1 {
+		t.Error("Expected an invalid configuration not to be applied")
+	}
+
+	writeConfig(t, filename, "")
+	applyErr = errors.New("budgets file missing")
+	if err := r.Reload(); !errors.Is(err, applyErr) {
+		t.Fatalf("Expected the apply error, got %v", err)
+	}
+	if got := testutil.ToFloat64(r.lastSuccess); got != 0 {
+		t.Errorf("Expected last reload success 0 after apply failed, got %f", got)
+	}
+
+	applyErr = nil
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Expected reload to succeed, got %v", err)
+	}
+	if got := testutil.ToFloat64(r.lastSuccess); got != 1 {
+		t.Errorf("Expected last reload success 1, got %f", got)
+	}
+}
+
+func TestReloader_Reload_RestartRequired(t *testing.T) {
+	t.Setenv("CURSOR_API_TOKEN", "token")
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, filename, "server:\n  listen_address: \":8080\"\n")
+
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	r := NewReloader(filename, cfg, Load, func(*Config) error { return nil })
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	// The second reload changes nothing the first one did not, so only the
+	// first should warn.
+	writeConfig(t, filename, "server:\n  listen_address: \":9090\"\n")
+	for i := 0; i < 2; i++ {
+		if err := r.Reload(); err != nil {
+			t.Fatalf("Expected reload to succeed, got %v", err)
+		}
+	}
+
+	warnings := 0
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.WarnLevel {
+			warnings++
+		}
+	}
+	if warnings != 1 {
+		t.Errorf("Expected one restart warning, got %d", warnings)
+	}
+}
+
+func TestReloader_Watch(t *testing.T) {
+	t.Setenv("CURSOR_API_TOKEN", "token")
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "config.yaml")
+	budgets := filepath.Join(dir, "budgets.yaml")
+	writeConfig(t, budgets, "rules: []\n")
+	writeConfig(t, filename, "budgets_file: "+budgets+"\n")
+
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	var mu sync.Mutex
+	reloads := 0
+	r := NewReloader(filename, cfg, Load, func(*Config) error {
+		mu.Lock()
+		defer mu.Unlock()
+		reloads++
+		return nil
+	})
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return reloads
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	// Rewriting the same content is not a change.
+	writeConfig(t, filename, "budgets_file: "+budgets+"\n")
+	time.Sleep(50 * time.Millisecond)
+	if got := count(); got != 0 {
+		t.Fatalf("Expected no reload for unchanged content, got %d", got)
+	}
+
+	// Files referenced by the config are watched too.
+	writeConfig(t, budgets, "thresholds: [0.5]\nrules: []\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for count() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the budgets change to reload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := count(); got != 1 {
+		t.Errorf("Expected exactly one reload per change, got %d", got)
+	}
+}
+
+func TestConfig_RestartRequired(t *testing.T) {
+	current := Default()
+	next := Default()
+	next.Collectors.Spending.Interval = time.Hour
+	next.Server.ListenAddress = ":9090"
+	next.Cursor.APIToken = "rotated"
+
+	want := []string{"cursor.api_token", "server.listen_address"}
+	if got := current.RestartRequired(next); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
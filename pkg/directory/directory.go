@@ -0,0 +1,363 @@
+// Package directory maps the users of a Cursor team to their department,
+// manager and cost centre, and adds them to the labels of per-user metrics.
+package directory
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/utils"
+)
+
+// Labels added to the metrics of users found in the directory.
+const (
+	DepartmentLabel = "department"
+	ManagerLabel    = "manager"
+	CostCentreLabel = "cost_centre"
+)
+
+// Unassigned is the department and cost centre of users missing from the
+// directory, or listed without one.
+const Unassigned = "unassigned"
+
+// userLabels are the labels that identify the user of a series.
+var userLabels = []string{"user_email", "member_email"}
+
+// Entry is what the directory knows about one user.
+type Entry struct {
+	Email      string `yaml:"email"`
+	Department string `yaml:"department"`
+	Manager    string `yaml:"manager"`
+	CostCentre string `yaml:"cost_centre"`
+}
+
+// Directory maps user emails to their Entry.
+type Directory struct {
+	entries map[string]Entry
+}
+
+// LoadFile reads a directory from a file. The format follows the extension:
+// .yaml or .yml for ParseYAML, .csv for ParseCSV and .json for ParseSCIM.
+func LoadFile(filename string) (*Directory, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory file: %w", err)
+	}
+
+	var directory *Directory
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".yaml", ".yml":
+		directory, err = ParseYAML(data)
+	case ".csv":
+		directory, err = ParseCSV(data)
+	case ".json":
+		directory, err = ParseSCIM(data)
+	default:
+		return nil, fmt.Errorf("directory file %s: unsupported extension %q, use .yaml, .csv or .json", filename, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid directory file %s: %w", filename, err)
+	}
+	return directory, nil
+}
+
+// ParseYAML decodes a directory from YAML, a list of entries under users.
+func ParseYAML(data []byte) (*Directory, error) {
+	var file struct {
+		Users []Entry `yaml:"users"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return New(file.Users)
+}
+
+// ParseCSV decodes a directory from CSV with a header row. The email column
+// is required; department, manager and cost_centre (or cost_center) are
+// optional, and other columns are ignored so exports from other systems can
+// be used as they are.
+func ParseCSV(data []byte) (*Directory, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("missing header row")
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "cost_center" {
+			name = "cost_centre"
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, errors.New("missing email column")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	entries := make([]Entry, 0, len(records)-1)
+	for _, record := range records[1:] {
+		entries = append(entries, Entry{
+			Email:      field(record, "email"),
+			Department: field(record, "department"),
+			Manager:    field(record, "manager"),
+			CostCentre: field(record, "cost_centre"),
+		})
+	}
+	return New(entries)
+}
+
+// ParseSCIM decodes a directory from a SCIM 2.0 list response of users, as
+// exported from the /Users endpoint of an identity provider. Users are
+// matched by their primary email, or their user name if they have no email.
+// Inactive users are skipped.
+func ParseSCIM(data []byte) (*Directory, error) {
+	var response struct {
+		Resources []struct {
+			UserName string `json:"userName"`
+			Active   *bool  `json:"active"`
+			Emails   []struct {
+				Value   string `json:"value"`
+				Primary bool   `json:"primary"`
+			} `json:"emails"`
+			// Enterprise is the SCIM enterprise user schema extension,
+			// which holds the department, cost centre and manager.
+			Enterprise struct {
+				Department string `json:"department"`
+				CostCenter string `json:"costCenter"`
+				Manager    struct {
+					Value       string `json:"value"`
+					DisplayName string `json:"displayName"`
+				} `json:"manager"`
+			} `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"`
+		} `json:"Resources"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(response.Resources))
+	for _, user := range response.Resources {
+		if user.Active != nil && !*user.Active {
+			continue
+		}
+		email := user.UserName
+		if len(user.Emails) > 0 {
+			email = user.Emails[0].Value
+		}
+		for _, e := range user.Emails {
+			if e.Primary {
+				email = e.Value
+				break
+			}
+		}
+		manager := user.Enterprise.Manager.DisplayName
+		if manager == "" {
+			manager = user.Enterprise.Manager.Value
+		}
+		entries = append(entries, Entry{
+			Email:      email,
+			Department: user.Enterprise.Department,
+			Manager:    manager,
+			CostCentre: user.Enterprise.CostCenter,
+		})
+	}
+	return New(entries)
+}
+
+// New returns a directory of entries. Emails are matched case-insensitively
+// and must be unique.
+func New(entries []Entry) (*Directory, error) {
+	d := &Directory{entries: make(map[string]Entry, len(entries))}
+	for i, entry := range entries {
+		email := normalize(entry.Email)
+		if email == "" {
+			return nil, fmt.Errorf("entry %d: email is required", i+1)
+		}
+		if _, ok := d.entries[email]; ok {
+			return nil, fmt.Errorf("entry %d: duplicate email %q", i+1, entry.Email)
+		}
+		d.entries[email] = entry
+	}
+	return d, nil
+}
+
+// Len returns the number of users in d.
+func (d *Directory) Len() int {
+	if d == nil {
+		return 0
+	}
+	return len(d.entries)
+}
+
+// Lookup returns the entry of the user with email.
+func (d *Directory) Lookup(email string) (Entry, bool) {
+	if d == nil {
+		return Entry{}, false
+	}
+	entry, ok := d.entries[normalize(email)]
+	return entry, ok
+}
+
+// labels returns the directory labels of the user with email. Users missing
+// from the directory are unassigned.
+func (d *Directory) labels(email string) map[string]string {
+	entry, _ := d.Lookup(email)
+	labels := map[string]string{
+		DepartmentLabel: entry.Department,
+		CostCentreLabel: entry.CostCentre,
+		ManagerLabel:    entry.Manager,
+	}
+	if labels[DepartmentLabel] == "" {
+		labels[DepartmentLabel] = Unassigned
+	}
+	if labels[CostCentreLabel] == "" {
+		labels[CostCentreLabel] = Unassigned
+	}
+	return labels
+}
+
+func normalize(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// aggregate is a per-department metric summed from a per-user metric.
+type aggregate struct {
+	source string
+	name   string
+}
+
+// aggregates lists the per-user metrics that are also exported summed by
+// department and cost centre, for charge-back.
+var aggregates = []aggregate{
+	{"cursor_spending_by_member_cents", "cursor_department_spending_cents"},
+	{"cursor_premium_requests_by_member_total", "cursor_department_premium_requests_total"},
+	{"cursor_usage_events_by_user_total", "cursor_department_usage_events_total"},
+	{"cursor_tokens_consumed_by_user_total", "cursor_department_tokens_consumed_total"},
+	{"cursor_estimated_cost_dollars", "cursor_department_estimated_cost_dollars"},
+	{"cursor_daily_user_lines_added_total", "cursor_department_daily_lines_added_total"},
+	{"cursor_daily_user_lines_deleted_total", "cursor_department_daily_lines_deleted_total"},
+	{"cursor_daily_user_accepts_total", "cursor_department_daily_accepts_total"},
+	{"cursor_daily_user_rejects_total", "cursor_department_daily_rejects_total"},
+	{"cursor_daily_user_tabs_accepted_total", "cursor_department_daily_tabs_accepted_total"},
+	{"cursor_daily_user_composer_requests_total", "cursor_department_daily_composer_requests_total"},
+	{"cursor_daily_user_chat_requests_total", "cursor_department_daily_chat_requests_total"},
+}
+
+// Apply adds the department, manager and cost centre of the user to every
+// series with a user_email or member_email label, and appends the
+// per-department aggregates. Series of users missing from the directory are
+// unassigned. A nil Directory leaves families unchanged.
+//
+// The aggregates are built from the labels before any other rewriting, so
+// Apply must run before identities are hashed or dropped.
+func (d *Directory) Apply(families []*dto.MetricFamily) []*dto.MetricFamily {
+	if d == nil {
+		return families
+	}
+
+	byName := make(map[string]*dto.MetricFamily, len(families))
+	for _, family := range families {
+		byName[family.GetName()] = family
+		for _, metric := range family.Metric {
+			if email, ok := userEmail(metric); ok && email != "" {
+				metric.Label = withLabels(metric.Label, d.labels(email))
+			}
+		}
+	}
+
+	for _, a := range aggregates {
+		family, ok := byName[a.source]
+		if !ok || len(family.Metric) == 0 {
+			continue
+		}
+		if aggregated := sumByDepartment(family, a.name); aggregated != nil {
+			families = append(families, aggregated)
+		}
+	}
+	return families
+}
+
+// sumByDepartment sums the series of family that belong to a user by every
+// label but the user and manager, so by department, cost centre and the
+// labels of the metric itself such as date or model.
+func sumByDepartment(family *dto.MetricFamily, name string) *dto.MetricFamily {
+	var sums []*dto.Metric
+	for _, metric := range family.Metric {
+		if _, ok := utils.MetricValue(metric); !ok {
+			continue
+		}
+		if email, ok := userEmail(metric); !ok || email == "" {
+			continue
+		}
+
+		sum := proto.Clone(metric).(*dto.Metric)
+		sum.Label = sum.Label[:0]
+		for _, label := range metric.Label {
+			if !isUserLabel(label.GetName()) && label.GetName() != ManagerLabel {
+				sum.Label = append(sum.Label, &dto.LabelPair{Name: label.Name, Value: label.Value})
+			}
+		}
+		sums = append(sums, sum)
+	}
+	if len(sums) == 0 {
+		return nil
+	}
+	return &dto.MetricFamily{
+		Name:   proto.String(name),
+		Help:   proto.String(family.GetHelp() + ", summed by department"),
+		Type:   family.Type,
+		Metric: utils.MergeMetrics(sums, utils.Sum),
+	}
+}
+
+// withLabels returns labels with extra added, sorted by name as the
+// Prometheus registry does. Empty values are left out.
+func withLabels(labels []*dto.LabelPair, extra map[string]string) []*dto.LabelPair {
+	for name, value := range extra {
+		if value != "" {
+			labels = append(labels, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+		}
+	}
+	utils.SortLabels(labels)
+	return labels
+}
+
+func userEmail(metric *dto.Metric) (string, bool) {
+	for _, label := range metric.Label {
+		if isUserLabel(label.GetName()) {
+			return label.GetValue(), true
+		}
+	}
+	return "", false
+}
+
+func isUserLabel(name string) bool {
+	for _, label := range userLabels {
+		if name == label {
+			return true
+		}
+	}
+	return false
+}
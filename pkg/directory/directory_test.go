@@ -0,0 +1,195 @@
+package directory
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseCSV(t *testing.T) {
+	directory, err := ParseCSV([]byte(`Email,Department,Title,Cost_Center,Manager
+Alice@Example.com, Platform ,Engineer,CC-1001,carol@example.com
+bob@example.com,,Engineer,,
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse directory: %v", err)
+	}
+
+	alice, ok := directory.Lookup("alice@example.com")
+	if !ok {
+		t.Fatal("Expected alice to be found regardless of case")
+	}
+	want := Entry{Email: "Alice@Example.com", Department: "Platform", Manager: "carol@example.com", CostCentre: "CC-1001"}
+	if alice != want {
+		t.Errorf("Expected %+v, got %+v", want, alice)
+	}
+	if directory.Len() != 2 {
+		t.Errorf("Expected 2 users, got %d", directory.Len())
+	}
+}
+
+func TestParseSCIM(t *testing.T) {
+	directory, err := ParseSCIM([]byte(`{
+  "schemas": ["urn:ietf:params:scim:api:messages:2.0:ListResponse"],
+  "Resources": [
+    {
+      "userName": "alice",
+      "emails": [{"value": "alice@personal.example"}, {"value": "alice@example.com", "primary": true}],
+      "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {
+        "department": "Platform",
+        "costCenter": "CC-1001",
+        "manager": {"value": "42", "displayName": "Carol"}
+      }
+    },
+    {"userName": "bob@example.com", "active": false}
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("Failed to parse directory: %v", err)
+	}
+
+	alice, ok := directory.Lookup("alice@example.com")
+	if !ok {
+		t.Fatal("Expected alice to be found by her primary email")
+	}
+	if alice.Department != "Platform" || alice.CostCentre != "CC-1001" || alice.Manager != "Carol" {
+		t.Errorf("Expected alice's enterprise attributes, got %+v", alice)
+	}
+	if _, ok := directory.Lookup("bob@example.com"); ok {
+		t.Error("Expected inactive users to be skipped")
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := map[string]func() error{
+		"missing email column": func() error {
+			_, err := ParseCSV([]byte("name,department\nAlice,Platform\n"))
+			return err
+		},
+		"email is required": func() error {
+			_, err := ParseYAML([]byte("users:\n  - department: Platform\n"))
+			return err
+		},
+		"duplicate email": func() error {
+			_, err := ParseYAML([]byte("users:\n  - email: a@example.com\n  - email: A@example.com\n"))
+			return err
+		},
+		"field team not found": func() error {
+			_, err := ParseYAML([]byte("users:\n  - email: a@example.com\n    team: x\n"))
+			return err
+		},
+		"unsupported extension": func() error {
+			path := filepath.Join(t.TempDir(), "users.ldif")
+			if err := os.WriteFile(path, []byte("dn: cn=alice\n"), 0o600); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+			_, err := LoadFile(path)
+			return err
+		},
+	}
+	for want, parse := range tests {
+		if err := parse(); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected an error containing %q, got %v", want, err)
+		}
+	}
+}
+
+func TestExampleFile(t *testing.T) {
+	if _, err := LoadFile(filepath.Join("..", "..", "directory.example.yaml")); err != nil {
+		t.Errorf("Failed to load example directory file: %v", err)
+	}
+}
+
+func gauge(value float64, labels ...string) *dto.Metric {
+	metric := &dto.Metric{Gauge: &dto.Gauge{Value: proto.Float64(value)}}
+	for i := 0; i < len(labels); i += 2 {
+		metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(labels[i]), Value: proto.String(labels[i+1])})
+	}
+	return metric
+}
+
+func labels(metric *dto.Metric) map[string]string {
+	labels := make(map[string]string)
+	for _, label := range metric.Label {
+		labels[label.GetName()] = label.GetValue()
+	}
+	return labels
+}
+
+func TestDirectory_Apply(t *testing.T) {
+	directory, err := New([]Entry{
+		{Email: "alice@example.com", Department: "Platform", Manager: "carol@example.com", CostCentre: "CC-1001"},
+		{Email: "bob@example.com", Department: "Platform", CostCentre: "CC-1001"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	families := directory.Apply([]*dto.MetricFamily{
+		{
+			Name: proto.String("cursor_spending_by_member_cents"),
+			Help: proto.String("Spending by team member in cents"),
+			Type: dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{
+				gauge(2500, "date", "2025-01-01", "member_email", "alice@example.com"),
+				gauge(500, "date", "2025-01-01", "member_email", "bob@example.com"),
+				gauge(100, "date", "2025-01-01", "member_email", "dave@example.com"),
+			},
+		},
+		{
+			Name:   proto.String("cursor_team_members_total"),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{gauge(4)},
+		},
+	})
+
+	if len(families) != 3 {
+		t.Fatalf("Expected the department aggregate to be appended, got %d families", len(families))
+	}
+
+	alice := labels(families[0].Metric[0])
+	if alice["department"] != "Platform" || alice["manager"] != "carol@example.com" || alice["cost_centre"] != "CC-1001" {
+		t.Errorf("Expected alice's directory labels, got %v", alice)
+	}
+	bob := labels(families[0].Metric[1])
+	if _, ok := bob["manager"]; ok {
+		t.Errorf("Expected no manager label for bob, got %v", bob)
+	}
+	if dave := labels(families[0].Metric[2]); dave["department"] != Unassigned || dave["cost_centre"] != Unassigned {
+		t.Errorf("Expected dave to be unassigned, got %v", dave)
+	}
+	if got := labels(families[1].Metric[0]); len(got) != 0 {
+		t.Errorf("Expected metrics without users untouched, got %v", got)
+	}
+
+	aggregate := families[2]
+	if aggregate.GetName() != "cursor_department_spending_cents" || aggregate.GetType() != dto.MetricType_GAUGE {
+		t.Fatalf("Expected a cursor_department_spending_cents gauge, got %s %s", aggregate.GetName(), aggregate.GetType())
+	}
+	totals := make(map[string]float64)
+	for _, metric := range aggregate.Metric {
+		l := labels(metric)
+		if _, ok := l["member_email"]; ok {
+			t.Errorf("Expected no member_email on the aggregate, got %v", l)
+		}
+		if l["date"] != "2025-01-01" {
+			t.Errorf("Expected the date label to be kept, got %v", l)
+		}
+		totals[l["department"]+"/"+l["cost_centre"]] = metric.GetGauge().GetValue()
+	}
+	if len(totals) != 2 || totals["Platform/CC-1001"] != 3000 || totals["unassigned/unassigned"] != 100 {
+		t.Errorf("Expected spending summed by department, got %v", totals)
+	}
+}
+
+func TestDirectory_Apply_Nil(t *testing.T) {
+	var directory *Directory
+	families := []*dto.MetricFamily{{Name: proto.String("cursor_spending_by_member_cents"), Metric: []*dto.Metric{gauge(1, "member_email", "a@example.com")}}}
+	if got := directory.Apply(families); len(got) != 1 || len(got[0].Metric[0].Label) != 1 {
+		t.Error("Expected a nil directory to leave metrics unchanged")
+	}
+}
@@ -0,0 +1,140 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/utils"
+)
+
+// backfillChunkDays is the longest date range requested from the daily
+// usage endpoint at once when fetching history.
+const backfillChunkDays = 30
+
+// dateLabel is the label of the daily usage metrics that Backfill replaces
+// with a timestamp.
+const dateLabel = "date"
+
+// Backfill fetches the daily usage of every team from start to end, both
+// days included, and returns the per-date metrics with the team label,
+// directory and privacy policy applied as for a scrape. Cardinality limits
+// are not applied: they are meant for the series of a scrape, and a limit on
+// dates would drop most of the history. Each sample has the timestamp of the
+// start of its day, in local time, instead of a date label, so the families
+// can be written to an OpenMetrics file for promtool tsdb
+// create-blocks-from openmetrics.
+func (t Teams) Backfill(ctx context.Context, start, end time.Time) ([]*dto.MetricFamily, error) {
+	gatherers := make(prometheus.Gatherers, 0, len(t))
+	for _, team := range t {
+		usage, err := team.Exporter.dailyUsageHistory(ctx, start, end)
+		if err != nil {
+			return nil, team.wrap(err)
+		}
+		gatherer, err := team.rewritingGatherer(&historyCollector{
+			exporter: NewDailyUsageExporter(team.Exporter.client),
+			usage:    usage,
+		}, team.labels(), team.Exporter.relabel)
+		if err != nil {
+			return nil, team.wrap(err)
+		}
+		gatherers = append(gatherers, gatherer)
+	}
+
+	families, err := gatherers.Gather()
+	if err != nil {
+		return nil, err
+	}
+	return timestampDates(families)
+}
+
+// dailyUsageHistory fetches the daily usage from start to end in ranges of
+// at most backfillChunkDays days.
+func (e *CursorExporter) dailyUsageHistory(ctx context.Context, start, end time.Time) ([]client.UserDailyUsage, error) {
+	var usage []client.UserDailyUsage
+	for from := start; !from.After(end); from = from.AddDate(0, 0, backfillChunkDays) {
+		to := from.AddDate(0, 0, backfillChunkDays-1)
+		if to.After(end) {
+			to = end
+		}
+		startDate, endDate := from.Format("2006-01-02"), to.Format("2006-01-02")
+		logrus.WithFields(logrus.Fields{"start": startDate, "end": endDate}).Debug("Fetching daily usage history")
+
+		chunk, err := e.client.GetUserDailyUsageContext(ctx, startDate, endDate)
+		if err != nil {
+			return nil, err
+		}
+		usage = append(usage, chunk...)
+	}
+	return usage, nil
+}
+
+// historyCollector collects the per-date metrics of a fetched daily usage
+// history.
+type historyCollector struct {
+	exporter *DailyUsageExporter
+	usage    []client.UserDailyUsage
+}
+
+func (c *historyCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.exporter.Describe(ch)
+}
+
+func (c *historyCollector) Collect(ch chan<- prometheus.Metric) {
+	c.exporter.collectDays(ch, c.usage)
+}
+
+// timestampDates replaces the date label of every series with the timestamp
+// of the start of the day and drops the families without a date, such as
+// the cardinality self-metric. The series of each family are sorted by
+// labels, then time, because OpenMetrics requires the samples of a series to
+// be contiguous and in order.
+func timestampDates(families []*dto.MetricFamily) ([]*dto.MetricFamily, error) {
+	dated := families[:0]
+	for _, family := range families {
+		metrics := family.Metric[:0]
+		for _, metric := range family.Metric {
+			day := ""
+			labels := make([]*dto.LabelPair, 0, len(metric.Label))
+			for _, label := range metric.Label {
+				if label.GetName() == dateLabel {
+					day = label.GetValue()
+					continue
+				}
+				labels = append(labels, label)
+			}
+			if day == "" {
+				continue
+			}
+
+			t, err := time.ParseInLocation("2006-01-02", day, time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid date %q: %w", family.GetName(), day, err)
+			}
+			metric.Label = labels
+			metric.TimestampMs = proto.Int64(t.UnixMilli())
+			metrics = append(metrics, metric)
+		}
+		if len(metrics) == 0 {
+			continue
+		}
+
+		sort.SliceStable(metrics, func(i, j int) bool {
+			a, b := utils.LabelKey(metrics[i].Label), utils.LabelKey(metrics[j].Label)
+			if a != b {
+				return a < b
+			}
+			return metrics[i].GetTimestampMs() < metrics[j].GetTimestampMs()
+		})
+		family.Metric = metrics
+		dated = append(dated, family)
+	}
+	return dated, nil
+}
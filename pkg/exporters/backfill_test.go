@@ -0,0 +1,115 @@
+package exporters
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/cardinality"
+)
+
+func TestTeams_Backfill(t *testing.T) {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2025, month, d, 0, 0, 0, 0, time.Local)
+	}
+	rows := []dailyUsageRow{
+		{Date: day(time.January, 5).Add(12 * time.Hour).UnixMilli(), Email: "alice@example.com", TotalLinesAdded: 10},
+		{Date: day(time.February, 10).Add(12 * time.Hour).UnixMilli(), Email: "alice@example.com", TotalLinesAdded: 20},
+		{Date: day(time.February, 10).Add(12 * time.Hour).UnixMilli(), Email: "bob@example.com", TotalLinesAdded: 5},
+	}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			StartDate int64 `json:"startDate"`
+			EndDate   int64 `json:"endDate"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		requests++
+
+		var response struct {
+			Data []dailyUsageRow `json:"data"`
+		}
+		for _, row := range rows {
+			if row.Date >= body.StartDate && row.Date <= body.EndDate {
+				response.Data = append(response.Data, row)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Logf("Failed to encode response: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	// A limit keeping only the latest date of a scrape must not cut the
+	// history short.
+	opts := DefaultOptions()
+	limits, err := cardinality.New([]cardinality.Limit{{Metric: "cursor_daily_*", Label: "date", Mode: cardinality.Latest, MaxValues: 1}})
+	if err != nil {
+		t.Fatalf("Failed to create limiter: %v", err)
+	}
+	opts.Limits = limits
+	exporter := NewCursorExporterWithOptions(server.URL, "test-token", opts)
+	families, err := (Teams{{Name: "sales", Exporter: exporter}}).Backfill(context.Background(), day(time.January, 1), day(time.February, 15))
+	if err != nil {
+		t.Fatalf("Failed to backfill: %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected 46 days to be fetched in 2 requests, got %d", requests)
+	}
+
+	byName := make(map[string]*dto.MetricFamily)
+	for _, family := range families {
+		if strings.HasPrefix(family.GetName(), "cursor_window_") || family.GetName() == cardinality.FoldedMetric {
+			t.Errorf("Expected no rolling window or cardinality metrics, got %s", family.GetName())
+		}
+		byName[family.GetName()] = family
+	}
+
+	user := byName["cursor_daily_user_lines_added_total"]
+	if user == nil || len(user.Metric) != 3 {
+		t.Fatalf("Expected 3 per-user samples, got %v", user)
+	}
+	want := []struct {
+		email string
+		day   time.Time
+		value float64
+	}{
+		{"alice@example.com", day(time.January, 5), 10},
+		{"alice@example.com", day(time.February, 10), 20},
+		{"bob@example.com", day(time.February, 10), 5},
+	}
+	for i, w := range want {
+		metric := user.Metric[i]
+		labels := make(map[string]string)
+		for _, label := range metric.Label {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if _, ok := labels["date"]; ok {
+			t.Errorf("Expected the date label to be replaced with a timestamp, got %v", labels)
+		}
+		if labels["user_email"] != w.email || labels["team"] != "sales" {
+			t.Errorf("Expected %s of team sales, got %v", w.email, labels)
+		}
+		if got := metric.GetTimestampMs(); got != w.day.UnixMilli() {
+			t.Errorf("Expected the timestamp of %s, got %s", w.day.Format("2006-01-02"), time.UnixMilli(got).Format(time.RFC3339))
+		}
+		if got := metric.GetGauge().GetValue(); got != w.value {
+			t.Errorf("Expected %v, got %v", w.value, got)
+		}
+	}
+
+	team := byName["cursor_daily_lines_added_total"]
+	if team == nil || len(team.Metric) != 2 || team.Metric[1].GetGauge().GetValue() != 25 {
+		t.Errorf("Expected the team total of 25 on the second day, got %v", team)
+	}
+}
@@ -1,6 +1,7 @@
 package exporters
 
 import (
+	"context"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -10,7 +11,9 @@ import (
 )
 
 type DailyUsageExporter struct {
-	client *client.CursorClient
+	client       *client.CursorClient
+	lookbackDays int
+	cycle        *billingCycle
 
 	linesAdded               *prometheus.Desc
 	linesDeleted             *prometheus.Desc
@@ -20,11 +23,27 @@ type DailyUsageExporter struct {
 	chatRequests             *prometheus.Desc
 	modelUsage               *prometheus.Desc
 	extensionUsage           *prometheus.Desc
+
+	userLinesAdded       *prometheus.Desc
+	userLinesDeleted     *prometheus.Desc
+	userAccepts          *prometheus.Desc
+	userRejects          *prometheus.Desc
+	userTabsAccepted     *prometheus.Desc
+	userComposerRequests *prometheus.Desc
+	userChatRequests     *prometheus.Desc
+
+	windowLinesAdded               *prometheus.Desc
+	windowLinesDeleted             *prometheus.Desc
+	windowSuggestionAcceptanceRate *prometheus.Desc
+	windowTabsUsed                 *prometheus.Desc
+	windowComposerUsed             *prometheus.Desc
+	windowChatRequests             *prometheus.Desc
 }
 
 func NewDailyUsageExporter(client *client.CursorClient) *DailyUsageExporter {
 	return &DailyUsageExporter{
-		client: client,
+		client:       client,
+		lookbackDays: defaultLookbackDays,
 
 		linesAdded: prometheus.NewDesc(
 			"cursor_daily_lines_added_total",
@@ -81,6 +100,97 @@ func NewDailyUsageExporter(client *client.CursorClient) *DailyUsageExporter {
 			[]string{"date", "extension"},
 			nil,
 		),
+
+		userLinesAdded: prometheus.NewDesc(
+			"cursor_daily_user_lines_added_total",
+			"Lines of code added per user per day",
+			[]string{"date", "user_email"},
+			nil,
+		),
+
+		userLinesDeleted: prometheus.NewDesc(
+			"cursor_daily_user_lines_deleted_total",
+			"Lines of code deleted per user per day",
+			[]string{"date", "user_email"},
+			nil,
+		),
+
+		userAccepts: prometheus.NewDesc(
+			"cursor_daily_user_accepts_total",
+			"AI suggestions accepted per user per day",
+			[]string{"date", "user_email"},
+			nil,
+		),
+
+		userRejects: prometheus.NewDesc(
+			"cursor_daily_user_rejects_total",
+			"AI suggestions rejected per user per day",
+			[]string{"date", "user_email"},
+			nil,
+		),
+
+		userTabsAccepted: prometheus.NewDesc(
+			"cursor_daily_user_tabs_accepted_total",
+			"Tab completions accepted per user per day",
+			[]string{"date", "user_email"},
+			nil,
+		),
+
+		userComposerRequests: prometheus.NewDesc(
+			"cursor_daily_user_composer_requests_total",
+			"Composer requests per user per day",
+			[]string{"date", "user_email"},
+			nil,
+		),
+
+		userChatRequests: prometheus.NewDesc(
+			"cursor_daily_user_chat_requests_total",
+			"Chat requests per user per day",
+			[]string{"date", "user_email"},
+			nil,
+		),
+
+		windowLinesAdded: prometheus.NewDesc(
+			"cursor_window_lines_added",
+			"Lines of code added within the window",
+			[]string{"window"},
+			nil,
+		),
+
+		windowLinesDeleted: prometheus.NewDesc(
+			"cursor_window_lines_deleted",
+			"Lines of code deleted within the window",
+			[]string{"window"},
+			nil,
+		),
+
+		windowSuggestionAcceptanceRate: prometheus.NewDesc(
+			"cursor_window_suggestion_acceptance_rate",
+			"AI suggestion acceptance rate within the window",
+			[]string{"window"},
+			nil,
+		),
+
+		windowTabsUsed: prometheus.NewDesc(
+			"cursor_window_tabs_used",
+			"Tabs used within the window",
+			[]string{"window"},
+			nil,
+		),
+
+		windowComposerUsed: prometheus.NewDesc(
+			"cursor_window_composer_used",
+			"Composer usage within the window",
+			[]string{"window"},
+			nil,
+		),
+
+		windowChatRequests: prometheus.NewDesc(
+			"cursor_window_chat_requests",
+			"Chat requests within the window",
+			[]string{"window"},
+			nil,
+		),
 	}
 }
 
@@ -93,19 +203,60 @@ func (e *DailyUsageExporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.chatRequests
 	ch <- e.modelUsage
 	ch <- e.extensionUsage
+	ch <- e.userLinesAdded
+	ch <- e.userLinesDeleted
+	ch <- e.userAccepts
+	ch <- e.userRejects
+	ch <- e.userTabsAccepted
+	ch <- e.userComposerRequests
+	ch <- e.userChatRequests
+	ch <- e.windowLinesAdded
+	ch <- e.windowLinesDeleted
+	ch <- e.windowSuggestionAcceptanceRate
+	ch <- e.windowTabsUsed
+	ch <- e.windowComposerUsed
+	ch <- e.windowChatRequests
 }
 
 func (e *DailyUsageExporter) Collect(ch chan<- prometheus.Metric) {
-	endDate := time.Now().Format("2006-01-02")
-	startDate := time.Now().AddDate(0, 0, -30).Format("2006-01-02")
+	if err := e.Update(context.Background(), ch); err != nil {
+		logrus.WithError(err).Error("Failed to get daily usage")
+	}
+}
+
+func (e *DailyUsageExporter) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	now := time.Now()
+	lookbackStart := startOfDay(now.AddDate(0, 0, -e.lookbackDays))
+	windows := rollingWindows(now, e.cycle.Start())
 
-	usage, err := e.client.GetDailyUsage(startDate, endDate)
+	endDate := now.Format("2006-01-02")
+	startDate := fetchStart(lookbackStart, windows).Format("2006-01-02")
+
+	fetched, err := e.client.GetUserDailyUsageContext(ctx, startDate, endDate)
 	if err != nil {
-		logrus.WithError(err).Error("Failed to get daily usage")
-		return
+		return err
+	}
+
+	e.collectWindows(ch, fetched, windows)
+
+	// Windows may need more history than the lookback; per-date series only
+	// cover the lookback.
+	lookbackDate := lookbackStart.Format("2006-01-02")
+	var userUsage []client.UserDailyUsage
+	for _, daily := range fetched {
+		if daily.Date >= lookbackDate {
+			userUsage = append(userUsage, daily)
+		}
 	}
 
-	for _, daily := range usage {
+	e.collectDays(ch, userUsage)
+	return nil
+}
+
+// collectDays sends the team-wide and per-user metrics of every day in
+// userUsage, labelled with the date.
+func (e *DailyUsageExporter) collectDays(ch chan<- prometheus.Metric, userUsage []client.UserDailyUsage) {
+	for _, daily := range client.AggregateDailyUsage(userUsage) {
 		ch <- prometheus.MustNewConstMetric(
 			e.linesAdded,
 			prometheus.GaugeValue,
@@ -168,4 +319,98 @@ func (e *DailyUsageExporter) Collect(ch chan<- prometheus.Metric) {
 			)
 		}
 	}
+
+	for _, daily := range userUsage {
+		if daily.UserEmail == "" {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			e.userLinesAdded,
+			prometheus.GaugeValue,
+			float64(daily.LinesAdded),
+			daily.Date,
+			daily.UserEmail,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			e.userLinesDeleted,
+			prometheus.GaugeValue,
+			float64(daily.LinesDeleted),
+			daily.Date,
+			daily.UserEmail,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			e.userAccepts,
+			prometheus.GaugeValue,
+			float64(daily.Accepts),
+			daily.Date,
+			daily.UserEmail,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			e.userRejects,
+			prometheus.GaugeValue,
+			float64(daily.Rejects),
+			daily.Date,
+			daily.UserEmail,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			e.userTabsAccepted,
+			prometheus.GaugeValue,
+			float64(daily.TabsAccepted),
+			daily.Date,
+			daily.UserEmail,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			e.userComposerRequests,
+			prometheus.GaugeValue,
+			float64(daily.ComposerRequests),
+			daily.Date,
+			daily.UserEmail,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			e.userChatRequests,
+			prometheus.GaugeValue,
+			float64(daily.ChatRequests),
+			daily.Date,
+			daily.UserEmail,
+		)
+	}
+}
+
+func (e *DailyUsageExporter) collectWindows(ch chan<- prometheus.Metric, userUsage []client.UserDailyUsage, windows []window) {
+	for _, w := range windows {
+		startDate := w.start.Format("2006-01-02")
+
+		var total client.UserDailyUsage
+		for _, daily := range userUsage {
+			if daily.Date < startDate {
+				continue
+			}
+			total.LinesAdded += daily.LinesAdded
+			total.LinesDeleted += daily.LinesDeleted
+			total.Accepts += daily.Accepts
+			total.Rejects += daily.Rejects
+			total.TabsAccepted += daily.TabsAccepted
+			total.ComposerRequests += daily.ComposerRequests
+			total.ChatRequests += daily.ChatRequests
+		}
+
+		rate := 0.0
+		if suggestions := total.Accepts + total.Rejects; suggestions > 0 {
+			rate = float64(total.Accepts) / float64(suggestions)
+		}
+
+		ch <- prometheus.MustNewConstMetric(e.windowLinesAdded, prometheus.GaugeValue, float64(total.LinesAdded), w.name)
+		ch <- prometheus.MustNewConstMetric(e.windowLinesDeleted, prometheus.GaugeValue, float64(total.LinesDeleted), w.name)
+		ch <- prometheus.MustNewConstMetric(e.windowSuggestionAcceptanceRate, prometheus.GaugeValue, rate, w.name)
+		ch <- prometheus.MustNewConstMetric(e.windowTabsUsed, prometheus.GaugeValue, float64(total.TabsAccepted), w.name)
+		ch <- prometheus.MustNewConstMetric(e.windowComposerUsed, prometheus.GaugeValue, float64(total.ComposerRequests), w.name)
+		ch <- prometheus.MustNewConstMetric(e.windowChatRequests, prometheus.GaugeValue, float64(total.ChatRequests), w.name)
+	}
 }
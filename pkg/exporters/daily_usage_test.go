@@ -0,0 +1,199 @@
+package exporters
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type dailyUsageRow struct {
+	Date              int64  `json:"date"`
+	Email             string `json:"email"`
+	TotalLinesAdded   int    `json:"totalLinesAdded"`
+	TotalLinesDeleted int    `json:"totalLinesDeleted"`
+	TotalAccepts      int    `json:"totalAccepts"`
+	TotalRejects      int    `json:"totalRejects"`
+	TotalTabsAccepted int    `json:"totalTabsAccepted"`
+	ComposerRequests  int    `json:"composerRequests"`
+	ChatRequests      int    `json:"chatRequests"`
+	MostUsedModel     string `json:"mostUsedModel"`
+}
+
+func newDailyUsageServer(t *testing.T, rows []dailyUsageRow) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/teams/daily-usage-data" {
+			t.Errorf("Expected path /teams/daily-usage-data, got %s", r.URL.Path)
+		}
+
+		response := struct {
+			Data []dailyUsageRow `json:"data"`
+		}{
+			Data: rows,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Logf("Failed to encode response: %v", err)
+		}
+	}))
+}
+
+func collectMetrics(c prometheus.Collector) []prometheus.Metric {
+	ch := make(chan prometheus.Metric, 100)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	metrics := []prometheus.Metric{}
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func TestDailyUsageExporter_Collect_PerUser(t *testing.T) {
+	day := time.Now().AddDate(0, 0, -1)
+	day = time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, time.Local)
+
+	server := newDailyUsageServer(t, []dailyUsageRow{
+		{Date: day.UnixMilli(), Email: "alice@example.com", TotalLinesAdded: 100, TotalLinesDeleted: 10, TotalAccepts: 8, TotalRejects: 2, TotalTabsAccepted: 30, ComposerRequests: 4, ChatRequests: 6, MostUsedModel: "gpt-4"},
+		{Date: day.UnixMilli(), Email: "bob@example.com", TotalLinesAdded: 50, TotalLinesDeleted: 5, TotalAccepts: 2, TotalRejects: 8, TotalTabsAccepted: 10, ComposerRequests: 1, ChatRequests: 2, MostUsedModel: "gpt-4"},
+	})
+	defer server.Close()
+
+	exporter := NewDailyUsageExporter(client.NewCursorClient(server.URL, "test-token"))
+	metrics := collectMetrics(exporter)
+
+	teamLines := findMetric(metrics, "cursor_daily_lines_added_total")
+	if teamLines == nil {
+		t.Fatal("Expected cursor_daily_lines_added_total metric")
+	}
+	if got := dtoMetric(teamLines).GetGauge().GetValue(); got != 150 {
+		t.Errorf("Expected team lines added 150, got %f", got)
+	}
+
+	teamRate := findMetric(metrics, "cursor_daily_suggestion_acceptance_rate")
+	if got := dtoMetric(teamRate).GetGauge().GetValue(); got != 0.5 {
+		t.Errorf("Expected team acceptance rate 0.5, got %f", got)
+	}
+
+	aliceLines := findMetricWithLabel(metrics, "cursor_daily_user_lines_added_total", "user_email", "alice@example.com")
+	if aliceLines == nil {
+		t.Fatal("Expected cursor_daily_user_lines_added_total for alice")
+	}
+	if got := dtoMetric(aliceLines).GetGauge().GetValue(); got != 100 {
+		t.Errorf("Expected alice lines added 100, got %f", got)
+	}
+
+	bobRejects := findMetricWithLabel(metrics, "cursor_daily_user_rejects_total", "user_email", "bob@example.com")
+	if bobRejects == nil {
+		t.Fatal("Expected cursor_daily_user_rejects_total for bob")
+	}
+	if got := dtoMetric(bobRejects).GetGauge().GetValue(); got != 8 {
+		t.Errorf("Expected bob rejects 8, got %f", got)
+	}
+
+	bobTabs := findMetricWithLabel(metrics, "cursor_daily_user_tabs_accepted_total", "user_email", "bob@example.com")
+	if got := dtoMetric(bobTabs).GetGauge().GetValue(); got != 10 {
+		t.Errorf("Expected bob tabs accepted 10, got %f", got)
+	}
+}
+
+func TestDailyUsageExporter_Collect_SkipsRowsWithoutEmail(t *testing.T) {
+	day := time.Now().AddDate(0, 0, -1)
+
+	server := newDailyUsageServer(t, []dailyUsageRow{
+		{Date: day.UnixMilli(), TotalLinesAdded: 20},
+	})
+	defer server.Close()
+
+	exporter := NewDailyUsageExporter(client.NewCursorClient(server.URL, "test-token"))
+	metrics := collectMetrics(exporter)
+
+	if m := findMetric(metrics, "cursor_daily_user_lines_added_total"); m != nil {
+		t.Error("Expected no per-user metrics for rows without an email")
+	}
+	if m := findMetric(metrics, "cursor_daily_lines_added_total"); m == nil {
+		t.Error("Expected team totals to include rows without an email")
+	}
+}
+
+func TestDailyUsageExporter_Collect_Windows(t *testing.T) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	cycleStart := today.AddDate(0, 0, -25)
+
+	var requestedStart int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqBody struct {
+			StartDate int64 `json:"startDate"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		requestedStart = reqBody.StartDate
+
+		response := struct {
+			Data []dailyUsageRow `json:"data"`
+		}{
+			Data: []dailyUsageRow{
+				{Date: today.Add(time.Hour).UnixMilli(), Email: "alice@example.com", TotalLinesAdded: 1, TotalAccepts: 1, TotalRejects: 1},
+				{Date: today.AddDate(0, 0, -3).Add(time.Hour).UnixMilli(), Email: "alice@example.com", TotalLinesAdded: 10, TotalAccepts: 3},
+				{Date: today.AddDate(0, 0, -20).Add(time.Hour).UnixMilli(), Email: "alice@example.com", TotalLinesAdded: 100},
+			},
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Logf("Failed to encode response: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	exporter := NewDailyUsageExporter(client.NewCursorClient(server.URL, "test-token"))
+	exporter.lookbackDays = 2
+	exporter.cycle = &billingCycle{}
+	exporter.cycle.set(cycleStart)
+
+	metrics := collectMetrics(exporter)
+
+	startDate := time.UnixMilli(requestedStart).UTC().Format("2006-01-02")
+	if startDate != cycleStart.Format("2006-01-02") {
+		t.Errorf("Expected fetch to start at the billing cycle %s, got %s", cycleStart.Format("2006-01-02"), startDate)
+	}
+
+	expected := map[string]float64{
+		windowToday:        1,
+		windowLast7Days:    11,
+		windowBillingCycle: 111,
+	}
+	for window, want := range expected {
+		m := findMetricWithLabel(metrics, "cursor_window_lines_added", "window", window)
+		if m == nil {
+			t.Fatalf("Expected cursor_window_lines_added{window=%q}", window)
+		}
+		if got := dtoMetric(m).GetGauge().GetValue(); got != want {
+			t.Errorf("Expected %s lines added %f, got %f", window, want, got)
+		}
+	}
+
+	rate := findMetricWithLabel(metrics, "cursor_window_suggestion_acceptance_rate", "window", windowLast7Days)
+	if got := dtoMetric(rate).GetGauge().GetValue(); got != 0.8 {
+		t.Errorf("Expected 7d acceptance rate 0.8, got %f", got)
+	}
+
+	perDate := 0
+	for _, m := range metrics {
+		if strings.Contains(m.Desc().String(), `"cursor_daily_lines_added_total"`) {
+			perDate++
+		}
+	}
+	if perDate != 1 {
+		t.Errorf("Expected per-date series limited to the 2 day lookback (1 date), got %d", perDate)
+	}
+}
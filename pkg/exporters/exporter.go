@@ -1,12 +1,22 @@
 package exporters
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/sirupsen/logrus"
 
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/cardinality"
 	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/directory"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/privacy"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/state"
 )
 
 type CursorExporter struct {
@@ -16,19 +26,61 @@ type CursorExporter struct {
 	spendingExporter    *SpendingExporter
 	usageEventsExporter *UsageEventsExporter
 
+	// mu guards collectors and the sub-exporters, which Reload replaces.
+	mu         sync.RWMutex
+	collectors []*scheduledCollector
+	started    atomic.Bool
+	cycle      *billingCycle
+	roster     *teamRoster
+
+	// reloadMu serializes Start and Reload. runCtx is the context passed to
+	// Start, and stopRuns stops the background refreshes started from it.
+	reloadMu sync.Mutex
+	runCtx   context.Context
+	stopRuns context.CancelFunc
+	runs     sync.WaitGroup
+
+	stateStore         state.Store
+	stateFlushInterval time.Duration
+
+	// team is the name of the team in log messages, if any.
+	team string
+
+	// directory, privacy and limits rewrite the gathered metrics, see
+	// rewrite. They are guarded by mu as Reload replaces them.
+	directory *directory.Directory
+	privacy   *privacy.Policy
+	limits    *cardinality.Limiter
+
 	scrapeDuration prometheus.Histogram
 	scrapeErrors   prometheus.Counter
+	snapshotAge    *prometheus.Desc
+	// collectorSuccess, collectorDuration and lastSuccess describe the most
+	// recent refresh of each collector.
+	collectorSuccess  *prometheus.Desc
+	collectorDuration *prometheus.Desc
+	lastSuccess       *prometheus.Desc
+	// apiRetries and apiThrottled are nil unless the exporter was built by
+	// NewCursorExporterWithOptions, which hooks them into the client.
+	apiRetries   *prometheus.CounterVec
+	apiThrottled *prometheus.CounterVec
 }
 
 func NewCursorExporter(baseURL, token string) *CursorExporter {
+	return NewCursorExporterWithOptions(baseURL, token, DefaultOptions())
+}
+
+func NewCursorExporterWithOptions(baseURL, token string, opts Options) *CursorExporter {
 	cursorClient := client.NewCursorClient(baseURL, token)
+	cursorClient.Token = opts.TokenSource
 
-	return &CursorExporter{
-		client:              cursorClient,
-		teamMembersExporter: NewTeamMembersExporter(cursorClient),
-		dailyUsageExporter:  NewDailyUsageExporter(cursorClient),
-		spendingExporter:    NewSpendingExporter(cursorClient),
-		usageEventsExporter: NewUsageEventsExporter(cursorClient),
+	e := &CursorExporter{
+		client:             cursorClient,
+		cycle:              &billingCycle{},
+		roster:             &teamRoster{},
+		stateStore:         opts.StateStore,
+		stateFlushInterval: opts.StateFlushInterval,
+		team:               opts.Team,
 
 		scrapeDuration: prometheus.NewHistogram(
 			prometheus.HistogramOpts{
@@ -43,75 +95,519 @@ func NewCursorExporter(baseURL, token string) *CursorExporter {
 				Help: "Total number of scrape errors",
 			},
 		),
+
+		apiRetries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "cursor_exporter_api_retries_total",
+				Help: "Total number of Cursor API requests retried, by endpoint and reason",
+			},
+			[]string{"endpoint", "reason"},
+		),
+
+		apiThrottled: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "cursor_exporter_api_requests_throttled_total",
+				Help: "Total number of Cursor API requests delayed by the client-side rate limiter, by endpoint",
+			},
+			[]string{"endpoint"},
+		),
+	}
+	cursorClient.OnRetry = func(endpoint, reason string) {
+		e.apiRetries.WithLabelValues(endpoint, reason).Inc()
 	}
+	cursorClient.OnThrottle = func(endpoint string) {
+		e.apiThrottled.WithLabelValues(endpoint).Inc()
+	}
+
+	e.configure(opts)
+
+	return e
+}
+
+// configure applies opts to the client and the sub-exporters. Only enabled
+// collectors are built: disabled ones are dropped and newly enabled ones
+// start empty, while those that stay enabled keep their state. The billing
+// cycle and roster stay empty if the spending or team members collector that
+// fills them is disabled.
+func (e *CursorExporter) configure(opts Options) {
+	e.client.HTTPClient.Timeout = opts.RequestTimeout
+	e.client.Retry = opts.Retry
+	e.client.RateLimiter = client.NewRateLimiter(opts.RateLimit, opts.EndpointRateLimits)
+	e.directory = opts.Directory
+	e.privacy = opts.Privacy
+	if !e.limits.Equal(opts.Limits) {
+		// Unchanged limits keep their state, so folded counters carry on
+		// from where they were.
+		e.limits = opts.Limits
+	}
+
+	if !opts.TeamMembers.Enabled {
+		e.teamMembersExporter = nil
+	} else if e.teamMembersExporter == nil {
+		e.teamMembersExporter = NewTeamMembersExporter(e.client)
+		e.teamMembersExporter.roster = e.roster
+	}
+
+	if !opts.DailyUsage.Enabled {
+		e.dailyUsageExporter = nil
+	} else {
+		if e.dailyUsageExporter == nil {
+			e.dailyUsageExporter = NewDailyUsageExporter(e.client)
+			e.dailyUsageExporter.cycle = e.cycle
+		}
+		e.dailyUsageExporter.lookbackDays = opts.DailyUsage.LookbackDays
+	}
+
+	if !opts.Spending.Enabled {
+		e.spendingExporter = nil
+	} else {
+		if e.spendingExporter == nil {
+			e.spendingExporter = NewSpendingExporter(e.client)
+			e.spendingExporter.cycle = e.cycle
+			e.spendingExporter.roster = e.roster
+		}
+		e.spendingExporter.pageSize = opts.Spending.PageSize
+		e.spendingExporter.setBudgets(opts.Budgets)
+	}
+
+	if !opts.UsageEvents.Enabled {
+		e.usageEventsExporter = nil
+	} else {
+		if e.usageEventsExporter == nil {
+			e.usageEventsExporter = NewUsageEventsExporter(e.client)
+			e.usageEventsExporter.cycle = e.cycle
+		}
+		e.usageEventsExporter.lookbackDays = opts.UsageEvents.LookbackDays
+		e.usageEventsExporter.pageSize = opts.UsageEvents.PageSize
+		e.usageEventsExporter.setPricing(opts.Pricing)
+	}
+
+	e.schedule(opts)
+}
+
+// schedule wraps each sub-exporter that was built in a scheduledCollector
+// using the intervals, timeout and concurrency limit from opts. Collectors
+// that were already scheduled for the same sub-exporter are reused so they
+// keep serving their snapshot.
+func (e *CursorExporter) schedule(opts Options) {
+	previous := make(map[updater]*scheduledCollector, len(e.collectors))
+	for _, c := range e.collectors {
+		previous[c.collector] = c
+	}
+
+	var slots chan struct{}
+	if opts.Concurrency > 0 {
+		slots = make(chan struct{}, opts.Concurrency)
+	}
+
+	e.collectors = nil
+	add := func(name string, collector updater, o CollectorOptions) {
+		c, ok := previous[collector]
+		if !ok {
+			c = newScheduledCollector(name, collector, o.Interval)
+		}
+		c.interval = o.Interval
+		c.timeout = opts.CollectorTimeout
+		c.slots = slots
+		e.collectors = append(e.collectors, c)
+	}
+	if e.teamMembersExporter != nil {
+		add(TeamMembersCollector, e.teamMembersExporter, opts.TeamMembers)
+	}
+	if e.dailyUsageExporter != nil {
+		add(DailyUsageCollector, e.dailyUsageExporter, opts.DailyUsage)
+	}
+	if e.spendingExporter != nil {
+		add(SpendingCollector, e.spendingExporter, opts.Spending)
+	}
+	if e.usageEventsExporter != nil {
+		add(UsageEventsCollector, e.usageEventsExporter, opts.UsageEvents)
+	}
+
+	if e.snapshotAge != nil {
+		return
+	}
+	e.snapshotAge = prometheus.NewDesc(
+		"cursor_exporter_snapshot_age_seconds",
+		"Seconds since the collector last refreshed its data from the Cursor API",
+		[]string{"collector"},
+		nil,
+	)
+	e.collectorSuccess = prometheus.NewDesc(
+		"cursor_exporter_collector_success",
+		"Whether the collector's last refresh from the Cursor API succeeded",
+		[]string{"collector"},
+		nil,
+	)
+	e.collectorDuration = prometheus.NewDesc(
+		"cursor_exporter_collector_duration_seconds",
+		"Time the collector's last refresh from the Cursor API took",
+		[]string{"collector"},
+		nil,
+	)
+	e.lastSuccess = prometheus.NewDesc(
+		"cursor_exporter_last_success_timestamp_seconds",
+		"Unix time of the collector's last successful refresh from the Cursor API",
+		[]string{"collector"},
+		nil,
+	)
+}
+
+// Start refreshes every collector in the background on its own interval until
+// ctx is cancelled. Once started, Collect serves the cached snapshots and no
+// longer calls the Cursor API itself. If a state store is configured, saved
+// state is loaded before the first refresh and flushed periodically.
+func (e *CursorExporter) Start(ctx context.Context) {
+	e.reloadMu.Lock()
+	defer e.reloadMu.Unlock()
+
+	if !e.started.CompareAndSwap(false, true) {
+		return
+	}
+
+	if e.stateStore != nil {
+		if err := e.LoadState(); err != nil {
+			e.logger().WithError(err).Warn("Failed to load exporter state, starting fresh")
+		}
+		go e.flushState(ctx)
+	}
+
+	e.runCtx = ctx
+	e.startRuns()
+}
+
+// Reload applies opts without restarting the exporter. Collectors that stay
+// enabled keep their snapshots, counters and cursors; disabled collectors
+// stop and newly enabled ones start. Refreshes in flight are aborted and
+// run again right away, without counting as failures. The API URL, token and
+// state store cannot be changed.
+func (e *CursorExporter) Reload(opts Options) {
+	e.reloadMu.Lock()
+	defer e.reloadMu.Unlock()
+
+	if e.stopRuns != nil {
+		e.stopRuns()
+		e.runs.Wait()
+	}
+
+	// Holding every refresh lock guarantees no collector is using the
+	// client or its sub-exporter while they are reconfigured.
+	e.mu.Lock()
+	previous := e.collectors
+	for _, c := range previous {
+		c.refreshMu.Lock()
+	}
+	e.configure(opts)
+	for _, c := range previous {
+		c.refreshMu.Unlock()
+	}
+	e.mu.Unlock()
+
+	if e.started.Load() {
+		e.startRuns()
+	}
+}
+
+// startRuns refreshes every collector in the background until runCtx is
+// cancelled or stopRuns is called.
+func (e *CursorExporter) startRuns() {
+	ctx, cancel := context.WithCancel(e.runCtx)
+	e.stopRuns = cancel
+
+	for _, c := range e.currentCollectors() {
+		e.logger().WithFields(logrus.Fields{
+			"collector": c.name,
+			"interval":  c.interval,
+		}).Info("Starting background collector")
+		e.runs.Add(1)
+		go func(c *scheduledCollector) {
+			defer e.runs.Done()
+			c.run(ctx, e.handleRefreshError)
+		}(c)
+	}
+}
+
+// currentCollectors returns the collectors as of the last Reload.
+func (e *CursorExporter) currentCollectors() []*scheduledCollector {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.collectors
+}
+
+// rewrite applies the directory, the privacy policy and the cardinality
+// limits, as of the last Reload, to gathered metrics. The directory needs
+// the emails the privacy policy may hash or drop, and the limits come last
+// so the values they fold into "other" are not rewritten. labels are added
+// to the metrics the limits report with.
+func (e *CursorExporter) rewrite(families []*dto.MetricFamily, labels prometheus.Labels) []*dto.MetricFamily {
+	e.mu.RLock()
+	limits := e.limits
+	e.mu.RUnlock()
+	return limits.Apply(e.relabel(families), labels)
+}
+
+// relabel applies the directory and the privacy policy, as of the last
+// Reload, to gathered metrics, like rewrite without the cardinality limits.
+func (e *CursorExporter) relabel(families []*dto.MetricFamily) []*dto.MetricFamily {
+	e.mu.RLock()
+	dir, policy := e.directory, e.privacy
+	e.mu.RUnlock()
+	return policy.Apply(dir.Apply(families))
 }
 
 func (e *CursorExporter) Describe(ch chan<- *prometheus.Desc) {
-	e.teamMembersExporter.Describe(ch)
-	e.dailyUsageExporter.Describe(ch)
-	e.spendingExporter.Describe(ch)
-	e.usageEventsExporter.Describe(ch)
+	for _, c := range e.currentCollectors() {
+		c.collector.Describe(ch)
+	}
 	e.scrapeDuration.Describe(ch)
 	e.scrapeErrors.Describe(ch)
+	if e.apiRetries != nil {
+		e.apiRetries.Describe(ch)
+		e.apiThrottled.Describe(ch)
+	}
+	ch <- e.snapshotAge
+	ch <- e.collectorSuccess
+	ch <- e.collectorDuration
+	ch <- e.lastSuccess
 }
 
+// Collect serves the latest snapshot of every collector. If the exporter has
+// not been started, each collector is refreshed first so that Collect keeps
+// working for callers that do not run the background scheduler.
 func (e *CursorExporter) Collect(ch chan<- prometheus.Metric) {
+	e.CollectContext(context.Background(), ch)
+}
+
+// CollectContext is like Collect but gives up on refreshing collectors once
+// ctx is done, serving whatever snapshot they already have.
+func (e *CursorExporter) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	e.collectOnly(ctx, ch, e.currentCollectors())
+}
+
+// collectOnly is like CollectContext but only refreshes and serves the given
+// collectors. The exporter's own metrics are always sent.
+func (e *CursorExporter) collectOnly(ctx context.Context, ch chan<- prometheus.Metric, collectors []*scheduledCollector) {
 	start := time.Now()
 	defer func() {
 		duration := time.Since(start)
 		e.scrapeDuration.Observe(duration.Seconds())
 		e.scrapeDuration.Collect(ch)
 		e.scrapeErrors.Collect(ch)
+		if e.apiRetries != nil {
+			e.apiRetries.Collect(ch)
+			e.apiThrottled.Collect(ch)
+		}
 		logrus.WithField("total_duration", duration).Debug("Completed Cursor metrics collection")
 	}()
 
 	logrus.Debug("Starting Cursor metrics collection")
 
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				logrus.WithField("panic", r).Error("Panic during team members collection")
-				e.scrapeErrors.Inc()
+	if !e.started.Load() {
+		e.refreshAll(ctx, collectors)
+	}
+
+	for _, c := range collectors {
+		updatedAt := c.collect(ch)
+		if !updatedAt.IsZero() {
+			ch <- prometheus.MustNewConstMetric(
+				e.snapshotAge,
+				prometheus.GaugeValue,
+				time.Since(updatedAt).Seconds(),
+				c.name,
+			)
+		}
+		e.collectStatus(ch, c, updatedAt)
+	}
+}
+
+// refreshAll refreshes collectors in parallel, as many at once as the
+// concurrency limit allows, and waits for all of them to finish.
+func (e *CursorExporter) refreshAll(ctx context.Context, collectors []*scheduledCollector) {
+	var wg sync.WaitGroup
+	for _, c := range collectors {
+		wg.Add(1)
+		go func(c *scheduledCollector) {
+			defer wg.Done()
+			logrus.WithField("collector", c.name).Debug("Starting collection")
+			if err := c.refresh(ctx); err != nil {
+				e.handleRefreshError(c, err)
 			}
-		}()
-		logrus.Debug("Starting team members collection")
-		e.teamMembersExporter.Collect(ch)
-		logrus.Debug("Completed team members collection")
-	}()
+		}(c)
+	}
+	wg.Wait()
+}
+
+// collectStatus sends the self-metrics describing c's last refresh. Nothing
+// is sent for a collector that has not been refreshed yet.
+func (e *CursorExporter) collectStatus(ch chan<- prometheus.Metric, c *scheduledCollector, updatedAt time.Time) {
+	last := c.status()
+	if last.at.IsZero() {
+		return
+	}
+
+	success := 0.0
+	if last.success {
+		success = 1
+	}
+	ch <- prometheus.MustNewConstMetric(e.collectorSuccess, prometheus.GaugeValue, success, c.name)
+	ch <- prometheus.MustNewConstMetric(e.collectorDuration, prometheus.GaugeValue, last.duration.Seconds(), c.name)
+	if !updatedAt.IsZero() {
+		ch <- prometheus.MustNewConstMetric(e.lastSuccess, prometheus.GaugeValue, float64(updatedAt.UnixNano())/1e9, c.name)
+	}
+}
+
+// selectCollectors returns the collectors with the given names, in the order
+// the exporter runs them. It fails for names that are unknown or belong to a
+// disabled collector.
+func (e *CursorExporter) selectCollectors(names []string) ([]*scheduledCollector, error) {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var selected []*scheduledCollector
+	for _, c := range e.currentCollectors() {
+		if wanted[c.name] {
+			selected = append(selected, c)
+			delete(wanted, c.name)
+		}
+	}
+	for name := range wanted {
+		if !isCollectorName(name) {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+		return nil, fmt.Errorf("collector %q is disabled", name)
+	}
+	return selected, nil
+}
+
+func (e *CursorExporter) handleRefreshError(c *scheduledCollector, err error) {
+	e.scrapeErrors.Inc()
+
+	var perr *panicError
+	if errors.As(err, &perr) {
+		e.logger().WithFields(logrus.Fields{
+			"collector": c.name,
+			"panic":     perr.value,
+		}).Error("Panic during collection")
+		return
+	}
+
+	if client.IsAuthError(err) {
+		e.logger().WithError(err).WithField("collector", c.name).Error("Cursor API rejected the token, check that it is valid and has admin permissions")
+		return
+	}
+	if errors.Is(err, client.ErrRateLimited) {
+		e.logger().WithError(err).WithField("collector", c.name).Warn("Cursor API rate limit reached, consider lowering API_RATE_LIMIT")
+		return
+	}
+
+	e.logger().WithError(err).WithField("collector", c.name).Error("Failed to refresh collector")
+}
+
+// logger returns the logger for messages about the exporter, which name its
+// team if it has one.
+func (e *CursorExporter) logger() *logrus.Entry {
+	entry := logrus.NewEntry(logrus.StandardLogger())
+	if e.team != "" {
+		return entry.WithField("team", e.team)
+	}
+	return entry
+}
+
+// persistent is implemented by sub-exporters that keep state worth preserving
+// across restarts.
+type persistent interface {
+	saveState(state.Snapshot) error
+	loadState(state.Snapshot) error
+}
+
+type billingCycleState struct {
+	Start time.Time `json:"start"`
+}
+
+// LoadState restores state saved by SaveState. It is a no-op without a state
+// store.
+func (e *CursorExporter) LoadState() error {
+	if e.stateStore == nil {
+		return nil
+	}
+
+	snapshot, err := e.stateStore.Load()
+	if err != nil {
+		return err
+	}
+
+	var cycle billingCycleState
+	if _, err := snapshot.Get("billing_cycle", &cycle); err != nil {
+		return err
+	}
+	if !cycle.Start.IsZero() {
+		e.cycle.set(cycle.Start.Local())
+	}
+
+	var members []client.TeamMember
+	if _, err := snapshot.Get("team_roster", &members); err != nil {
+		return err
+	}
+	if len(members) > 0 {
+		e.roster.set(members)
+	}
 
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				logrus.WithField("panic", r).Error("Panic during daily usage collection")
-				e.scrapeErrors.Inc()
+	for _, c := range e.currentCollectors() {
+		if p, ok := c.collector.(persistent); ok {
+			if err := p.loadState(snapshot); err != nil {
+				return err
 			}
-		}()
-		logrus.Debug("Starting daily usage collection")
-		e.dailyUsageExporter.Collect(ch)
-		logrus.Debug("Completed daily usage collection")
-	}()
+		}
+	}
+
+	e.logger().WithField("collectors", len(snapshot)).Info("Loaded exporter state")
+	return nil
+}
 
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				logrus.WithField("panic", r).Error("Panic during spending collection")
-				e.scrapeErrors.Inc()
+// SaveState writes the current state to the state store. It is a no-op
+// without a state store.
+func (e *CursorExporter) SaveState() error {
+	if e.stateStore == nil {
+		return nil
+	}
+
+	snapshot := state.Snapshot{}
+	if start := e.cycle.Start(); !start.IsZero() {
+		if err := snapshot.Put("billing_cycle", billingCycleState{Start: start}); err != nil {
+			return err
+		}
+	}
+	if members := e.roster.Members(); len(members) > 0 {
+		if err := snapshot.Put("team_roster", members); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range e.currentCollectors() {
+		if p, ok := c.collector.(persistent); ok {
+			if err := p.saveState(snapshot); err != nil {
+				return err
 			}
-		}()
-		logrus.Debug("Starting spending collection")
-		e.spendingExporter.Collect(ch)
-		logrus.Debug("Completed spending collection")
-	}()
+		}
+	}
+
+	return e.stateStore.Save(snapshot)
+}
+
+func (e *CursorExporter) flushState(ctx context.Context) {
+	ticker := time.NewTicker(e.stateFlushInterval)
+	defer ticker.Stop()
 
-	func() {
-		defer func() {
-			if r := recover(); r != nil {
-				logrus.WithField("panic", r).Error("Panic during usage events collection")
-				e.scrapeErrors.Inc()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.SaveState(); err != nil {
+				e.logger().WithError(err).Error("Failed to save exporter state")
 			}
-		}()
-		logrus.Debug("Starting usage events collection")
-		e.usageEventsExporter.Collect(ch)
-		logrus.Debug("Completed usage events collection")
-	}()
+		}
+	}
 }
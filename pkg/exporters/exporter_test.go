@@ -1,14 +1,21 @@
 package exporters
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/budget"
 	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/state"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -73,6 +80,33 @@ func TestCursorExporter_Describe(t *testing.T) {
 	}
 }
 
+func TestCursorExporter_DisabledCollectors(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Spending.Enabled = false
+	opts.UsageEvents.Enabled = false
+	exporter := NewCursorExporterWithOptions("https://api.cursor.com", "test-token", opts)
+
+	if exporter.spendingExporter != nil || exporter.usageEventsExporter != nil {
+		t.Error("Expected disabled sub-exporters not to be built")
+	}
+	var names []string
+	for _, c := range exporter.collectors {
+		names = append(names, c.name)
+	}
+	if want := []string{TeamMembersCollector, DailyUsageCollector}; strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected collectors %v, got %v", want, names)
+	}
+
+	ch := make(chan *prometheus.Desc, 200)
+	exporter.Describe(ch)
+	close(ch)
+	for desc := range ch {
+		if strings.Contains(desc.String(), `"cursor_spending_total_cents"`) {
+			t.Error("Expected no spending metrics to be described")
+		}
+	}
+}
+
 func TestCursorExporter_Collect_WithMockServer(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
@@ -321,6 +355,7 @@ func TestCursorExporter_Collect_HandlesPanics(t *testing.T) {
 			},
 		),
 	}
+	exporter.schedule(DefaultOptions())
 
 	ch := make(chan prometheus.Metric, 100)
 	go func() {
@@ -409,3 +444,246 @@ func TestCursorExporter_ScrapeMetrics(t *testing.T) {
 		t.Error("Expected to find scrape duration metric")
 	}
 }
+
+func TestCursorExporter_StatePersistsAcrossRestarts(t *testing.T) {
+	api := &usageEventsServer{}
+	api.add(time.Now().Add(-time.Hour), "john@example.com", "gpt-4", 60, 40)
+
+	mux := http.NewServeMux()
+	mux.Handle("/teams/filtered-usage-events", api)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	opts := DefaultOptions()
+	opts.StateStore = state.NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+
+	first := NewCursorExporterWithOptions(server.URL, "test-token", opts)
+	first.cycle.set(time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local))
+	collectMetrics(first)
+	if err := first.SaveState(); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	// The restarted exporter must not count the same event again.
+	second := NewCursorExporterWithOptions(server.URL, "test-token", opts)
+	if err := second.LoadState(); err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	api.add(time.Now().Add(-time.Minute), "john@example.com", "gpt-4", 5, 5)
+
+	metrics := collectMetrics(second)
+	if got := dtoMetric(findMetric(metrics, "cursor_usage_events_total")).GetCounter().GetValue(); got != 2 {
+		t.Errorf("Expected counter to continue from 1 to 2 after restart, got %f", got)
+	}
+
+	if got := second.cycle.Start(); !got.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("Expected billing cycle start to be restored, got %v", got)
+	}
+
+	last := api.startDates[len(api.startDates)-1]
+	if want := time.Now().Add(-time.Hour).Add(-ingestOverlap).UnixMilli(); last < want-1000 {
+		t.Errorf("Expected restarted exporter to resume from the high-water mark, fetched from %d", last)
+	}
+}
+
+func TestCursorExporter_Collect_CountsRetries(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/teams/members" && attempts.Add(1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	opts := DefaultOptions()
+	opts.Retry = client.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	exporter := NewCursorExporterWithOptions(server.URL, "test-token", opts)
+
+	metrics := collectMetrics(exporter)
+
+	retries := findMetricWithLabels(metrics, "cursor_exporter_api_retries_total", map[string]string{
+		"endpoint": "/teams/members",
+		"reason":   client.RetryReasonServerError,
+	})
+	if retries == nil {
+		t.Fatal("Expected cursor_exporter_api_retries_total for /teams/members")
+	}
+	if got := dtoMetric(retries).GetCounter().GetValue(); got != 1 {
+		t.Errorf("Expected 1 retry, got %f", got)
+	}
+}
+
+func TestCursorExporter_Reload(t *testing.T) {
+	requests := make(map[string]*atomic.Int32)
+	for _, path := range []string{"/teams/members", "/teams/daily-usage-data", "/teams/spend", "/teams/filtered-usage-events"} {
+		requests[path] = &atomic.Int32{}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if counter, ok := requests[r.URL.Path]; ok {
+			counter.Add(1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write([]byte(`{}`)); err != nil {
+			t.Logf("Failed to write response: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	opts := DefaultOptions()
+	opts.UsageEvents.Enabled = false
+	exporter := NewCursorExporterWithOptions(server.URL, "test-token", opts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	exporter.Start(ctx)
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("the initial refresh", func() bool {
+		for _, c := range exporter.currentCollectors() {
+			if c.status().at.IsZero() {
+				return false
+			}
+		}
+		return true
+	})
+	teamMembers := exporter.currentCollectors()[0]
+	before := requests["/teams/members"].Load()
+
+	opts.Spending.Enabled = false
+	opts.UsageEvents.Enabled = true
+	opts.TeamMembers.Interval = time.Hour
+	exporter.Reload(opts)
+
+	if exporter.spendingExporter != nil {
+		t.Error("Expected the spending collector to be dropped")
+	}
+	var names []string
+	for _, c := range exporter.currentCollectors() {
+		names = append(names, c.name)
+	}
+	if want := []string{TeamMembersCollector, DailyUsageCollector, UsageEventsCollector}; strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected collectors %v, got %v", want, names)
+	}
+	if c := exporter.currentCollectors()[0]; c != teamMembers || c.interval != time.Hour {
+		t.Error("Expected the team members collector to be kept with its new interval")
+	}
+
+	waitFor("the newly enabled collector to refresh", func() bool {
+		return requests["/teams/filtered-usage-events"].Load() > 0
+	})
+	if after := requests["/teams/members"].Load(); after != before {
+		t.Errorf("Expected kept collectors not to refresh early after a reload, team members went from %d to %d requests", before, after)
+	}
+	if findMetric(collectMetrics(exporter), "cursor_team_members_total") == nil {
+		t.Error("Expected the team members snapshot to survive the reload")
+	}
+}
+
+func TestCursorExporter_ReloadDuringSlowRefresh(t *testing.T) {
+	var slow atomic.Bool
+	slow.Store(true)
+	started := make(chan struct{}, 1)
+	requests := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/teams/members" {
+			requests.Add(1)
+			if slow.Load() {
+				select {
+				case started <- struct{}{}:
+				default:
+				}
+				<-r.Context().Done()
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write([]byte(`{}`)); err != nil {
+			t.Logf("Failed to write response: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	opts := DefaultOptions()
+	opts.DailyUsage.Enabled = false
+	opts.Spending.Enabled = false
+	opts.UsageEvents.Enabled = false
+	opts.Retry.MaxAttempts = 1
+	exporter := NewCursorExporterWithOptions(server.URL, "test-token", opts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	exporter.Start(ctx)
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the first refresh to start")
+	}
+	slow.Store(false)
+	exporter.Reload(opts)
+
+	teamMembers := exporter.currentCollectors()[0]
+	deadline := time.Now().Add(5 * time.Second)
+	for !teamMembers.status().success {
+		if last := teamMembers.status(); !last.at.IsZero() && !last.success {
+			t.Fatal("Expected the refresh aborted by the reload not to be recorded as a failure")
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the collector to refresh right after the reload instead of waiting an interval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("Expected the aborted refresh to be retried once, got %d requests", got)
+	}
+}
+
+func TestCursorExporter_ReloadWhileSavingState(t *testing.T) {
+	budgets, err := budget.Parse([]byte("rules:\n  - {name: team, scope: team, limit_dollars: 1}\nwebhook: {url: http://127.0.0.1:1}\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse budgets: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.StateStore = state.NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+	opts.Budgets = budgets
+	exporter := NewCursorExporterWithOptions("http://127.0.0.1:1", "test-token", opts)
+
+	// Run with -race: a reload replaces the budget notifier that saving and
+	// loading state read.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer close(done)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				exporter.Reload(opts)
+			}
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if err := exporter.SaveState(); err != nil {
+			t.Fatalf("Failed to save state: %v", err)
+		}
+		if err := exporter.LoadState(); err != nil {
+			t.Fatalf("Failed to load state: %v", err)
+		}
+	}
+}
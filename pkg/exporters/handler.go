@@ -0,0 +1,105 @@
+package exporters
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+// scrapeTimeoutHeader is set by Prometheus to the scrape timeout in seconds.
+const scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+
+// scrapeTimeoutOffset is taken off the scrape timeout Prometheus advertises
+// so the exporter still answers before Prometheus gives up on the scrape.
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
+// collectParam selects collectors for a single scrape, as in
+// /metrics?collect[]=spending&collect[]=team_members.
+const collectParam = "collect[]"
+
+// Handler serves the exporter's metrics together with those of gatherer.
+// Scrapes may limit the collectors served with collect[] query parameters.
+//
+// A started exporter serves its snapshots without calling the API. One that
+// was not started refreshes on every scrape, with a context that is cancelled
+// when the client goes away or the scrape timeout advertised by Prometheus
+// is about to expire.
+func (e *CursorExporter) Handler(gatherer prometheus.Gatherer) http.Handler {
+	return Teams{{Exporter: e}}.Handler(gatherer)
+}
+
+// Handler serves the metrics of every team together with those of gatherer,
+// like CursorExporter.Handler.
+func (t Teams) Handler(gatherer prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		names := r.URL.Query()[collectParam]
+
+		ctx, cancel := scrapeContext(r)
+		defer cancel()
+
+		gatherers := prometheus.Gatherers{gatherer}
+		for _, team := range t {
+			collectors := team.Exporter.currentCollectors()
+			if len(names) > 0 {
+				var err error
+				if collectors, err = team.Exporter.selectCollectors(names); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			teamGatherer, err := team.gatherer(&scrapeCollector{exporter: team.Exporter, ctx: ctx, collectors: collectors}, team.labels())
+			if err != nil {
+				http.Error(w, team.wrap(err).Error(), http.StatusInternalServerError)
+				return
+			}
+			gatherers = append(gatherers, teamGatherer)
+		}
+
+		promhttp.HandlerFor(
+			gatherers,
+			promhttp.HandlerOpts{ErrorLog: logrus.StandardLogger()},
+		).ServeHTTP(w, r)
+	})
+}
+
+// scrapeContext derives the context of one scrape from the request. Requests
+// without a valid scrape timeout header only end with the request itself.
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	header := r.Header.Get(scrapeTimeoutHeader)
+	if header == "" {
+		return context.WithCancel(r.Context())
+	}
+
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil || seconds <= 0 {
+		logrus.WithField("value", header).Warn("Ignoring invalid scrape timeout header")
+		return context.WithCancel(r.Context())
+	}
+
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > 2*scrapeTimeoutOffset {
+		timeout -= scrapeTimeoutOffset
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// scrapeCollector collects the exporter with the context and collectors of
+// one scrape.
+type scrapeCollector struct {
+	exporter   *CursorExporter
+	ctx        context.Context
+	collectors []*scheduledCollector
+}
+
+func (c *scrapeCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.exporter.Describe(ch)
+}
+
+func (c *scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.exporter.collectOnly(c.ctx, ch, c.collectors)
+}
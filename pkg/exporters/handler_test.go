@@ -0,0 +1,149 @@
+package exporters
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestScrapeContext(t *testing.T) {
+	tests := []struct {
+		header      string
+		hasDeadline bool
+		timeout     time.Duration
+	}{
+		{header: "", hasDeadline: false},
+		{header: "invalid", hasDeadline: false},
+		{header: "-1", hasDeadline: false},
+		{header: "10", hasDeadline: true, timeout: 10*time.Second - scrapeTimeoutOffset},
+		{header: "0.5", hasDeadline: true, timeout: 500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			if tt.header != "" {
+				r.Header.Set(scrapeTimeoutHeader, tt.header)
+			}
+
+			ctx, cancel := scrapeContext(r)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if ok != tt.hasDeadline {
+				t.Fatalf("Expected deadline=%v, got %v", tt.hasDeadline, ok)
+			}
+			if !ok {
+				return
+			}
+			if remaining := time.Until(deadline); remaining > tt.timeout || remaining < tt.timeout-time.Second {
+				t.Errorf("Expected a deadline about %s away, got %s", tt.timeout, remaining)
+			}
+		})
+	}
+}
+
+func TestCursorExporter_Handler_HonoursScrapeTimeout(t *testing.T) {
+	fake := newFakeUpdater(1)
+	fake.block = true
+
+	exporter := NewCursorExporter("http://127.0.0.1:0", "test-token")
+	exporter.collectors = []*scheduledCollector{newScheduledCollector("fake", fake, time.Minute)}
+
+	server := httptest.NewServer(exporter.Handler(prometheus.NewRegistry()))
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set(scrapeTimeoutHeader, "0.2")
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the scrape to give up after the scrape timeout, took %s", elapsed)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "cursor_exporter_scrape_duration_seconds") {
+		t.Error("Expected exporter self-metrics in the response")
+	}
+}
+
+func TestCursorExporter_Handler_CollectParam(t *testing.T) {
+	opts := DefaultOptions()
+	opts.UsageEvents.Enabled = false
+	exporter := NewCursorExporterWithOptions("http://127.0.0.1:0", "test-token", opts)
+	exporter.started.Store(true)
+	for i, c := range exporter.collectors {
+		fake := newFakeUpdater(float64(i))
+		fake.desc = prometheus.NewDesc("cursor_test_"+c.name, "Test value", nil, nil)
+		c.collector = fake
+		if err := c.refresh(context.Background()); err != nil {
+			t.Fatalf("Failed to refresh %s: %v", c.name, err)
+		}
+	}
+
+	server := httptest.NewServer(exporter.Handler(prometheus.NewRegistry()))
+	defer server.Close()
+
+	tests := []struct {
+		query  string
+		status int
+		want   []string
+		absent []string
+	}{
+		{
+			query:  "",
+			status: http.StatusOK,
+			want:   []string{"cursor_test_team_members", "cursor_test_daily_usage", "cursor_test_spending"},
+		},
+		{
+			query:  "?collect[]=spending&collect[]=team_members",
+			status: http.StatusOK,
+			want:   []string{"cursor_test_team_members", "cursor_test_spending", "cursor_exporter_scrape_duration_seconds"},
+			absent: []string{"cursor_test_daily_usage", `collector="daily_usage"`},
+		},
+		{query: "?collect[]=usage_events", status: http.StatusBadRequest, want: []string{`collector "usage_events" is disabled`}},
+		{query: "?collect[]=billing", status: http.StatusBadRequest, want: []string{`unknown collector "billing"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			resp, err := http.Get(server.URL + tt.query)
+			if err != nil {
+				t.Fatalf("Scrape failed: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, resp.StatusCode, body)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(body), want) {
+					t.Errorf("Expected %q in the response", want)
+				}
+			}
+			for _, absent := range tt.absent {
+				if strings.Contains(string(body), absent) {
+					t.Errorf("Expected no %q in the response", absent)
+				}
+			}
+		})
+	}
+}
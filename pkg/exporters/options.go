@@ -0,0 +1,144 @@
+package exporters
+
+import (
+	"time"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/budget"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/cardinality"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/directory"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/pricing"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/privacy"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/state"
+)
+
+// Collector names used in logs and in the collector label of self-metrics.
+const (
+	TeamMembersCollector = "team_members"
+	DailyUsageCollector  = "daily_usage"
+	SpendingCollector    = "spending"
+	UsageEventsCollector = "usage_events"
+)
+
+// CollectorNames lists every collector in the order the exporter runs them.
+var CollectorNames = []string{TeamMembersCollector, DailyUsageCollector, SpendingCollector, UsageEventsCollector}
+
+func isCollectorName(name string) bool {
+	return (&Options{}).Collector(name) != nil
+}
+
+// Options configures how CursorExporter collects from the Cursor Admin API.
+type Options struct {
+	TeamMembers CollectorOptions
+	DailyUsage  CollectorOptions
+	Spending    CollectorOptions
+	UsageEvents CollectorOptions
+
+	// Concurrency is how many collectors may refresh from the Cursor API at
+	// the same time. Zero does not limit concurrency.
+	Concurrency int
+
+	// CollectorTimeout bounds a single refresh of any collector, including
+	// retries and pagination. Zero means no timeout.
+	CollectorTimeout time.Duration
+
+	// Pricing estimates the cost of usage events. Cost metrics are not
+	// exported if it is nil.
+	Pricing *pricing.Table
+
+	// Budgets are evaluated against the spend of the current billing cycle.
+	// Budget metrics are not exported if it is nil.
+	Budgets *budget.Config
+
+	// Directory adds the department, manager and cost centre of users to
+	// per-user metrics, and per-department aggregates. They are not
+	// exported if it is nil.
+	Directory *directory.Directory
+
+	// Privacy rewrites the user emails in metric labels before they are
+	// exported. Emails are exported unchanged if it is nil.
+	Privacy *privacy.Policy
+
+	// Limits caps the number of values of labels such as user_email, model
+	// or date. Labels are not limited if it is nil.
+	Limits *cardinality.Limiter
+
+	// Team names the team in log messages when the exporter is one of
+	// several Teams. It is only used when the exporter is created.
+	Team string
+
+	// TokenSource, if set, supplies the API token for every request instead
+	// of the token passed to NewCursorExporterWithOptions. It is only used
+	// when the exporter is created.
+	TokenSource client.TokenSource
+
+	// RequestTimeout bounds a single Cursor API request, excluding retries.
+	RequestTimeout time.Duration
+
+	// Retry controls how failed Cursor API requests are retried.
+	Retry client.RetryPolicy
+
+	// RateLimit is the request budget shared by every collector and
+	// endpoint. EndpointRateLimits adds tighter budgets for individual
+	// endpoints, keyed by path. Requests are not limited by default.
+	RateLimit          client.RateLimit
+	EndpointRateLimits map[string]client.RateLimit
+
+	// StateStore persists usage event counters, ingestion cursors and the
+	// billing cycle between restarts. State is not persisted if it is nil.
+	StateStore state.Store
+
+	// StateFlushInterval is how often state is saved while the exporter runs.
+	StateFlushInterval time.Duration
+}
+
+// Collector returns the options of the named collector, or nil if there is
+// no collector with that name.
+func (o *Options) Collector(name string) *CollectorOptions {
+	switch name {
+	case TeamMembersCollector:
+		return &o.TeamMembers
+	case DailyUsageCollector:
+		return &o.DailyUsage
+	case SpendingCollector:
+		return &o.Spending
+	case UsageEventsCollector:
+		return &o.UsageEvents
+	}
+	return nil
+}
+
+// CollectorOptions configures a single sub-exporter.
+type CollectorOptions struct {
+	// Enabled controls whether the collector is built at all. Disabled
+	// collectors never call the Cursor API and export no metrics.
+	Enabled bool
+
+	// Interval is how often the collector refreshes its snapshot once the
+	// exporter has been started.
+	Interval time.Duration
+
+	// LookbackDays is how many days of history the daily usage and usage
+	// events collectors fetch. Other collectors ignore it.
+	LookbackDays int
+
+	// PageSize is how many records the spending and usage events collectors
+	// request per page. Other collectors ignore it.
+	PageSize int
+}
+
+func DefaultOptions() Options {
+	return Options{
+		TeamMembers: CollectorOptions{Enabled: true, Interval: 5 * time.Minute},
+		DailyUsage:  CollectorOptions{Enabled: true, Interval: 15 * time.Minute, LookbackDays: defaultLookbackDays},
+		Spending:    CollectorOptions{Enabled: true, Interval: 5 * time.Minute, PageSize: defaultSpendingPageSize},
+		UsageEvents: CollectorOptions{Enabled: true, Interval: time.Minute, LookbackDays: defaultLookbackDays, PageSize: defaultUsageEventsPageSize},
+
+		Concurrency:      4,
+		CollectorTimeout: 5 * time.Minute,
+
+		RequestTimeout:     30 * time.Second,
+		Retry:              client.DefaultRetryPolicy(),
+		StateFlushInterval: time.Minute,
+	}
+}
@@ -0,0 +1,43 @@
+package exporters
+
+import (
+	"sync"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+)
+
+// teamRoster shares the team members reported by the team members endpoint
+// with the collectors that need to know each member's role. A nil
+// *teamRoster is valid and never knows any member.
+type teamRoster struct {
+	mu      sync.RWMutex
+	members []client.TeamMember
+}
+
+func (r *teamRoster) set(members []client.TeamMember) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.members = members
+	r.mu.Unlock()
+}
+
+func (r *teamRoster) Members() []client.TeamMember {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.members
+}
+
+// roles maps each member's email to their role.
+func (r *teamRoster) roles() map[string]string {
+	members := r.Members()
+	roles := make(map[string]string, len(members))
+	for _, member := range members {
+		roles[member.Email] = member.Role
+	}
+	return roles
+}
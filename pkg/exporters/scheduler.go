@@ -0,0 +1,241 @@
+package exporters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+)
+
+// updater is implemented by every sub-exporter. Update sends the metrics for
+// one refresh and reports whether the underlying API calls succeeded.
+type updater interface {
+	Describe(ch chan<- *prometheus.Desc)
+	Update(ctx context.Context, ch chan<- prometheus.Metric) error
+}
+
+// scheduledCollector keeps the last good snapshot of a sub-exporter so scrapes
+// can be served from memory instead of calling the Cursor API.
+type scheduledCollector struct {
+	name      string
+	collector updater
+	interval  time.Duration
+	// timeout bounds a single refresh. Zero means no limit beyond ctx.
+	timeout time.Duration
+	// slots is shared by every collector of an exporter and bounds how many
+	// refresh at once. A nil slots does not limit concurrency.
+	slots chan struct{}
+
+	refreshMu sync.Mutex
+
+	mu        sync.RWMutex
+	metrics   []prometheus.Metric
+	updatedAt time.Time
+	last      refreshStatus
+}
+
+// refreshStatus describes the most recent refresh attempt of a collector,
+// successful or not.
+type refreshStatus struct {
+	at       time.Time
+	duration time.Duration
+	success  bool
+}
+
+func newScheduledCollector(name string, collector updater, interval time.Duration) *scheduledCollector {
+	return &scheduledCollector{
+		name:      name,
+		collector: collector,
+		interval:  interval,
+	}
+}
+
+// refresh runs the sub-exporter once and replaces the snapshot if it
+// succeeded. A failed refresh, including one cut short by ctx or the
+// collector's timeout, keeps serving the previous snapshot. A refresh aborted
+// because ctx was cancelled is not recorded as an attempt at all. refresh
+// waits for a free slot before calling the sub-exporter.
+func (s *scheduledCollector) refresh(ctx context.Context) error {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	if s.slots != nil {
+		select {
+		case s.slots <- struct{}{}:
+			defer func() { <-s.slots }()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	parent := ctx
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	ch := make(chan prometheus.Metric)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(ch)
+		defer func() {
+			if r := recover(); r != nil {
+				errCh <- &panicError{value: r}
+			}
+		}()
+		errCh <- s.collector.Update(ctx, ch)
+	}()
+
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+
+	err := <-errCh
+	if err != nil && errors.Is(parent.Err(), context.Canceled) {
+		// The collector was stopped, on reload or shutdown, or the scrape
+		// went away. That is not a failed attempt: keep the previous status
+		// so the failure is not reported and the next refresh is not pushed
+		// back by an interval.
+		return err
+	}
+	if err != nil && parent.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("collector timed out after %s: %w", s.timeout, err)
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = refreshStatus{at: now, duration: now.Sub(start), success: err == nil}
+	if err != nil {
+		return err
+	}
+	s.metrics = metrics
+	s.updatedAt = now
+
+	return nil
+}
+
+// status reports the most recent refresh attempt. The returned status has a
+// zero time if the collector has not been refreshed yet.
+func (s *scheduledCollector) status() refreshStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.last
+}
+
+// collect sends the current snapshot and reports when it was taken. The
+// returned time is zero if no refresh has succeeded yet.
+func (s *scheduledCollector) collect(ch chan<- prometheus.Metric) time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, m := range s.metrics {
+		ch <- m
+	}
+	return s.updatedAt
+}
+
+// run refreshes the snapshot once it is due and then on every interval until
+// ctx is cancelled. Cancelling ctx also aborts a refresh in flight. After a
+// failure the next refresh may be delayed, see refreshDelay.
+func (s *scheduledCollector) run(ctx context.Context, onError func(*scheduledCollector, error)) {
+	timer := time.NewTimer(s.firstDelay())
+	defer timer.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		err := s.refresh(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			onError(s, err)
+		} else {
+			failures = 0
+			logrus.WithFields(logrus.Fields{
+				"collector": s.name,
+				"duration":  s.status().duration,
+			}).Debug("Refreshed collector snapshot")
+		}
+
+		delay := refreshDelay(s.interval, err, failures)
+		if delay > s.interval {
+			logrus.WithFields(logrus.Fields{
+				"collector": s.name,
+				"delay":     delay,
+			}).Warn("Delaying next collector refresh")
+		}
+		timer.Reset(delay)
+	}
+}
+
+// firstDelay returns how long run waits before its first refresh: not at all
+// for a collector that has never been refreshed, otherwise until an interval
+// has passed since the last attempt. Restarting a collector on reload thus
+// does not call the API early.
+func (s *scheduledCollector) firstDelay() time.Duration {
+	last := s.status()
+	if last.at.IsZero() {
+		return 0
+	}
+	if delay := s.interval - time.Since(last.at); delay > 0 {
+		return delay
+	}
+	return 0
+}
+
+// maxRefreshBackoff caps how long a collector waits between refreshes that
+// keep failing with auth errors.
+const maxRefreshBackoff = time.Hour
+
+// refreshDelay returns how long to wait before the next refresh. The API is
+// left alone for longer when hammering it cannot help: rejected tokens back
+// off exponentially with the number of consecutive failures, and rate
+// limits wait at least as long as the API asked.
+func refreshDelay(interval time.Duration, err error, failures int) time.Duration {
+	if err == nil {
+		return interval
+	}
+
+	if client.IsAuthError(err) {
+		delay := interval
+		for i := 1; i < failures && delay < maxRefreshBackoff; i++ {
+			delay *= 2
+		}
+		if delay > maxRefreshBackoff && interval < maxRefreshBackoff {
+			delay = maxRefreshBackoff
+		}
+		return delay
+	}
+
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > interval {
+		return apiErr.RetryAfter
+	}
+	return interval
+}
+
+type panicError struct {
+	value interface{}
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
@@ -0,0 +1,317 @@
+package exporters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type fakeUpdater struct {
+	desc  *prometheus.Desc
+	value float64
+	err   error
+	panic bool
+	block bool
+}
+
+func (f *fakeUpdater) Describe(ch chan<- *prometheus.Desc) {
+	ch <- f.desc
+}
+
+func (f *fakeUpdater) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	if f.panic {
+		panic("boom")
+	}
+	if f.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if f.err != nil {
+		return f.err
+	}
+	ch <- prometheus.MustNewConstMetric(f.desc, prometheus.GaugeValue, f.value)
+	return nil
+}
+
+func newFakeUpdater(value float64) *fakeUpdater {
+	return &fakeUpdater{
+		desc:  prometheus.NewDesc("cursor_test_value", "Test value", nil, nil),
+		value: value,
+	}
+}
+
+func snapshotValues(s *scheduledCollector) []float64 {
+	ch := make(chan prometheus.Metric, 10)
+	s.collect(ch)
+	close(ch)
+
+	var values []float64
+	for m := range ch {
+		values = append(values, dtoMetric(m).GetGauge().GetValue())
+	}
+	return values
+}
+
+func TestScheduledCollector_KeepsLastGoodSnapshot(t *testing.T) {
+	fake := newFakeUpdater(42)
+	s := newScheduledCollector("fake", fake, time.Minute)
+
+	if err := s.refresh(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	firstUpdate := s.updatedAt
+
+	fake.err = errors.New("api down")
+	fake.value = 7
+	if err := s.refresh(context.Background()); err == nil {
+		t.Fatal("Expected refresh error")
+	}
+
+	values := snapshotValues(s)
+	if len(values) != 1 || values[0] != 42 {
+		t.Errorf("Expected previous snapshot [42], got %v", values)
+	}
+	if !s.updatedAt.Equal(firstUpdate) {
+		t.Error("Expected failed refresh to leave the snapshot time unchanged")
+	}
+}
+
+func TestScheduledCollector_RecoversPanics(t *testing.T) {
+	fake := newFakeUpdater(1)
+	fake.panic = true
+	s := newScheduledCollector("fake", fake, time.Minute)
+
+	err := s.refresh(context.Background())
+	var perr *panicError
+	if !errors.As(err, &perr) {
+		t.Fatalf("Expected panicError, got %v", err)
+	}
+	if updatedAt := s.collect(make(chan prometheus.Metric, 1)); !updatedAt.IsZero() {
+		t.Error("Expected no snapshot after a panic")
+	}
+}
+
+func TestScheduledCollector_RefreshStopsWhenContextDone(t *testing.T) {
+	fake := newFakeUpdater(42)
+	s := newScheduledCollector("fake", fake, time.Minute)
+	if err := s.refresh(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	fake.block = true
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := s.refresh(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if values := snapshotValues(s); len(values) != 1 || values[0] != 42 {
+		t.Errorf("Expected previous snapshot [42], got %v", values)
+	}
+}
+
+func TestScheduledCollector_RefreshTimesOut(t *testing.T) {
+	fake := newFakeUpdater(42)
+	fake.block = true
+	s := newScheduledCollector("fake", fake, time.Minute)
+	s.timeout = 50 * time.Millisecond
+
+	err := s.refresh(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "timed out after 50ms") {
+		t.Errorf("Expected the error to mention the timeout, got %q", err)
+	}
+	if s.status().success {
+		t.Error("Expected the timed out refresh to be recorded as a failure")
+	}
+}
+
+// concurrencyUpdater records how many of its Update calls overlap.
+type concurrencyUpdater struct {
+	running *atomic.Int32
+	peak    *atomic.Int32
+}
+
+func (u concurrencyUpdater) Describe(ch chan<- *prometheus.Desc) {}
+
+func (u concurrencyUpdater) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	n := u.running.Add(1)
+	defer u.running.Add(-1)
+	for {
+		peak := u.peak.Load()
+		if n <= peak || u.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	return nil
+}
+
+func TestCursorExporter_RefreshAll_BoundsConcurrency(t *testing.T) {
+	for _, concurrency := range []int{1, 2, 4} {
+		t.Run(fmt.Sprintf("concurrency=%d", concurrency), func(t *testing.T) {
+			var running, peak atomic.Int32
+			opts := DefaultOptions()
+			opts.Concurrency = concurrency
+			exporter := NewCursorExporterWithOptions("http://invalid", "token", opts)
+			for _, c := range exporter.collectors {
+				c.collector = concurrencyUpdater{running: &running, peak: &peak}
+			}
+
+			exporter.refreshAll(context.Background(), exporter.collectors)
+
+			if got := peak.Load(); got != int32(concurrency) {
+				t.Errorf("Expected %d collectors to refresh at once, got %d", concurrency, got)
+			}
+		})
+	}
+}
+
+func TestCursorExporter_Collect_CollectorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/teams/members" {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(struct {
+			TeamMembers []client.TeamMember `json:"teamMembers"`
+		}{
+			TeamMembers: []client.TeamMember{{Name: "John Doe", Email: "john@example.com", Role: "admin"}},
+		}); err != nil {
+			t.Logf("Failed to encode response: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	exporter := NewCursorExporter(server.URL, "test-token")
+	before := time.Now()
+	metrics := collectMetrics(exporter)
+
+	expected := map[string]float64{
+		TeamMembersCollector: 1,
+		DailyUsageCollector:  0,
+		SpendingCollector:    0,
+		UsageEventsCollector: 0,
+	}
+	for collector, want := range expected {
+		success := findMetricWithLabel(metrics, "cursor_exporter_collector_success", "collector", collector)
+		if success == nil {
+			t.Fatalf("Expected cursor_exporter_collector_success for %s", collector)
+		}
+		if got := dtoMetric(success).GetGauge().GetValue(); got != want {
+			t.Errorf("Expected %s success %f, got %f", collector, want, got)
+		}
+		if findMetricWithLabel(metrics, "cursor_exporter_collector_duration_seconds", "collector", collector) == nil {
+			t.Errorf("Expected cursor_exporter_collector_duration_seconds for %s", collector)
+		}
+
+		lastSuccess := findMetricWithLabel(metrics, "cursor_exporter_last_success_timestamp_seconds", "collector", collector)
+		if want == 0 {
+			if lastSuccess != nil {
+				t.Errorf("Expected no last success timestamp for failing collector %s", collector)
+			}
+			continue
+		}
+		if lastSuccess == nil {
+			t.Fatalf("Expected cursor_exporter_last_success_timestamp_seconds for %s", collector)
+		}
+		if got := dtoMetric(lastSuccess).GetGauge().GetValue(); got < float64(before.Unix()) {
+			t.Errorf("Expected last success at or after %d, got %f", before.Unix(), got)
+		}
+	}
+
+	if got := dtoMetric(findMetric(metrics, "cursor_exporter_scrape_errors_total")).GetCounter().GetValue(); got != 3 {
+		t.Errorf("Expected 3 scrape errors for the failing collectors, got %f", got)
+	}
+}
+
+func TestCursorExporter_Start_ServesFromCache(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(struct {
+			TeamMembers []client.TeamMember `json:"teamMembers"`
+			TotalPages  int                 `json:"totalPages"`
+		}{
+			TeamMembers: []client.TeamMember{{Name: "John Doe", Email: "john@example.com", Role: "admin"}},
+			TotalPages:  1,
+		}); err != nil {
+			t.Logf("Failed to encode response: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	exporter := NewCursorExporter(server.URL, "test-token")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	exporter.Start(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for requests.Load() < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for initial refresh, saw %d requests", requests.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Wait for the snapshots to be stored after the responses were sent.
+	time.Sleep(50 * time.Millisecond)
+	before := requests.Load()
+
+	for i := 0; i < 3; i++ {
+		metrics := collectMetrics(exporter)
+		if findMetric(metrics, "cursor_team_members_total") == nil {
+			t.Fatal("Expected cached cursor_team_members_total metric")
+		}
+		if findMetricWithLabel(metrics, "cursor_exporter_snapshot_age_seconds", "collector", TeamMembersCollector) == nil {
+			t.Error("Expected snapshot age for team_members collector")
+		}
+	}
+
+	if after := requests.Load(); after != before {
+		t.Errorf("Expected scrapes to be served from cache, API requests went from %d to %d", before, after)
+	}
+}
+
+func TestRefreshDelay(t *testing.T) {
+	interval := 5 * time.Minute
+	unauthorized := fmt.Errorf("failed to get team members: %w", &client.APIError{StatusCode: http.StatusUnauthorized})
+	rateLimited := &client.APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: 20 * time.Minute}
+
+	tests := []struct {
+		name     string
+		err      error
+		failures int
+		expected time.Duration
+	}{
+		{name: "success", err: nil, expected: interval},
+		{name: "other error", err: errors.New("boom"), failures: 3, expected: interval},
+		{name: "first auth failure", err: unauthorized, failures: 1, expected: interval},
+		{name: "third auth failure", err: unauthorized, failures: 3, expected: 4 * interval},
+		{name: "auth failures capped", err: unauthorized, failures: 20, expected: maxRefreshBackoff},
+		{name: "retry after", err: rateLimited, failures: 1, expected: 20 * time.Minute},
+		{name: "short retry after", err: &client.APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Second}, failures: 1, expected: interval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := refreshDelay(interval, tt.err, tt.failures); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
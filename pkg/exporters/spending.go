@@ -1,24 +1,49 @@
 package exporters
 
 import (
+	"context"
+	"sync"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/budget"
 	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/state"
 )
 
+// budgetNotificationsKey is the state key of the thresholds already notified.
+const budgetNotificationsKey = "budget_notifications"
+
+// defaultSpendingPageSize is how many members are requested per page of
+// spending data.
+const defaultSpendingPageSize = 1000
+
 type SpendingExporter struct {
-	client *client.CursorClient
+	client   *client.CursorClient
+	pageSize int
+	cycle    *billingCycle
+	roster   *teamRoster
+
+	// budgetMu guards budgets and notifier, which a reload replaces while
+	// refreshes and state flushes use them.
+	budgetMu sync.Mutex
+	budgets  *budget.Config
+	notifier *budget.Notifier
 
 	totalSpending           *prometheus.Desc
 	spendingByMember        *prometheus.Desc
 	premiumRequestsByMember *prometheus.Desc
 	totalPremiumRequests    *prometheus.Desc
+	budgetUtilization       *prometheus.Desc
+	budgetExceeded          *prometheus.Desc
 }
 
 func NewSpendingExporter(client *client.CursorClient) *SpendingExporter {
 	return &SpendingExporter{
-		client: client,
+		client:   client,
+		pageSize: defaultSpendingPageSize,
 
 		totalSpending: prometheus.NewDesc(
 			"cursor_spending_total_cents",
@@ -47,21 +72,70 @@ func NewSpendingExporter(client *client.CursorClient) *SpendingExporter {
 			nil,
 			nil,
 		),
+
+		budgetUtilization: prometheus.NewDesc(
+			"cursor_budget_utilization_ratio",
+			"Spend in the current billing cycle divided by the budget limit",
+			[]string{"rule", "scope", "member_email"},
+			nil,
+		),
+
+		budgetExceeded: prometheus.NewDesc(
+			"cursor_budget_exceeded",
+			"Whether spend in the current billing cycle has reached the budget limit (1 if exceeded, 0 otherwise)",
+			[]string{"rule", "scope", "member_email"},
+			nil,
+		),
 	}
 }
 
+// setBudgets sets the budget rules evaluated on every refresh and the
+// notifier told when a rule crosses a threshold. Thresholds already notified
+// are carried over so replacing the rules does not resend them.
+func (e *SpendingExporter) setBudgets(budgets *budget.Config) {
+	e.budgetMu.Lock()
+	defer e.budgetMu.Unlock()
+	sent := e.notifier.State()
+	e.budgets = budgets
+	e.notifier = budget.NewNotifier(budgets)
+	e.notifier.Restore(sent)
+}
+
+// currentBudgets returns the budget rules and notifier as of the last
+// setBudgets.
+func (e *SpendingExporter) currentBudgets() (*budget.Config, *budget.Notifier) {
+	e.budgetMu.Lock()
+	defer e.budgetMu.Unlock()
+	return e.budgets, e.notifier
+}
+
 func (e *SpendingExporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.totalSpending
 	ch <- e.spendingByMember
 	ch <- e.premiumRequestsByMember
 	ch <- e.totalPremiumRequests
+	ch <- e.budgetUtilization
+	ch <- e.budgetExceeded
 }
 
 func (e *SpendingExporter) Collect(ch chan<- prometheus.Metric) {
-	spending, err := e.client.GetSpending(1000, 0)
-	if err != nil {
+	if err := e.Update(context.Background(), ch); err != nil {
 		logrus.WithError(err).Error("Failed to get spending data")
-		return
+	}
+}
+
+func (e *SpendingExporter) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	spending, err := e.client.GetSpendingContext(ctx, e.pageSize, 0)
+	if err != nil {
+		return err
+	}
+
+	var cycleStart time.Time
+	if len(spending) > 0 {
+		if start, err := time.ParseInLocation("2006-01-02", spending[0].Date, time.Local); err == nil {
+			cycleStart = start
+			e.cycle.set(cycleStart)
+		}
 	}
 
 	var totalSpend int
@@ -99,4 +173,71 @@ func (e *SpendingExporter) Collect(ch chan<- prometheus.Metric) {
 		prometheus.GaugeValue,
 		float64(totalPremiumRequests),
 	)
+
+	if budgets, notifier := e.currentBudgets(); budgets != nil {
+		e.collectBudgets(ctx, ch, budgets, notifier, spending, cycleStart)
+	}
+
+	return nil
+}
+
+func (e *SpendingExporter) collectBudgets(ctx context.Context, ch chan<- prometheus.Metric, budgets *budget.Config, notifier *budget.Notifier, spending []client.SpendingData, cycleStart time.Time) {
+	results := budgets.Evaluate(spending, e.roster.roles())
+
+	for _, result := range results {
+		exceeded := 0.0
+		if result.Exceeded() {
+			exceeded = 1
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			e.budgetUtilization,
+			prometheus.GaugeValue,
+			result.Utilization,
+			result.Rule.Name,
+			string(result.Rule.Scope),
+			result.MemberEmail,
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			e.budgetExceeded,
+			prometheus.GaugeValue,
+			exceeded,
+			result.Rule.Name,
+			string(result.Rule.Scope),
+			result.MemberEmail,
+		)
+	}
+
+	// Notifications are tracked per billing cycle, so none are sent until
+	// the cycle start is known.
+	if cycleStart.IsZero() {
+		return
+	}
+	if err := notifier.Notify(ctx, cycleStart, results); err != nil {
+		logrus.WithError(err).Warn("Failed to send budget notification")
+	}
+}
+
+func (e *SpendingExporter) saveState(snapshot state.Snapshot) error {
+	_, notifier := e.currentBudgets()
+	if notifier == nil {
+		return nil
+	}
+	return snapshot.Put(budgetNotificationsKey, notifier.State())
+}
+
+func (e *SpendingExporter) loadState(snapshot state.Snapshot) error {
+	_, notifier := e.currentBudgets()
+	if notifier == nil {
+		return nil
+	}
+
+	var sent []budget.SentState
+	ok, err := snapshot.Get(budgetNotificationsKey, &sent)
+	if err != nil || !ok {
+		return err
+	}
+	notifier.Restore(sent)
+	return nil
 }
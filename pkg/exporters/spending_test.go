@@ -0,0 +1,104 @@
+package exporters
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/budget"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+)
+
+func newSpendingServer(t *testing.T, cycleStart time.Time, spend map[string]int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/teams/spend" {
+			t.Errorf("Expected path /teams/spend, got %s", r.URL.Path)
+		}
+
+		type memberSpend struct {
+			SpendCents int    `json:"spendCents"`
+			Email      string `json:"email"`
+		}
+		response := struct {
+			TeamMemberSpend        []memberSpend `json:"teamMemberSpend"`
+			SubscriptionCycleStart int64         `json:"subscriptionCycleStart"`
+			TotalPages             int           `json:"totalPages"`
+		}{
+			SubscriptionCycleStart: cycleStart.UnixMilli(),
+			TotalPages:             1,
+		}
+		for email, cents := range spend {
+			response.TeamMemberSpend = append(response.TeamMemberSpend, memberSpend{SpendCents: cents, Email: email})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Logf("Failed to encode response: %v", err)
+		}
+	}))
+}
+
+func TestSpendingExporter_Collect_Budgets(t *testing.T) {
+	server := newSpendingServer(t, time.Now().AddDate(0, 0, -10), map[string]int{
+		"alice@example.com": 2500,
+		"bob@example.com":   500,
+	})
+	defer server.Close()
+
+	config, err := budget.Parse([]byte(`
+rules:
+  - {name: members, scope: role, role: member, limit_dollars: 20}
+  - {name: team, scope: team, limit_dollars: 60}
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse budgets: %v", err)
+	}
+
+	exporter := NewSpendingExporter(client.NewCursorClient(server.URL, "test-token"))
+	exporter.roster = &teamRoster{}
+	exporter.roster.set([]client.TeamMember{
+		{Email: "alice@example.com", Role: "member"},
+		{Email: "bob@example.com", Role: "member"},
+	})
+	exporter.setBudgets(config)
+
+	metrics := collectMetrics(exporter)
+
+	alice := findMetricWithLabels(metrics, "cursor_budget_utilization_ratio", map[string]string{"rule": "members", "member_email": "alice@example.com"})
+	if alice == nil {
+		t.Fatal("Expected cursor_budget_utilization_ratio for alice")
+	}
+	if got := dtoMetric(alice).GetGauge().GetValue(); got != 1.25 {
+		t.Errorf("Expected alice utilization 1.25, got %f", got)
+	}
+
+	aliceExceeded := findMetricWithLabels(metrics, "cursor_budget_exceeded", map[string]string{"rule": "members", "member_email": "alice@example.com"})
+	if got := dtoMetric(aliceExceeded).GetGauge().GetValue(); got != 1 {
+		t.Errorf("Expected alice to exceed her budget, got %f", got)
+	}
+
+	bobExceeded := findMetricWithLabels(metrics, "cursor_budget_exceeded", map[string]string{"rule": "members", "member_email": "bob@example.com"})
+	if got := dtoMetric(bobExceeded).GetGauge().GetValue(); got != 0 {
+		t.Errorf("Expected bob within his budget, got %f", got)
+	}
+
+	team := findMetricWithLabels(metrics, "cursor_budget_utilization_ratio", map[string]string{"rule": "team", "scope": "team", "member_email": ""})
+	if team == nil {
+		t.Fatal("Expected team cursor_budget_utilization_ratio")
+	}
+	if got := dtoMetric(team).GetGauge().GetValue(); got != 0.5 {
+		t.Errorf("Expected team utilization 0.5, got %f", got)
+	}
+}
+
+func TestSpendingExporter_Collect_NoBudgets(t *testing.T) {
+	server := newSpendingServer(t, time.Now(), map[string]int{"alice@example.com": 100})
+	defer server.Close()
+
+	exporter := NewSpendingExporter(client.NewCursorClient(server.URL, "test-token"))
+	if m := findMetric(collectMetrics(exporter), "cursor_budget_"); m != nil {
+		t.Error("Expected no budget metrics without budget rules")
+	}
+}
@@ -1,6 +1,8 @@
 package exporters
 
 import (
+	"context"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
@@ -9,6 +11,7 @@ import (
 
 type TeamMembersExporter struct {
 	client *client.CursorClient
+	roster *teamRoster
 
 	totalMembers  *prometheus.Desc
 	membersByRole *prometheus.Desc
@@ -40,11 +43,17 @@ func (e *TeamMembersExporter) Describe(ch chan<- *prometheus.Desc) {
 }
 
 func (e *TeamMembersExporter) Collect(ch chan<- prometheus.Metric) {
-	members, err := e.client.GetTeamMembers()
-	if err != nil {
+	if err := e.Update(context.Background(), ch); err != nil {
 		logrus.WithError(err).Error("Failed to get team members")
-		return
 	}
+}
+
+func (e *TeamMembersExporter) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	members, err := e.client.GetTeamMembersContext(ctx)
+	if err != nil {
+		return err
+	}
+	e.roster.set(members)
 
 	ch <- prometheus.MustNewConstMetric(
 		e.totalMembers,
@@ -65,4 +74,6 @@ func (e *TeamMembersExporter) Collect(ch chan<- prometheus.Metric) {
 			role,
 		)
 	}
+
+	return nil
 }
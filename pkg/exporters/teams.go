@@ -0,0 +1,130 @@
+package exporters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// teamLabel is added to every metric of a team when several teams are
+// exported.
+const teamLabel = "team"
+
+// Team is a Cursor team exported with its own exporter, and therefore its
+// own client, token, collectors and state.
+type Team struct {
+	// Name is the value of the team label on the team's metrics. A team
+	// without a name exports its metrics without the label, as a single
+	// team exporter does.
+	Name     string
+	Exporter *CursorExporter
+}
+
+// Teams exports several Cursor teams from one process. The teams share
+// nothing, so a team whose token is rejected or whose refreshes fail does
+// not affect the others.
+type Teams []Team
+
+// Start starts the background refreshes of every team.
+func (t Teams) Start(ctx context.Context) {
+	for _, team := range t {
+		team.Exporter.Start(ctx)
+	}
+}
+
+// Reload applies opts to every team. The options that are only used when an
+// exporter is created, such as the token source, are ignored.
+func (t Teams) Reload(opts Options) {
+	for _, team := range t {
+		team.Exporter.Reload(opts)
+	}
+}
+
+// LoadState restores the saved state of every team.
+func (t Teams) LoadState() error {
+	var errs []error
+	for _, team := range t {
+		if err := team.Exporter.LoadState(); err != nil {
+			errs = append(errs, team.wrap(err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SaveState saves the state of every team, even if saving one of them fails.
+func (t Teams) SaveState() error {
+	var errs []error
+	for _, team := range t {
+		if err := team.Exporter.SaveState(); err != nil {
+			errs = append(errs, team.wrap(err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Gatherer returns a gatherer of the metrics of every team, with the team
+// label added to named teams and the directory, privacy policy and
+// cardinality limits of each team applied.
+func (t Teams) Gatherer() (prometheus.Gatherer, error) {
+	gatherers := make(prometheus.Gatherers, 0, len(t))
+	for _, team := range t {
+		gatherer, err := team.gatherer(team.Exporter, team.labels())
+		if err != nil {
+			return nil, team.wrap(err)
+		}
+		gatherers = append(gatherers, gatherer)
+	}
+	return gatherers, nil
+}
+
+// Gatherer returns a gatherer of the team's metrics like Teams.Gatherer,
+// but without the team label, for exports that identify the team
+// otherwise, such as the resource of OTLP pushes.
+func (team Team) Gatherer() (prometheus.Gatherer, error) {
+	gatherer, err := team.gatherer(team.Exporter, nil)
+	if err != nil {
+		return nil, team.wrap(err)
+	}
+	return gatherer, nil
+}
+
+// gatherer registers collector, which collects the team's exporter, in a
+// registry of its own with labels added to every metric, and rewrites what
+// it gathers with the team's directory, privacy policy and cardinality
+// limits. Rewriting labels at this level rather than in Collect keeps the
+// registry's consistency checks working on the original metrics.
+func (team Team) gatherer(collector prometheus.Collector, labels prometheus.Labels) (prometheus.Gatherer, error) {
+	return team.rewritingGatherer(collector, labels, func(families []*dto.MetricFamily) []*dto.MetricFamily {
+		return team.Exporter.rewrite(families, labels)
+	})
+}
+
+// rewritingGatherer is like gatherer, but rewrites what it gathers with
+// rewrite.
+func (team Team) rewritingGatherer(collector prometheus.Collector, labels prometheus.Labels, rewrite func([]*dto.MetricFamily) []*dto.MetricFamily) (prometheus.Gatherer, error) {
+	registry := prometheus.NewRegistry()
+	if err := prometheus.WrapRegistererWith(labels, registry).Register(collector); err != nil {
+		return nil, err
+	}
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := registry.Gather()
+		return rewrite(families), err
+	}), nil
+}
+
+func (team Team) labels() prometheus.Labels {
+	if team.Name == "" {
+		return nil
+	}
+	return prometheus.Labels{teamLabel: team.Name}
+}
+
+func (team Team) wrap(err error) error {
+	if team.Name == "" {
+		return err
+	}
+	return fmt.Errorf("team %s: %w", team.Name, err)
+}
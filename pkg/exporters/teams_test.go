@@ -0,0 +1,182 @@
+package exporters
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/directory"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/privacy"
+)
+
+func TestTeams_Handler_IsolatesTeams(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"unauthorized"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"teamMembers":[{"name":"Ada","email":"ada@example.com","role":"owner"}]}`))
+	}))
+	defer server.Close()
+
+	opts := DefaultOptions()
+	opts.Retry.MaxAttempts = 1
+	teams := Teams{
+		{Name: "marketing", Exporter: NewCursorExporterWithOptions(server.URL, "good-token", opts)},
+		{Name: "sales", Exporter: NewCursorExporterWithOptions(server.URL, "revoked-token", opts)},
+	}
+
+	handler := httptest.NewServer(teams.Handler(prometheus.NewRegistry()))
+	defer handler.Close()
+
+	resp, err := http.Get(handler.URL + "?collect[]=team_members")
+	if err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	for _, want := range []string{
+		`cursor_team_members_total{team="marketing"} 1`,
+		`cursor_exporter_collector_success{collector="team_members",team="marketing"} 1`,
+		`cursor_exporter_collector_success{collector="team_members",team="sales"} 0`,
+		`cursor_exporter_scrape_errors_total{team="sales"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Expected %q in the response:\n%s", want, body)
+		}
+	}
+	if strings.Contains(string(body), `cursor_team_members_total{team="sales"}`) {
+		t.Error("Expected no team members for the team whose token was rejected")
+	}
+}
+
+func TestTeams_Handler_AppliesPrivacy(t *testing.T) {
+	server := newSpendingServer(t, time.Now().AddDate(0, 0, -10), map[string]int{
+		"alice@example.com": 2500,
+		"bob@example.com":   500,
+	})
+	defer server.Close()
+
+	policy, err := privacy.NewPolicy(privacy.Domain, "", nil)
+	if err != nil {
+		t.Fatalf("Failed to create the policy: %v", err)
+	}
+	opts := DefaultOptions()
+	opts.Privacy = policy
+	teams := Teams{{Exporter: NewCursorExporterWithOptions(server.URL, "test-token", opts)}}
+
+	handler := httptest.NewServer(teams.Handler(prometheus.NewRegistry()))
+	defer handler.Close()
+
+	resp, err := http.Get(handler.URL + "?collect[]=spending")
+	if err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	if strings.Contains(string(body), "@example.com") {
+		t.Errorf("Expected no email addresses in the response:\n%s", body)
+	}
+	if !strings.Contains(string(body), `cursor_spending_by_member_cents{date="`) ||
+		!strings.Contains(string(body), `member_email="example.com"} 3000`) {
+		t.Errorf("Expected the spending of both members merged under their domain:\n%s", body)
+	}
+}
+
+func TestTeams_Gatherer_DirectoryBeforePrivacy(t *testing.T) {
+	server := newSpendingServer(t, time.Now().AddDate(0, 0, -10), map[string]int{
+		"alice@example.com": 2500,
+		"bob@example.com":   500,
+	})
+	defer server.Close()
+
+	users, err := directory.New([]directory.Entry{
+		{Email: "alice@example.com", Department: "Platform", Manager: "carol@example.com", CostCentre: "CC-1001"},
+		{Email: "bob@example.com", Department: "Platform", Manager: "carol@example.com", CostCentre: "CC-1001"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the directory: %v", err)
+	}
+	policy, err := privacy.NewPolicy(privacy.Hash, "salt", nil)
+	if err != nil {
+		t.Fatalf("Failed to create the policy: %v", err)
+	}
+	opts := DefaultOptions()
+	opts.TeamMembers.Enabled = false
+	opts.DailyUsage.Enabled = false
+	opts.UsageEvents.Enabled = false
+	opts.Directory = users
+	opts.Privacy = policy
+	exporter := NewCursorExporterWithOptions(server.URL, "test-token", opts)
+
+	gatherer, err := (Teams{{Name: "sales", Exporter: exporter}}).Gatherer()
+	if err != nil {
+		t.Fatalf("Failed to create the gatherer: %v", err)
+	}
+	families, err := gatherer.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather: %v", err)
+	}
+
+	var department, member *dto.Metric
+	for _, family := range families {
+		switch family.GetName() {
+		case "cursor_department_spending_cents":
+			department = family.GetMetric()[0]
+		case "cursor_spending_by_member_cents":
+			member = family.GetMetric()[0]
+		}
+	}
+	if department == nil || member == nil {
+		t.Fatal("Expected per-member and per-department spending")
+	}
+	if got := department.GetGauge().GetValue(); got != 3000 {
+		t.Errorf("Expected the department total of both members, got %f", got)
+	}
+	for _, label := range member.GetLabel() {
+		if strings.Contains(label.GetValue(), "@") {
+			t.Errorf("Expected emails to be hashed, got %s=%q", label.GetName(), label.GetValue())
+		}
+		if label.GetName() == "manager" && label.GetValue() != policy.Identity("carol@example.com") {
+			t.Errorf("Expected the hashed manager, got %q", label.GetValue())
+		}
+	}
+}
+
+func TestTeams_Gatherer_UnnamedTeam(t *testing.T) {
+	exporter := NewCursorExporter("http://127.0.0.1:0", "test-token")
+	exporter.started.Store(true)
+
+	gatherer, err := (Teams{{Exporter: exporter}}).Gatherer()
+	if err != nil {
+		t.Fatalf("Failed to create the gatherer: %v", err)
+	}
+	families, err := gatherer.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather: %v", err)
+	}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == teamLabel {
+					t.Fatalf("Expected no team label for a single unnamed team, found one on %s", family.GetName())
+				}
+			}
+		}
+	}
+}
@@ -1,16 +1,29 @@
 package exporters
 
 import (
+	"context"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
 	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/pricing"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/state"
 )
 
+// defaultUsageEventsPageSize is how many events are requested per page.
+const defaultUsageEventsPageSize = 5000
+
 type UsageEventsExporter struct {
-	client *client.CursorClient
+	client       *client.CursorClient
+	lookbackDays int
+	pageSize     int
+	cycle        *billingCycle
+
+	mu     sync.Mutex
+	ledger *usageLedger
 
 	totalEvents           *prometheus.Desc
 	eventsByType          *prometheus.Desc
@@ -19,63 +32,107 @@ type UsageEventsExporter struct {
 	tokensConsumed        *prometheus.Desc
 	tokensConsumedByModel *prometheus.Desc
 	tokensConsumedByUser  *prometheus.Desc
+	estimatedCost         *prometheus.Desc
+
+	windowEvents         *prometheus.Desc
+	windowTokensConsumed *prometheus.Desc
+	windowEstimatedCost  *prometheus.Desc
 }
 
 func NewUsageEventsExporter(client *client.CursorClient) *UsageEventsExporter {
 	return &UsageEventsExporter{
-		client: client,
+		client:       client,
+		lookbackDays: defaultLookbackDays,
+		pageSize:     defaultUsageEventsPageSize,
+		ledger:       newUsageLedger(),
 
 		totalEvents: prometheus.NewDesc(
 			"cursor_usage_events_total",
-			"Total number of usage events",
+			"Total number of usage events ingested",
 			nil,
 			nil,
 		),
 
 		eventsByType: prometheus.NewDesc(
 			"cursor_usage_events_by_type_total",
-			"Number of usage events by type",
+			"Number of usage events ingested by type",
 			[]string{"event_type"},
 			nil,
 		),
 
 		eventsByUser: prometheus.NewDesc(
 			"cursor_usage_events_by_user_total",
-			"Number of usage events by user",
+			"Number of usage events ingested by user",
 			[]string{"user_email"},
 			nil,
 		),
 
 		eventsByModel: prometheus.NewDesc(
 			"cursor_usage_events_by_model_total",
-			"Number of usage events by model",
+			"Number of usage events ingested by model",
 			[]string{"model"},
 			nil,
 		),
 
 		tokensConsumed: prometheus.NewDesc(
 			"cursor_tokens_consumed_total",
-			"Total tokens consumed",
-			nil,
+			"Total tokens consumed by ingested usage events by token type",
+			[]string{"token_type"},
 			nil,
 		),
 
 		tokensConsumedByModel: prometheus.NewDesc(
 			"cursor_tokens_consumed_by_model_total",
-			"Tokens consumed by model",
-			[]string{"model"},
+			"Tokens consumed by ingested usage events by model and token type",
+			[]string{"model", "token_type"},
 			nil,
 		),
 
 		tokensConsumedByUser: prometheus.NewDesc(
 			"cursor_tokens_consumed_by_user_total",
-			"Tokens consumed by user",
-			[]string{"user_email"},
+			"Tokens consumed by ingested usage events by user and token type",
+			[]string{"user_email", "token_type"},
+			nil,
+		),
+
+		estimatedCost: prometheus.NewDesc(
+			"cursor_estimated_cost_dollars",
+			"Estimated cost in dollars of ingested usage events by user, model and event type, from the pricing table",
+			[]string{"user_email", "model", "event_type"},
+			nil,
+		),
+
+		windowEvents: prometheus.NewDesc(
+			"cursor_window_usage_events",
+			"Number of usage events within the window",
+			[]string{"window"},
+			nil,
+		),
+
+		windowTokensConsumed: prometheus.NewDesc(
+			"cursor_window_tokens_consumed",
+			"Tokens consumed within the window",
+			[]string{"window"},
+			nil,
+		),
+
+		windowEstimatedCost: prometheus.NewDesc(
+			"cursor_window_estimated_cost_dollars",
+			"Estimated cost in dollars of usage events within the window, from the pricing table",
+			[]string{"window"},
 			nil,
 		),
 	}
 }
 
+// setPricing sets the price table used to estimate the cost of usage events
+// ingested from now on. Cost metrics are only exported once a table is set.
+func (e *UsageEventsExporter) setPricing(table *pricing.Table) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ledger.pricing = table
+}
+
 func (e *UsageEventsExporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.totalEvents
 	ch <- e.eventsByType
@@ -84,88 +141,172 @@ func (e *UsageEventsExporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.tokensConsumed
 	ch <- e.tokensConsumedByModel
 	ch <- e.tokensConsumedByUser
+	ch <- e.estimatedCost
+	ch <- e.windowEvents
+	ch <- e.windowTokensConsumed
+	ch <- e.windowEstimatedCost
 }
 
 func (e *UsageEventsExporter) Collect(ch chan<- prometheus.Metric) {
-	endDate := time.Now().Format("2006-01-02")
-	startDate := time.Now().AddDate(0, 0, -30).Format("2006-01-02")
+	if err := e.Update(context.Background(), ch); err != nil {
+		logrus.WithError(err).Error("Failed to get usage events")
+	}
+}
+
+func (e *UsageEventsExporter) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	lookbackStart := startOfDay(now.AddDate(0, 0, -e.lookbackDays))
+	windows := rollingWindows(now, e.cycle.Start())
+	start := fetchStart(lookbackStart, windows)
 
-	events, err := e.client.GetUsageEvents("", 5000, 0, startDate, endDate)
+	since := e.ledger.since(start)
+	events, err := e.client.GetUsageEventsSinceContext(ctx, since, e.pageSize)
 	if err != nil {
-		logrus.WithError(err).Error("Failed to get usage events")
-		return
+		return err
 	}
 
-	eventTypeCount := make(map[string]int)
-	userEventCount := make(map[string]int)
-	modelEventCount := make(map[string]int)
-	modelTokenCount := make(map[string]int)
-	userTokenCount := make(map[string]int)
-	totalTokens := 0
-
-	for _, event := range events {
-		eventTypeCount[event.EventType]++
-		userEventCount[event.UserEmail]++
-		modelEventCount[event.Model]++
-		modelTokenCount[event.Model] += event.TokensConsumed
-		userTokenCount[event.UserEmail] += event.TokensConsumed
-		totalTokens += event.TokensConsumed
+	added := e.ledger.ingest(since, events)
+	e.ledger.pruneDaily(start)
+
+	logrus.WithFields(logrus.Fields{
+		"since":           since,
+		"fetched":         len(events),
+		"ingested":        added,
+		"high_water_mark": e.ledger.highWaterMark,
+	}).Debug("Ingested usage events")
+
+	e.collectCounters(ch)
+
+	for _, w := range windows {
+		total := e.ledger.window(w.start)
+		ch <- prometheus.MustNewConstMetric(e.windowEvents, prometheus.GaugeValue, float64(total.Events), w.name)
+		ch <- prometheus.MustNewConstMetric(e.windowTokensConsumed, prometheus.GaugeValue, float64(total.Tokens), w.name)
+		if e.ledger.pricing != nil {
+			ch <- prometheus.MustNewConstMetric(e.windowEstimatedCost, prometheus.GaugeValue, total.CostDollars, w.name)
+		}
+	}
+
+	return nil
+}
+
+func (e *UsageEventsExporter) collectCounters(ch chan<- prometheus.Metric) {
+	eventTypeCount := make(map[string]int64)
+	byUser := make(map[string]*usageTotals)
+	byModel := make(map[string]*usageTotals)
+	var total usageTotals
+
+	for key, totals := range e.ledger.totals {
+		eventTypeCount[key.Kind] += totals.Events
+		if _, ok := byUser[key.UserEmail]; !ok {
+			byUser[key.UserEmail] = &usageTotals{}
+		}
+		byUser[key.UserEmail].merge(*totals)
+		if _, ok := byModel[key.Model]; !ok {
+			byModel[key.Model] = &usageTotals{}
+		}
+		byModel[key.Model].merge(*totals)
+		total.merge(*totals)
 	}
 
 	ch <- prometheus.MustNewConstMetric(
 		e.totalEvents,
-		prometheus.GaugeValue,
-		float64(len(events)),
+		prometheus.CounterValue,
+		float64(total.Events),
 	)
 
 	for eventType, count := range eventTypeCount {
 		ch <- prometheus.MustNewConstMetric(
 			e.eventsByType,
-			prometheus.GaugeValue,
+			prometheus.CounterValue,
 			float64(count),
 			eventType,
 		)
 	}
 
-	for userEmail, count := range userEventCount {
+	for userEmail, totals := range byUser {
 		ch <- prometheus.MustNewConstMetric(
 			e.eventsByUser,
-			prometheus.GaugeValue,
-			float64(count),
+			prometheus.CounterValue,
+			float64(totals.Events),
 			userEmail,
 		)
 	}
 
-	for model, count := range modelEventCount {
+	for model, totals := range byModel {
 		ch <- prometheus.MustNewConstMetric(
 			e.eventsByModel,
-			prometheus.GaugeValue,
-			float64(count),
+			prometheus.CounterValue,
+			float64(totals.Events),
 			model,
 		)
 	}
 
-	ch <- prometheus.MustNewConstMetric(
-		e.tokensConsumed,
-		prometheus.GaugeValue,
-		float64(totalTokens),
-	)
-
-	for model, tokens := range modelTokenCount {
+	for tokenType, tokens := range total.byTokenType() {
 		ch <- prometheus.MustNewConstMetric(
-			e.tokensConsumedByModel,
-			prometheus.GaugeValue,
+			e.tokensConsumed,
+			prometheus.CounterValue,
 			float64(tokens),
-			model,
+			tokenType,
 		)
 	}
 
-	for userEmail, tokens := range userTokenCount {
+	for model, totals := range byModel {
+		for tokenType, tokens := range totals.byTokenType() {
+			ch <- prometheus.MustNewConstMetric(
+				e.tokensConsumedByModel,
+				prometheus.CounterValue,
+				float64(tokens),
+				model,
+				tokenType,
+			)
+		}
+	}
+
+	for userEmail, totals := range byUser {
+		for tokenType, tokens := range totals.byTokenType() {
+			ch <- prometheus.MustNewConstMetric(
+				e.tokensConsumedByUser,
+				prometheus.CounterValue,
+				float64(tokens),
+				userEmail,
+				tokenType,
+			)
+		}
+	}
+
+	if e.ledger.pricing == nil {
+		return
+	}
+	for key, totals := range e.ledger.totals {
 		ch <- prometheus.MustNewConstMetric(
-			e.tokensConsumedByUser,
-			prometheus.GaugeValue,
-			float64(tokens),
-			userEmail,
+			e.estimatedCost,
+			prometheus.CounterValue,
+			totals.CostDollars,
+			key.UserEmail,
+			key.Model,
+			key.Kind,
 		)
 	}
 }
+
+func (e *UsageEventsExporter) saveState(snapshot state.Snapshot) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return snapshot.Put(UsageEventsCollector, e.ledger.state())
+}
+
+func (e *UsageEventsExporter) loadState(snapshot state.Snapshot) error {
+	var s usageLedgerState
+	ok, err := snapshot.Get(UsageEventsCollector, &s)
+	if err != nil || !ok {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ledger.restore(s)
+	return nil
+}
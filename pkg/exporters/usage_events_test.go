@@ -0,0 +1,316 @@
+package exporters
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/pricing"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type usageEventRow struct {
+	Timestamp  string `json:"timestamp"`
+	Model      string `json:"model"`
+	KindLabel  string `json:"kindLabel"`
+	TokenUsage *struct {
+		InputTokens      int `json:"inputTokens"`
+		OutputTokens     int `json:"outputTokens"`
+		CacheWriteTokens int `json:"cacheWriteTokens"`
+		CacheReadTokens  int `json:"cacheReadTokens"`
+	} `json:"tokenUsage"`
+	UserEmail string `json:"userEmail"`
+}
+
+// usageEventsServer serves the events it holds that are at or after the
+// requested startDate, like the real API, and records each startDate.
+// Events are served in the order they were added, or newest first, one page
+// of the requested size at a time.
+type usageEventsServer struct {
+	mu          sync.Mutex
+	events      []usageEventRow
+	newestFirst bool
+	startDates  []int64
+}
+
+func (s *usageEventsServer) add(ts time.Time, user, model string, input, output int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row := usageEventRow{
+		Timestamp: strconv.FormatInt(ts.UnixMilli(), 10),
+		Model:     model,
+		KindLabel: "Included in Business",
+		UserEmail: user,
+	}
+	row.TokenUsage = &struct {
+		InputTokens      int `json:"inputTokens"`
+		OutputTokens     int `json:"outputTokens"`
+		CacheWriteTokens int `json:"cacheWriteTokens"`
+		CacheReadTokens  int `json:"cacheReadTokens"`
+	}{InputTokens: input, OutputTokens: output}
+	s.events = append(s.events, row)
+}
+
+func (s *usageEventsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var reqBody struct {
+		StartDate int64 `json:"startDate"`
+		Page      int   `json:"page"`
+		PageSize  int   `json:"pageSize"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.startDates = append(s.startDates, reqBody.StartDate)
+
+	var matching []usageEventRow
+	for _, e := range s.events {
+		ts, _ := strconv.ParseInt(e.Timestamp, 10, 64)
+		if ts >= reqBody.StartDate {
+			matching = append(matching, e)
+		}
+	}
+	if s.newestFirst {
+		slices.Reverse(matching)
+	}
+
+	response := struct {
+		UsageEvents []usageEventRow `json:"usageEvents"`
+		Pagination  struct {
+			HasNextPage bool `json:"hasNextPage"`
+		} `json:"pagination"`
+	}{UsageEvents: matching}
+	if reqBody.PageSize > 0 && reqBody.Page > 0 {
+		start := min((reqBody.Page-1)*reqBody.PageSize, len(matching))
+		end := min(start+reqBody.PageSize, len(matching))
+		response.UsageEvents = matching[start:end]
+		response.Pagination.HasNextPage = end < len(matching)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func TestUsageEventsExporter_Collect_IncrementalCounters(t *testing.T) {
+	now := time.Now()
+	api := &usageEventsServer{}
+	api.add(now.Add(-2*time.Hour), "john@example.com", "gpt-4", 60, 40)
+	api.add(now.Add(-time.Hour), "jane@example.com", "claude-3", 10, 10)
+
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	exporter := NewUsageEventsExporter(client.NewCursorClient(server.URL, "test-token"))
+
+	metrics := collectMetrics(exporter)
+	total := findMetric(metrics, "cursor_usage_events_total")
+	if total == nil {
+		t.Fatal("Expected cursor_usage_events_total metric")
+	}
+	if dtoMetric(total).GetCounter() == nil {
+		t.Fatal("Expected cursor_usage_events_total to be a counter")
+	}
+	if got := dtoMetric(total).GetCounter().GetValue(); got != 2 {
+		t.Errorf("Expected 2 events after first collection, got %f", got)
+	}
+
+	api.add(now.Add(-time.Minute), "john@example.com", "gpt-4", 5, 5)
+
+	metrics = collectMetrics(exporter)
+	if got := dtoMetric(findMetric(metrics, "cursor_usage_events_total")).GetCounter().GetValue(); got != 3 {
+		t.Errorf("Expected 3 events after second collection, got %f", got)
+	}
+
+	johnInput := findMetricWithLabels(metrics, "cursor_tokens_consumed_by_user_total", map[string]string{"user_email": "john@example.com", "token_type": tokenTypeInput})
+	if got := dtoMetric(johnInput).GetCounter().GetValue(); got != 65 {
+		t.Errorf("Expected john input tokens 65, got %f", got)
+	}
+	johnOutput := findMetricWithLabels(metrics, "cursor_tokens_consumed_by_user_total", map[string]string{"user_email": "john@example.com", "token_type": tokenTypeOutput})
+	if got := dtoMetric(johnOutput).GetCounter().GetValue(); got != 45 {
+		t.Errorf("Expected john output tokens 45, got %f", got)
+	}
+
+	if len(api.startDates) != 2 {
+		t.Fatalf("Expected 2 API requests, got %d", len(api.startDates))
+	}
+	wantSecond := now.Add(-time.Hour).Add(-ingestOverlap).UnixMilli()
+	if api.startDates[1] != wantSecond {
+		t.Errorf("Expected second fetch to start at the high-water mark minus overlap (%d), got %d", wantSecond, api.startDates[1])
+	}
+}
+
+func TestUsageEventsExporter_Collect_NewestFirstAcrossPages(t *testing.T) {
+	now := time.Now()
+	api := &usageEventsServer{newestFirst: true}
+	api.add(now.Add(-3*time.Hour), "john@example.com", "gpt-4", 1, 0)
+	api.add(now.Add(-2*time.Hour), "jane@example.com", "claude-3", 2, 0)
+	api.add(now.Add(-time.Hour), "john@example.com", "gpt-4", 4, 0)
+
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	exporter := NewUsageEventsExporter(client.NewCursorClient(server.URL, "test-token"))
+	exporter.pageSize = 2
+
+	metrics := collectMetrics(exporter)
+	if got := dtoMetric(findMetric(metrics, "cursor_usage_events_total")).GetCounter().GetValue(); got != 3 {
+		t.Errorf("Expected all 3 events of the first fetch, got %f", got)
+	}
+
+	api.add(now.Add(-20*time.Minute), "jane@example.com", "claude-3", 8, 0)
+	api.add(now.Add(-10*time.Minute), "john@example.com", "gpt-4", 16, 0)
+	api.add(now.Add(-time.Minute), "jane@example.com", "claude-3", 32, 0)
+
+	metrics = collectMetrics(exporter)
+	if got := dtoMetric(findMetric(metrics, "cursor_usage_events_total")).GetCounter().GetValue(); got != 6 {
+		t.Errorf("Expected all 3 new events of the incremental fetch, got %f", got)
+	}
+	input := findMetricWithLabels(metrics, "cursor_tokens_consumed_total", map[string]string{"token_type": tokenTypeInput})
+	if got := dtoMetric(input).GetCounter().GetValue(); got != 63 {
+		t.Errorf("Expected every event counted once for 63 input tokens, got %f", got)
+	}
+}
+
+func TestUsageEventsExporter_Collect_CountersNeverDecrease(t *testing.T) {
+	api := &usageEventsServer{}
+	api.add(time.Now().Add(-time.Hour), "john@example.com", "gpt-4", 1, 1)
+
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	exporter := NewUsageEventsExporter(client.NewCursorClient(server.URL, "test-token"))
+	collectMetrics(exporter)
+
+	// The API no longer returns the event, e.g. because it aged out.
+	api.mu.Lock()
+	api.events = nil
+	api.mu.Unlock()
+
+	metrics := collectMetrics(exporter)
+	if got := dtoMetric(findMetric(metrics, "cursor_usage_events_total")).GetCounter().GetValue(); got != 1 {
+		t.Errorf("Expected counter to stay at 1, got %f", got)
+	}
+}
+
+// findMetricWithLabels returns the first metric named name that carries every
+// label in labels.
+func findMetricWithLabels(metrics []prometheus.Metric, name string, labels map[string]string) prometheus.Metric {
+	for _, m := range metrics {
+		if !strings.Contains(m.Desc().String(), `"`+name+`"`) {
+			continue
+		}
+		matched := 0
+		for _, lp := range dtoMetric(m).GetLabel() {
+			if want, ok := labels[lp.GetName()]; ok && want == lp.GetValue() {
+				matched++
+			}
+		}
+		if matched == len(labels) {
+			return m
+		}
+	}
+	return nil
+}
+
+func TestUsageEventsExporter_Collect_TokenBreakdown(t *testing.T) {
+	api := &usageEventsServer{}
+	api.add(time.Now().Add(-time.Hour), "john@example.com", "gpt-4", 60, 40)
+	api.mu.Lock()
+	api.events[0].TokenUsage.CacheReadTokens = 300
+	api.events[0].TokenUsage.CacheWriteTokens = 20
+	api.mu.Unlock()
+
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	exporter := NewUsageEventsExporter(client.NewCursorClient(server.URL, "test-token"))
+	metrics := collectMetrics(exporter)
+
+	expected := map[string]float64{
+		tokenTypeInput:      60,
+		tokenTypeOutput:     40,
+		tokenTypeCacheRead:  300,
+		tokenTypeCacheWrite: 20,
+	}
+	for tokenType, want := range expected {
+		total := findMetricWithLabels(metrics, "cursor_tokens_consumed_total", map[string]string{"token_type": tokenType})
+		if total == nil {
+			t.Fatalf("Expected cursor_tokens_consumed_total{token_type=%q}", tokenType)
+		}
+		if got := dtoMetric(total).GetCounter().GetValue(); got != want {
+			t.Errorf("Expected %s tokens %f, got %f", tokenType, want, got)
+		}
+
+		byModel := findMetricWithLabels(metrics, "cursor_tokens_consumed_by_model_total", map[string]string{"model": "gpt-4", "token_type": tokenType})
+		if got := dtoMetric(byModel).GetCounter().GetValue(); got != want {
+			t.Errorf("Expected gpt-4 %s tokens %f, got %f", tokenType, want, got)
+		}
+	}
+
+	window := findMetricWithLabel(metrics, "cursor_window_tokens_consumed", "window", windowLast7Days)
+	if got := dtoMetric(window).GetGauge().GetValue(); got != 420 {
+		t.Errorf("Expected the 7d window to sum every token type, got %f", got)
+	}
+}
+
+func TestUsageEventsExporter_Collect_EstimatedCost(t *testing.T) {
+	api := &usageEventsServer{}
+	api.add(time.Now().Add(-2*time.Hour), "john@example.com", "gpt-4", 1_000_000, 500_000)
+	api.add(time.Now().Add(-time.Hour), "john@example.com", "gpt-4", 1_000_000, 0)
+	api.add(time.Now().Add(-time.Hour), "jane@example.com", "mystery-model", 1_000_000, 0)
+
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	exporter := NewUsageEventsExporter(client.NewCursorClient(server.URL, "test-token"))
+
+	if m := findMetric(collectMetrics(exporter), `"cursor_estimated_cost_dollars"`); m != nil {
+		t.Fatal("Expected no cost metrics without a pricing table")
+	}
+
+	table, err := pricing.Parse([]byte("models:\n  gpt-*:\n    input: 2\n    output: 10\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse pricing table: %v", err)
+	}
+	exporter = NewUsageEventsExporter(client.NewCursorClient(server.URL, "test-token"))
+	exporter.setPricing(table)
+	metrics := collectMetrics(exporter)
+
+	john := findMetricWithLabels(metrics, "cursor_estimated_cost_dollars", map[string]string{
+		"user_email": "john@example.com",
+		"model":      "gpt-4",
+		"event_type": "Included in Business",
+	})
+	if john == nil {
+		t.Fatal("Expected cursor_estimated_cost_dollars for john")
+	}
+	if got := dtoMetric(john).GetCounter().GetValue(); got != 9 {
+		t.Errorf("Expected john's estimated cost 9, got %f", got)
+	}
+
+	jane := findMetricWithLabels(metrics, "cursor_estimated_cost_dollars", map[string]string{"user_email": "jane@example.com"})
+	if got := dtoMetric(jane).GetCounter().GetValue(); got != 0 {
+		t.Errorf("Expected unpriced models to cost 0, got %f", got)
+	}
+
+	window := findMetricWithLabel(metrics, "cursor_window_estimated_cost_dollars", "window", windowLast7Days)
+	if window == nil {
+		t.Fatal("Expected cursor_window_estimated_cost_dollars")
+	}
+	if got := dtoMetric(window).GetGauge().GetValue(); got != 9 {
+		t.Errorf("Expected 7d estimated cost 9, got %f", got)
+	}
+}
@@ -0,0 +1,294 @@
+package exporters
+
+import (
+	"time"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/pricing"
+)
+
+// ingestOverlap is how far before the high-water mark each incremental fetch
+// starts, so events the API reports slightly late are still picked up.
+// Events in the overlap that were already ingested are skipped.
+const ingestOverlap = 10 * time.Minute
+
+type usageKey struct {
+	UserEmail string `json:"user_email"`
+	Model     string `json:"model"`
+	Kind      string `json:"kind"`
+}
+
+type usageTotals struct {
+	Events           int64 `json:"events"`
+	Tokens           int64 `json:"tokens"`
+	InputTokens      int64 `json:"input_tokens"`
+	OutputTokens     int64 `json:"output_tokens"`
+	CacheWriteTokens int64 `json:"cache_write_tokens"`
+	CacheReadTokens  int64 `json:"cache_read_tokens"`
+
+	// CostDollars is the estimated cost of the events, priced when they
+	// were ingested.
+	CostDollars float64 `json:"cost_dollars,omitempty"`
+}
+
+func (t *usageTotals) addEvent(event client.UsageEvent, cost float64) {
+	t.Events++
+	t.Tokens += int64(event.TokensConsumed)
+	t.InputTokens += int64(event.InputTokens)
+	t.OutputTokens += int64(event.OutputTokens)
+	t.CacheWriteTokens += int64(event.CacheWriteTokens)
+	t.CacheReadTokens += int64(event.CacheReadTokens)
+	t.CostDollars += cost
+}
+
+// Token type label values for the token breakdown.
+const (
+	tokenTypeInput      = "input"
+	tokenTypeOutput     = "output"
+	tokenTypeCacheWrite = "cache_write"
+	tokenTypeCacheRead  = "cache_read"
+)
+
+// byTokenType returns the token breakdown keyed by token_type label value.
+func (t usageTotals) byTokenType() map[string]int64 {
+	return map[string]int64{
+		tokenTypeInput:      t.InputTokens,
+		tokenTypeOutput:     t.OutputTokens,
+		tokenTypeCacheWrite: t.CacheWriteTokens,
+		tokenTypeCacheRead:  t.CacheReadTokens,
+	}
+}
+
+func (t *usageTotals) merge(other usageTotals) {
+	t.Events += other.Events
+	t.Tokens += other.Tokens
+	t.InputTokens += other.InputTokens
+	t.OutputTokens += other.OutputTokens
+	t.CacheWriteTokens += other.CacheWriteTokens
+	t.CacheReadTokens += other.CacheReadTokens
+	t.CostDollars += other.CostDollars
+}
+
+type eventKey struct {
+	timestamp int64
+	userEmail string
+	model     string
+	kind      string
+	tokens    int
+}
+
+func eventKeyOf(event client.UsageEvent) eventKey {
+	return eventKey{
+		timestamp: event.Timestamp.UnixMilli(),
+		userEmail: event.UserEmail,
+		model:     event.Model,
+		kind:      event.EventType,
+		tokens:    event.TokensConsumed,
+	}
+}
+
+// usageLedger accumulates usage events incrementally. It remembers the newest
+// event it has ingested so each refresh only asks the API for newer events,
+// and keeps running totals that only ever grow.
+//
+// Events are priced with pricing as they are ingested, so a changed price
+// table only affects events ingested afterwards.
+type usageLedger struct {
+	pricing *pricing.Table
+
+	highWaterMark time.Time
+	// coveredFrom is where the earliest fetch started. Every event from
+	// then up to the high-water mark has been ingested.
+	coveredFrom time.Time
+	seen        map[eventKey]int
+	totals      map[usageKey]*usageTotals
+	daily       map[string]*usageTotals
+}
+
+func newUsageLedger() *usageLedger {
+	return &usageLedger{
+		seen:   make(map[eventKey]int),
+		totals: make(map[usageKey]*usageTotals),
+		daily:  make(map[string]*usageTotals),
+	}
+}
+
+// since returns where the next fetch should start. Before anything has been
+// ingested, or when initial is earlier than the ledger covers, such as a
+// billing cycle start learned after the first fetch, that is initial, so the
+// missing history is backfilled.
+func (l *usageLedger) since(initial time.Time) time.Time {
+	if l.highWaterMark.IsZero() || !l.coveredFrom.IsZero() && initial.Before(l.coveredFrom) {
+		return initial
+	}
+	return l.highWaterMark.Add(-ingestOverlap)
+}
+
+// ingest adds the events that were not ingested by an earlier fetch and
+// returns how many were new. events must cover everything from since
+// onwards, as returned by a single fetch starting at since().
+func (l *usageLedger) ingest(since time.Time, events []client.UsageEvent) int {
+	batch := make(map[eventKey]int)
+	added := 0
+
+	// Events between the covered start and the overlap were ingested by
+	// earlier fetches and are no longer in seen. The bounds are those from
+	// before this fetch, as events may come in any order; events in the
+	// overlap are told apart by seen.
+	hwm, covered := l.highWaterMark, l.coveredFrom
+	ingested := func(ts time.Time) bool {
+		return !hwm.IsZero() && !covered.IsZero() && !ts.Before(covered) && ts.Before(hwm.Add(-ingestOverlap))
+	}
+	for _, event := range events {
+		if ingested(event.Timestamp) {
+			continue
+		}
+		key := eventKeyOf(event)
+		batch[key]++
+		if batch[key] <= l.seen[key] {
+			continue
+		}
+
+		l.add(event)
+		added++
+
+		if event.Timestamp.After(l.highWaterMark) {
+			l.highWaterMark = event.Timestamp
+		}
+	}
+
+	for key, count := range batch {
+		if count > l.seen[key] {
+			l.seen[key] = count
+		}
+	}
+	if l.coveredFrom.IsZero() || since.Before(l.coveredFrom) {
+		l.coveredFrom = since
+	}
+
+	cutoff := l.highWaterMark.Add(-ingestOverlap).UnixMilli()
+	for key := range l.seen {
+		if key.timestamp < cutoff {
+			delete(l.seen, key)
+		}
+	}
+
+	return added
+}
+
+func (l *usageLedger) add(event client.UsageEvent) {
+	cost, _ := l.pricing.Cost(event)
+
+	key := usageKey{UserEmail: event.UserEmail, Model: event.Model, Kind: event.EventType}
+	total, ok := l.totals[key]
+	if !ok {
+		total = &usageTotals{}
+		l.totals[key] = total
+	}
+	total.addEvent(event, cost)
+
+	date := event.Timestamp.Format("2006-01-02")
+	day, ok := l.daily[date]
+	if !ok {
+		day = &usageTotals{}
+		l.daily[date] = day
+	}
+	day.addEvent(event, cost)
+}
+
+// pruneDaily drops per-day totals older than before. The lifetime totals are
+// never pruned.
+func (l *usageLedger) pruneDaily(before time.Time) {
+	cutoff := before.Format("2006-01-02")
+	for date := range l.daily {
+		if date < cutoff {
+			delete(l.daily, date)
+		}
+	}
+}
+
+// window sums the per-day totals from start onwards.
+func (l *usageLedger) window(start time.Time) usageTotals {
+	startDate := start.Format("2006-01-02")
+
+	var sum usageTotals
+	for date, day := range l.daily {
+		if date >= startDate {
+			sum.merge(*day)
+		}
+	}
+	return sum
+}
+
+// usageLedgerState is the JSON form of a usageLedger.
+type usageLedgerState struct {
+	HighWaterMark time.Time              `json:"high_water_mark"`
+	CoveredFrom   time.Time              `json:"covered_from,omitzero"`
+	Seen          []seenEventState       `json:"seen,omitempty"`
+	Totals        []usageTotalsState     `json:"totals,omitempty"`
+	Daily         map[string]usageTotals `json:"daily,omitempty"`
+}
+
+type seenEventState struct {
+	Timestamp int64  `json:"timestamp"`
+	UserEmail string `json:"user_email"`
+	Model     string `json:"model"`
+	Kind      string `json:"kind"`
+	Tokens    int    `json:"tokens"`
+	Count     int    `json:"count"`
+}
+
+type usageTotalsState struct {
+	usageKey
+	usageTotals
+}
+
+func (l *usageLedger) state() usageLedgerState {
+	s := usageLedgerState{
+		HighWaterMark: l.highWaterMark,
+		CoveredFrom:   l.coveredFrom,
+		Daily:         make(map[string]usageTotals, len(l.daily)),
+	}
+	for key, count := range l.seen {
+		s.Seen = append(s.Seen, seenEventState{
+			Timestamp: key.timestamp,
+			UserEmail: key.userEmail,
+			Model:     key.model,
+			Kind:      key.kind,
+			Tokens:    key.tokens,
+			Count:     count,
+		})
+	}
+	for key, total := range l.totals {
+		s.Totals = append(s.Totals, usageTotalsState{usageKey: key, usageTotals: *total})
+	}
+	for date, day := range l.daily {
+		s.Daily[date] = *day
+	}
+	return s
+}
+
+func (l *usageLedger) restore(s usageLedgerState) {
+	prices := l.pricing
+	*l = *newUsageLedger()
+	l.pricing = prices
+	l.highWaterMark = s.HighWaterMark
+	l.coveredFrom = s.CoveredFrom
+	for _, seen := range s.Seen {
+		l.seen[eventKey{
+			timestamp: seen.Timestamp,
+			userEmail: seen.UserEmail,
+			model:     seen.Model,
+			kind:      seen.Kind,
+			tokens:    seen.Tokens,
+		}] = seen.Count
+	}
+	for _, total := range s.Totals {
+		t := total.usageTotals
+		l.totals[total.usageKey] = &t
+	}
+	for date, day := range s.Daily {
+		d := day
+		l.daily[date] = &d
+	}
+}
@@ -0,0 +1,140 @@
+package exporters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+)
+
+func usageEvent(ts time.Time, user, model string, tokens int) client.UsageEvent {
+	return client.UsageEvent{
+		EventType:      "Included in Business",
+		UserEmail:      user,
+		Model:          model,
+		TokensConsumed: tokens,
+		Timestamp:      ts,
+	}
+}
+
+func TestUsageLedger_IngestSkipsOverlap(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	ledger := newUsageLedger()
+
+	initial := base.Add(-time.Hour)
+	if got := ledger.since(initial); !got.Equal(initial) {
+		t.Errorf("Expected first fetch to start at %v, got %v", initial, got)
+	}
+
+	first := []client.UsageEvent{
+		usageEvent(base, "john@example.com", "gpt-4", 100),
+		usageEvent(base.Add(time.Minute), "jane@example.com", "claude-3", 50),
+	}
+	if added := ledger.ingest(initial, first); added != 2 {
+		t.Fatalf("Expected 2 new events, got %d", added)
+	}
+
+	want := base.Add(time.Minute).Add(-ingestOverlap)
+	if got := ledger.since(initial); !got.Equal(want) {
+		t.Errorf("Expected next fetch to start at %v, got %v", want, got)
+	}
+
+	// The second fetch overlaps the first and includes one late event.
+	second := []client.UsageEvent{
+		usageEvent(base, "john@example.com", "gpt-4", 100),
+		usageEvent(base.Add(30*time.Second), "john@example.com", "gpt-4", 10),
+		usageEvent(base.Add(time.Minute), "jane@example.com", "claude-3", 50),
+		usageEvent(base.Add(2*time.Minute), "jane@example.com", "claude-3", 25),
+	}
+	if added := ledger.ingest(want, second); added != 2 {
+		t.Fatalf("Expected 2 new events, got %d", added)
+	}
+
+	john := ledger.totals[usageKey{UserEmail: "john@example.com", Model: "gpt-4", Kind: "Included in Business"}]
+	if john.Events != 2 || john.Tokens != 110 {
+		t.Errorf("Expected john totals {2 110}, got %+v", *john)
+	}
+	jane := ledger.totals[usageKey{UserEmail: "jane@example.com", Model: "claude-3", Kind: "Included in Business"}]
+	if jane.Events != 2 || jane.Tokens != 75 {
+		t.Errorf("Expected jane totals {2 75}, got %+v", *jane)
+	}
+}
+
+func TestUsageLedger_IngestCountsIdenticalEvents(t *testing.T) {
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	ledger := newUsageLedger()
+
+	event := usageEvent(ts, "john@example.com", "gpt-4", 5)
+	if added := ledger.ingest(ts, []client.UsageEvent{event, event}); added != 2 {
+		t.Fatalf("Expected both identical events to be ingested, got %d", added)
+	}
+	if added := ledger.ingest(ts, []client.UsageEvent{event, event, event}); added != 1 {
+		t.Fatalf("Expected only the third identical event to be new, got %d", added)
+	}
+}
+
+func TestUsageLedger_BackfillsEarlierStart(t *testing.T) {
+	base := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	events := []client.UsageEvent{
+		usageEvent(base.AddDate(0, 0, -5), "john@example.com", "gpt-4", 1),
+		usageEvent(base.AddDate(0, 0, -1), "john@example.com", "gpt-4", 10),
+		usageEvent(base.Add(-5*time.Minute), "john@example.com", "gpt-4", 100),
+		usageEvent(base, "john@example.com", "gpt-4", 1000),
+	}
+	fetch := func(since time.Time) []client.UsageEvent {
+		var fetched []client.UsageEvent
+		for _, event := range events {
+			if !event.Timestamp.Before(since) {
+				fetched = append(fetched, event)
+			}
+		}
+		return fetched
+	}
+
+	ledger := newUsageLedger()
+	lookback := base.AddDate(0, 0, -2)
+	ledger.ingest(lookback, fetch(lookback))
+	if got := ledger.since(lookback); !got.Equal(base.Add(-ingestOverlap)) {
+		t.Errorf("Expected an incremental fetch, got %v", got)
+	}
+
+	// A billing cycle start before the lookback is learned later.
+	cycle := base.AddDate(0, 0, -7)
+	since := ledger.since(cycle)
+	if !since.Equal(cycle) {
+		t.Fatalf("Expected the fetch to start at the earlier cycle start, got %v", since)
+	}
+	if added := ledger.ingest(since, fetch(since)); added != 1 {
+		t.Errorf("Expected only the event before the lookback to be new, got %d", added)
+	}
+	if got := ledger.window(cycle); got.Events != 4 || got.Tokens != 1111 {
+		t.Errorf("Expected every event once in the cycle window, got %+v", got)
+	}
+	if got := ledger.since(cycle); !got.Equal(base.Add(-ingestOverlap)) {
+		t.Errorf("Expected an incremental fetch once the cycle is covered, got %v", got)
+	}
+}
+
+func TestUsageLedger_WindowAndPrune(t *testing.T) {
+	day := time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)
+	ledger := newUsageLedger()
+	ledger.ingest(day.AddDate(0, 0, -10), []client.UsageEvent{
+		usageEvent(day.AddDate(0, 0, -10), "john@example.com", "gpt-4", 1),
+		usageEvent(day.AddDate(0, 0, -2), "john@example.com", "gpt-4", 10),
+		usageEvent(day, "john@example.com", "gpt-4", 100),
+	})
+
+	if got := ledger.window(startOfDay(day).AddDate(0, 0, -6)); got.Events != 2 || got.Tokens != 110 {
+		t.Errorf("Expected 7d window {2 110}, got %+v", got)
+	}
+
+	ledger.pruneDaily(startOfDay(day).AddDate(0, 0, -5))
+	if got := ledger.window(time.Time{}); got.Events != 2 {
+		t.Errorf("Expected pruning to drop the oldest day, got %+v", got)
+	}
+
+	total := ledger.totals[usageKey{UserEmail: "john@example.com", Model: "gpt-4", Kind: "Included in Business"}]
+	if total.Events != 3 {
+		t.Errorf("Expected lifetime totals to survive pruning, got %+v", *total)
+	}
+}
@@ -0,0 +1,75 @@
+package exporters
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultLookbackDays = 30
+
+	windowToday        = "today"
+	windowLast7Days    = "7d"
+	windowBillingCycle = "billing_cycle"
+)
+
+// billingCycle shares the subscription cycle start reported by the spending
+// endpoint with the collectors that aggregate over the current cycle. A nil
+// *billingCycle is valid and never knows the cycle start.
+type billingCycle struct {
+	mu    sync.RWMutex
+	start time.Time
+}
+
+func (b *billingCycle) set(start time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.start = start
+	b.mu.Unlock()
+}
+
+func (b *billingCycle) Start() time.Time {
+	if b == nil {
+		return time.Time{}
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.start
+}
+
+type window struct {
+	name  string
+	start time.Time
+}
+
+// rollingWindows returns the pre-aggregated windows that end now. The billing
+// cycle window is left out until the cycle start is known.
+func rollingWindows(now, cycleStart time.Time) []window {
+	today := startOfDay(now)
+	windows := []window{
+		{name: windowToday, start: today},
+		{name: windowLast7Days, start: today.AddDate(0, 0, -6)},
+	}
+	if !cycleStart.IsZero() {
+		windows = append(windows, window{name: windowBillingCycle, start: cycleStart})
+	}
+	return windows
+}
+
+// fetchStart returns the earliest time a collector has to fetch so that both
+// its lookback and every window are covered.
+func fetchStart(lookbackStart time.Time, windows []window) time.Time {
+	start := lookbackStart
+	for _, w := range windows {
+		if w.start.Before(start) {
+			start = w.start
+		}
+	}
+	return start
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
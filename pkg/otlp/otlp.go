@@ -0,0 +1,157 @@
+// Package otlp pushes the exporter's metrics to an OpenTelemetry Collector
+// over OTLP, alongside or instead of serving them to Prometheus.
+package otlp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	prometheusbridge "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// Protocol is the OTLP transport.
+type Protocol string
+
+const (
+	// GRPC sends metrics with OTLP/gRPC, by default to port 4317.
+	GRPC Protocol = "grpc"
+	// HTTP sends metrics with OTLP/HTTP in protobuf, by default to port 4318.
+	HTTP Protocol = "http/protobuf"
+)
+
+// Protocols lists the supported protocols.
+var Protocols = []Protocol{GRPC, HTTP}
+
+// ServiceName is the service.name resource attribute of every source.
+const ServiceName = "cursor-admin-api-exporter"
+
+// TeamAttribute is the resource attribute holding the team of a source.
+const TeamAttribute = "cursor.team"
+
+// Options configures where and how often metrics are pushed.
+type Options struct {
+	// Endpoint is the collector's host:port, or a URL. An http:// URL
+	// implies Insecure, and the path of an OTLP/HTTP URL replaces
+	// /v1/metrics.
+	Endpoint string
+	Protocol Protocol
+	// Interval is how often metrics are pushed.
+	Interval time.Duration
+	// Timeout bounds a single push, retries included.
+	Timeout time.Duration
+	// Insecure disables TLS.
+	Insecure bool
+	// Headers are sent with every push, e.g. for authentication.
+	Headers map[string]string
+	// Version is the exporter version, set as service.version.
+	Version string
+}
+
+// Source is a set of metrics pushed with its own resource.
+type Source struct {
+	// Team is set as the cursor.team resource attribute, unless empty.
+	Team     string
+	Gatherer prometheus.Gatherer
+}
+
+// Pusher periodically pushes the metrics of its sources. Each source has
+// its own meter provider, so its metrics are sent with its own resource
+// rather than with a team label.
+type Pusher struct {
+	providers []*sdkmetric.MeterProvider
+}
+
+// New starts pushing the metrics of sources every opts.Interval. Errors of
+// pushes are logged and do not stop later pushes.
+func New(ctx context.Context, opts Options, sources []Source) (*Pusher, error) {
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logrus.WithError(err).Warn("Failed to push metrics over OTLP")
+	}))
+
+	p := &Pusher{}
+	for _, source := range sources {
+		exporter, err := newExporter(ctx, opts)
+		if err != nil {
+			return nil, errors.Join(err, p.Shutdown(ctx))
+		}
+		reader := sdkmetric.NewPeriodicReader(exporter,
+			sdkmetric.WithInterval(opts.Interval),
+			sdkmetric.WithTimeout(opts.Timeout),
+			sdkmetric.WithProducer(prometheusbridge.NewMetricProducer(prometheusbridge.WithGatherer(source.Gatherer))),
+		)
+		p.providers = append(p.providers, sdkmetric.NewMeterProvider(
+			sdkmetric.WithReader(reader),
+			sdkmetric.WithResource(newResource(opts.Version, source.Team)),
+		))
+	}
+	return p, nil
+}
+
+// ForceFlush pushes the current metrics of every source.
+func (p *Pusher) ForceFlush(ctx context.Context) error {
+	var errs []error
+	for _, provider := range p.providers {
+		errs = append(errs, provider.ForceFlush(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// Shutdown pushes the metrics one last time and stops pushing.
+func (p *Pusher) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, provider := range p.providers {
+		errs = append(errs, provider.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+func newExporter(ctx context.Context, opts Options) (sdkmetric.Exporter, error) {
+	isURL := strings.Contains(opts.Endpoint, "://")
+	switch opts.Protocol {
+	case GRPC:
+		options := []otlpmetricgrpc.Option{otlpmetricgrpc.WithTimeout(opts.Timeout), otlpmetricgrpc.WithHeaders(opts.Headers)}
+		if isURL {
+			options = append(options, otlpmetricgrpc.WithEndpointURL(opts.Endpoint))
+		} else {
+			options = append(options, otlpmetricgrpc.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			options = append(options, otlpmetricgrpc.WithInsecure())
+		}
+		return otlpmetricgrpc.New(ctx, options...)
+	case HTTP:
+		options := []otlpmetrichttp.Option{otlpmetrichttp.WithTimeout(opts.Timeout), otlpmetrichttp.WithHeaders(opts.Headers)}
+		if isURL {
+			options = append(options, otlpmetrichttp.WithEndpointURL(opts.Endpoint))
+		} else {
+			options = append(options, otlpmetrichttp.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			options = append(options, otlpmetrichttp.WithInsecure())
+		}
+		return otlpmetrichttp.New(ctx, options...)
+	}
+	return nil, fmt.Errorf("unsupported OTLP protocol %q, use %q or %q", opts.Protocol, GRPC, HTTP)
+}
+
+func newResource(version, team string) *resource.Resource {
+	attributes := []attribute.KeyValue{
+		attribute.String("service.name", ServiceName),
+		attribute.String("service.version", version),
+	}
+	if team != "" {
+		attributes = append(attributes, attribute.String(TeamAttribute, team))
+	}
+	return resource.NewSchemaless(attributes...)
+}
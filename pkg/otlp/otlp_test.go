@@ -0,0 +1,188 @@
+package otlp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector is a stand-in for an OpenTelemetry Collector that records the
+// requests it receives over OTLP/gRPC and OTLP/HTTP.
+type collector struct {
+	collectorpb.UnimplementedMetricsServiceServer
+
+	mu       sync.Mutex
+	requests []*collectorpb.ExportMetricsServiceRequest
+	headers  []string
+}
+
+func (c *collector) Export(_ context.Context, request *collectorpb.ExportMetricsServiceRequest) (*collectorpb.ExportMetricsServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, request)
+	return &collectorpb.ExportMetricsServiceResponse{}, nil
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/metrics" {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request := &collectorpb.ExportMetricsServiceRequest{}
+	if err := proto.Unmarshal(body, request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	c.headers = append(c.headers, r.Header.Get("Authorization"))
+	c.mu.Unlock()
+	if _, err := c.Export(r.Context(), request); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	response, _ := proto.Marshal(&collectorpb.ExportMetricsServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(response)
+}
+
+// resources maps the cursor.team resource attribute to the metrics pushed
+// with it, and checks the attributes every resource has.
+func (c *collector) resources(t *testing.T) map[string][]*metricspb.Metric {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resources := make(map[string][]*metricspb.Metric)
+	for _, request := range c.requests {
+		for _, rm := range request.GetResourceMetrics() {
+			attributes := make(map[string]string)
+			for _, kv := range rm.GetResource().GetAttributes() {
+				attributes[kv.GetKey()] = kv.GetValue().GetStringValue()
+			}
+			if attributes["service.name"] != ServiceName || attributes["service.version"] != "1.2.3" {
+				t.Errorf("Expected the service name and version, got %v", attributes)
+			}
+			for _, sm := range rm.GetScopeMetrics() {
+				resources[attributes[TeamAttribute]] = append(resources[attributes[TeamAttribute]], sm.GetMetrics()...)
+			}
+		}
+	}
+	return resources
+}
+
+func gatherer(spending float64) prometheus.Gatherer {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "cursor_spending_by_member_cents", Help: "Spending by team member in cents"}, []string{"member_email"})
+	gauge.WithLabelValues("alice@example.com").Set(spending)
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "cursor_usage_events_total", Help: "Total number of usage events"})
+	counter.Add(3)
+	registry.MustRegister(gauge, counter)
+	return registry
+}
+
+func push(t *testing.T, opts Options) {
+	t.Helper()
+	opts.Interval = time.Hour
+	opts.Timeout = 5 * time.Second
+	opts.Version = "1.2.3"
+
+	ctx := context.Background()
+	pusher, err := New(ctx, opts, []Source{
+		{Team: "sales", Gatherer: gatherer(2500)},
+		{Team: "platform", Gatherer: gatherer(700)},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create pusher: %v", err)
+	}
+	if err := pusher.ForceFlush(ctx); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+	if err := pusher.Shutdown(ctx); err != nil {
+		t.Fatalf("Failed to shut down: %v", err)
+	}
+}
+
+func checkPushed(t *testing.T, c *collector) {
+	t.Helper()
+	resources := c.resources(t)
+	for team, want := range map[string]float64{"sales": 2500, "platform": 700} {
+		var spending, events *metricspb.Metric
+		for _, metric := range resources[team] {
+			switch metric.GetName() {
+			case "cursor_spending_by_member_cents":
+				spending = metric
+			case "cursor_usage_events_total":
+				events = metric
+			}
+		}
+		if spending == nil || events == nil {
+			t.Fatalf("Expected the metrics of team %s, got %v", team, resources[team])
+		}
+
+		point := spending.GetGauge().GetDataPoints()[0]
+		if point.GetAsDouble() != want {
+			t.Errorf("Expected spending %v for team %s, got %v", want, team, point.GetAsDouble())
+		}
+		if attributes := point.GetAttributes(); len(attributes) != 1 || attributes[0].GetKey() != "member_email" {
+			t.Errorf("Expected labels to become attributes, got %v", attributes)
+		}
+		if sum := events.GetSum(); !sum.GetIsMonotonic() || sum.GetDataPoints()[0].GetAsDouble() != 3 {
+			t.Errorf("Expected the counter as a monotonic sum of 3, got %v", sum)
+		}
+	}
+}
+
+func TestPusher_HTTP(t *testing.T) {
+	c := &collector{}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	push(t, Options{Endpoint: server.URL, Protocol: HTTP, Headers: map[string]string{"Authorization": "Bearer secret"}})
+
+	checkPushed(t, c)
+	for _, header := range c.headers {
+		if header != "Bearer secret" {
+			t.Errorf("Expected the configured headers, got %q", header)
+		}
+	}
+}
+
+func TestPusher_GRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	c := &collector{}
+	server := grpc.NewServer()
+	collectorpb.RegisterMetricsServiceServer(server, c)
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+
+	push(t, Options{Endpoint: listener.Addr().String(), Protocol: GRPC, Insecure: true})
+
+	checkPushed(t, c)
+}
+
+func TestNew_UnsupportedProtocol(t *testing.T) {
+	_, err := New(context.Background(), Options{Endpoint: "localhost:4317", Protocol: "http/json"}, []Source{{Gatherer: prometheus.NewRegistry()}})
+	if err == nil {
+		t.Error("Expected an error for an unsupported protocol")
+	}
+}
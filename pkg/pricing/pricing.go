@@ -0,0 +1,128 @@
+package pricing
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+)
+
+// ModelPrice is the price of one model in dollars per million tokens.
+type ModelPrice struct {
+	Input      float64 `yaml:"input"`
+	Output     float64 `yaml:"output"`
+	CacheRead  float64 `yaml:"cache_read"`
+	CacheWrite float64 `yaml:"cache_write"`
+}
+
+// Table maps usage events to an estimated cost in dollars.
+//
+// Events whose kind is listed in RequestKinds cost a flat amount per request,
+// whatever their token usage. Every other event is priced by its tokens using
+// the entry in Models that matches its model, either exactly or through a
+// glob pattern such as "claude-3.5-*". The most specific pattern wins.
+type Table struct {
+	Models       map[string]ModelPrice `yaml:"models"`
+	RequestKinds map[string]float64    `yaml:"request_kinds"`
+
+	patterns []string
+}
+
+// LoadFile reads a pricing table from a YAML file.
+func LoadFile(filename string) (*Table, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pricing file: %w", err)
+	}
+
+	table, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pricing file %s: %w", filename, err)
+	}
+	return table, nil
+}
+
+// Parse decodes a pricing table from YAML.
+func Parse(data []byte) (*Table, error) {
+	var table Table
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&table); err != nil {
+		return nil, err
+	}
+
+	if err := table.init(); err != nil {
+		return nil, err
+	}
+	return &table, nil
+}
+
+func (t *Table) init() error {
+	for model, price := range t.Models {
+		if price.Input < 0 || price.Output < 0 || price.CacheRead < 0 || price.CacheWrite < 0 {
+			return fmt.Errorf("model %q: prices must not be negative", model)
+		}
+		if !strings.ContainsAny(model, "*?[") {
+			continue
+		}
+		if _, err := path.Match(model, ""); err != nil {
+			return fmt.Errorf("model %q: invalid pattern: %w", model, err)
+		}
+		t.patterns = append(t.patterns, model)
+	}
+	for kind, price := range t.RequestKinds {
+		if price < 0 {
+			return fmt.Errorf("request kind %q: price must not be negative", kind)
+		}
+	}
+
+	// Longer patterns are more specific and are tried first.
+	sort.Slice(t.patterns, func(i, j int) bool {
+		if len(t.patterns[i]) != len(t.patterns[j]) {
+			return len(t.patterns[i]) > len(t.patterns[j])
+		}
+		return t.patterns[i] < t.patterns[j]
+	})
+	return nil
+}
+
+// Cost returns the estimated cost of event in dollars. It reports false if
+// the table has no price for the event. A nil table prices nothing.
+func (t *Table) Cost(event client.UsageEvent) (float64, bool) {
+	if t == nil {
+		return 0, false
+	}
+
+	if price, ok := t.RequestKinds[event.EventType]; ok {
+		return price, true
+	}
+
+	price, ok := t.modelPrice(event.Model)
+	if !ok {
+		return 0, false
+	}
+
+	cost := float64(event.InputTokens)*price.Input +
+		float64(event.OutputTokens)*price.Output +
+		float64(event.CacheReadTokens)*price.CacheRead +
+		float64(event.CacheWriteTokens)*price.CacheWrite
+	return cost / 1e6, true
+}
+
+func (t *Table) modelPrice(model string) (ModelPrice, bool) {
+	if price, ok := t.Models[model]; ok {
+		return price, true
+	}
+	for _, pattern := range t.patterns {
+		if matched, _ := path.Match(pattern, model); matched {
+			return t.Models[pattern], true
+		}
+	}
+	return ModelPrice{}, false
+}
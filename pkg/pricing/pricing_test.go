@@ -0,0 +1,139 @@
+package pricing
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/client"
+)
+
+const testTable = `
+models:
+  gpt-4:
+    input: 30
+    output: 60
+  claude-3.5-*:
+    input: 3
+    output: 15
+    cache_read: 0.3
+    cache_write: 3.75
+  claude-*:
+    input: 10
+    output: 10
+request_kinds:
+  "Usage-based": 0.04
+  "Errored, Not Charged": 0
+`
+
+func TestTable_Cost(t *testing.T) {
+	table, err := Parse([]byte(testTable))
+	if err != nil {
+		t.Fatalf("Failed to parse table: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		event  client.UsageEvent
+		cost   float64
+		priced bool
+	}{
+		{
+			name:   "exact model match",
+			event:  client.UsageEvent{Model: "gpt-4", InputTokens: 1000, OutputTokens: 500},
+			cost:   0.06,
+			priced: true,
+		},
+		{
+			name:   "most specific pattern wins",
+			event:  client.UsageEvent{Model: "claude-3.5-sonnet", InputTokens: 1_000_000, CacheReadTokens: 1_000_000, CacheWriteTokens: 1_000_000},
+			cost:   3 + 0.3 + 3.75,
+			priced: true,
+		},
+		{
+			name:   "fallback pattern",
+			event:  client.UsageEvent{Model: "claude-3-opus", OutputTokens: 100_000},
+			cost:   1,
+			priced: true,
+		},
+		{
+			name:   "request kind overrides tokens",
+			event:  client.UsageEvent{EventType: "Usage-based", Model: "gpt-4", InputTokens: 1_000_000},
+			cost:   0.04,
+			priced: true,
+		},
+		{
+			name:   "free request kind",
+			event:  client.UsageEvent{EventType: "Errored, Not Charged", Model: "gpt-4", InputTokens: 1_000_000},
+			cost:   0,
+			priced: true,
+		},
+		{
+			name:   "unknown model",
+			event:  client.UsageEvent{Model: "mystery", InputTokens: 10},
+			priced: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cost, priced := table.Cost(tt.event)
+			if priced != tt.priced {
+				t.Fatalf("Expected priced=%v, got %v", tt.priced, priced)
+			}
+			if math.Abs(cost-tt.cost) > 1e-9 {
+				t.Errorf("Expected cost %f, got %f", tt.cost, cost)
+			}
+		})
+	}
+}
+
+func TestTable_NilTablePricesNothing(t *testing.T) {
+	var table *Table
+	if _, priced := table.Cost(client.UsageEvent{Model: "gpt-4", InputTokens: 1}); priced {
+		t.Error("Expected nil table to price nothing")
+	}
+}
+
+func TestParse_RejectsInvalidTables(t *testing.T) {
+	tests := map[string]string{
+		"negative price":  "models:\n  gpt-4:\n    input: -1\n",
+		"negative kind":   "request_kinds:\n  Usage-based: -0.04\n",
+		"unknown field":   "models:\n  gpt-4:\n    inptu: 1\n",
+		"invalid pattern": "models:\n  \"claude-[\":\n    input: 1\n",
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse([]byte(data)); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pricing.yaml")
+	if err := os.WriteFile(path, []byte(testTable), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	table, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to load file: %v", err)
+	}
+	if len(table.Models) != 3 || len(table.RequestKinds) != 2 {
+		t.Errorf("Expected 3 models and 2 request kinds, got %d and %d", len(table.Models), len(table.RequestKinds))
+	}
+
+	if _, err := LoadFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
+
+func TestLoadFile_Example(t *testing.T) {
+	if _, err := LoadFile(filepath.Join("..", "..", "pricing.example.yaml")); err != nil {
+		t.Errorf("Failed to load example pricing file: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+// Package privacy rewrites the user identities in metric labels before the
+// metrics leave the exporter.
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/matanbaruch/cursor-admin-api-exporter/pkg/utils"
+)
+
+// Mode is how a Policy rewrites user identities.
+type Mode string
+
+const (
+	// Keep exports identities unchanged.
+	Keep Mode = "keep"
+	// Hash replaces identities with a keyed hash, stable across restarts
+	// for a given salt, so series can still be told apart.
+	Hash Mode = "hash"
+	// Alias replaces identities with configured aliases. Identities without
+	// an alias are merged into OtherAlias.
+	Alias Mode = "alias"
+	// Domain keeps only the domain of an email address.
+	Domain Mode = "domain"
+	// Drop removes identity labels altogether.
+	Drop Mode = "drop"
+)
+
+// Modes lists every Mode.
+var Modes = []Mode{Keep, Hash, Alias, Domain, Drop}
+
+// OtherAlias is the alias of identities that have none in Alias mode.
+const OtherAlias = "other"
+
+// hashLength is the number of hex digits of the hash kept in Hash mode.
+const hashLength = 16
+
+// IdentityLabels are the labels that hold user identities, including the
+// manager added from the user directory.
+var IdentityLabels = []string{"user_email", "member_email", "manager"}
+
+// maxAggregated lists the metrics whose series are merged by keeping the
+// highest value rather than the sum, as summing them would be meaningless.
+var maxAggregated = map[string]bool{
+	"cursor_budget_utilization_ratio": true,
+	"cursor_budget_exceeded":          true,
+}
+
+// Policy rewrites user identities in metric labels. A nil Policy keeps them.
+type Policy struct {
+	mode    Mode
+	salt    []byte
+	aliases map[string]string
+}
+
+// NewPolicy returns a Policy for mode. Hash mode requires a salt, which
+// must be kept secret for hashes not to be reversed by hashing known
+// addresses. aliases maps identities to their alias in Alias mode and is
+// matched case-insensitively.
+func NewPolicy(mode Mode, salt string, aliases map[string]string) (*Policy, error) {
+	p := &Policy{mode: mode}
+	switch mode {
+	case Keep, Domain, Drop:
+	case Hash:
+		if salt == "" {
+			return nil, errors.New("hash mode requires a salt")
+		}
+		p.salt = []byte(salt)
+	case Alias:
+		p.aliases = make(map[string]string, len(aliases))
+		for identity, alias := range aliases {
+			p.aliases[normalize(identity)] = alias
+		}
+	default:
+		return nil, fmt.Errorf("unknown identity mode %q", mode)
+	}
+	return p, nil
+}
+
+// Mode returns the mode of p.
+func (p *Policy) Mode() Mode {
+	if p == nil {
+		return Keep
+	}
+	return p.mode
+}
+
+// Identity returns what identity becomes under p. Empty identities stay
+// empty.
+func (p *Policy) Identity(identity string) string {
+	if identity == "" {
+		return ""
+	}
+	switch p.Mode() {
+	case Hash:
+		mac := hmac.New(sha256.New, p.salt)
+		mac.Write([]byte(normalize(identity)))
+		return hex.EncodeToString(mac.Sum(nil))[:hashLength]
+	case Alias:
+		if alias, ok := p.aliases[normalize(identity)]; ok {
+			return alias
+		}
+		return OtherAlias
+	case Domain:
+		if i := strings.LastIndex(identity, "@"); i >= 0 && i < len(identity)-1 {
+			return strings.ToLower(identity[i+1:])
+		}
+		return "unknown"
+	case Drop:
+		return ""
+	}
+	return identity
+}
+
+// Apply rewrites the identity labels of families in place and returns them.
+// Series that end up with the same labels, for instance the users of one
+// domain, are merged by summing their values, or keeping the highest for
+// ratios.
+func (p *Policy) Apply(families []*dto.MetricFamily) []*dto.MetricFamily {
+	if p.Mode() == Keep {
+		return families
+	}
+	for _, family := range families {
+		if !hasIdentity(family) {
+			continue
+		}
+		for _, metric := range family.Metric {
+			metric.Label = p.relabel(metric.Label)
+		}
+		combine := utils.Sum
+		if maxAggregated[family.GetName()] {
+			combine = utils.Max
+		}
+		family.Metric = utils.MergeMetrics(family.Metric, combine)
+	}
+	return families
+}
+
+func (p *Policy) relabel(labels []*dto.LabelPair) []*dto.LabelPair {
+	relabelled := labels[:0]
+	for _, label := range labels {
+		if isIdentityLabel(label.GetName()) {
+			if p.mode == Drop {
+				continue
+			}
+			label.Value = proto.String(p.Identity(label.GetValue()))
+		}
+		relabelled = append(relabelled, label)
+	}
+	return relabelled
+}
+
+func hasIdentity(family *dto.MetricFamily) bool {
+	for _, metric := range family.Metric {
+		for _, label := range metric.Label {
+			if isIdentityLabel(label.GetName()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isIdentityLabel(name string) bool {
+	for _, identity := range IdentityLabels {
+		if name == identity {
+			return true
+		}
+	}
+	return false
+}
+
+func normalize(identity string) string {
+	return strings.ToLower(strings.TrimSpace(identity))
+}
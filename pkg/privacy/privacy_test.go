@@ -0,0 +1,161 @@
+package privacy
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func gauge(name string, value float64, labels ...string) *dto.MetricFamily {
+	metric := &dto.Metric{Gauge: &dto.Gauge{Value: proto.Float64(value)}}
+	for i := 0; i < len(labels); i += 2 {
+		metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(labels[i]), Value: proto.String(labels[i+1])})
+	}
+	return &dto.MetricFamily{Name: proto.String(name), Type: dto.MetricType_GAUGE.Enum(), Metric: []*dto.Metric{metric}}
+}
+
+// families returns spending and budget metrics of three members, as the
+// exporter would gather them.
+func families() []*dto.MetricFamily {
+	spending := gauge("cursor_spending_by_member_cents", 2500, "member_email", "alice@example.com", "date", "2025-01-01")
+	spending.Metric = append(spending.Metric,
+		gauge("", 500, "member_email", "Bob@Example.com", "date", "2025-01-01").Metric[0],
+		gauge("", 100, "member_email", "carol@other.org", "date", "2025-01-01").Metric[0],
+	)
+	ratio := gauge("cursor_budget_utilization_ratio", 1.25, "rule", "members", "scope", "role", "member_email", "alice@example.com")
+	ratio.Metric = append(ratio.Metric,
+		gauge("", 0.25, "rule", "members", "scope", "role", "member_email", "bob@example.com").Metric[0],
+		gauge("", 0.5, "rule", "team", "scope", "team", "member_email", "").Metric[0],
+	)
+	return []*dto.MetricFamily{spending, ratio, gauge("cursor_team_members_total", 3)}
+}
+
+// values maps the value of label to the metric value for the family named
+// name.
+func values(t *testing.T, families []*dto.MetricFamily, name, label string) map[string]float64 {
+	t.Helper()
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		values := make(map[string]float64)
+		for _, metric := range family.Metric {
+			value := "<absent>"
+			for _, pair := range metric.Label {
+				if pair.GetName() == label {
+					value = pair.GetValue()
+				}
+			}
+			if _, ok := values[value]; ok {
+				t.Errorf("Expected one %s series with %s=%q", name, label, value)
+			}
+			values[value] = metric.GetGauge().GetValue()
+		}
+		return values
+	}
+	t.Fatalf("Expected a %s family", name)
+	return nil
+}
+
+func TestNewPolicy_Invalid(t *testing.T) {
+	if _, err := NewPolicy(Hash, "", nil); err == nil {
+		t.Error("Expected an error for hash mode without a salt")
+	}
+	if _, err := NewPolicy("obfuscate", "", nil); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
+
+func TestPolicy_Identity(t *testing.T) {
+	hash, _ := NewPolicy(Hash, "salt", nil)
+	otherSalt, _ := NewPolicy(Hash, "pepper", nil)
+	alias, _ := NewPolicy(Alias, "", map[string]string{"Alice@Example.com": "alice"})
+	domain, _ := NewPolicy(Domain, "", nil)
+
+	hashed := hash.Identity("alice@example.com")
+	if len(hashed) != hashLength || hashed == "alice@example.com" {
+		t.Errorf("Expected a %d digit hash, got %q", hashLength, hashed)
+	}
+	if got := hash.Identity(" ALICE@example.com"); got != hashed {
+		t.Errorf("Expected the hash to ignore case and spaces, got %q and %q", got, hashed)
+	}
+	if got := otherSalt.Identity("alice@example.com"); got == hashed {
+		t.Error("Expected the hash to depend on the salt")
+	}
+
+	tests := []struct {
+		policy   *Policy
+		identity string
+		want     string
+	}{
+		{nil, "alice@example.com", "alice@example.com"},
+		{alias, "alice@example.com", "alice"},
+		{alias, "bob@example.com", OtherAlias},
+		{domain, "bob@Example.com", "example.com"},
+		{domain, "bob", "unknown"},
+		{hash, "", ""},
+		{domain, "", ""},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.Identity(tt.identity); got != tt.want {
+			t.Errorf("%s: expected %q for %q, got %q", tt.policy.Mode(), tt.want, tt.identity, got)
+		}
+	}
+}
+
+func TestPolicy_Apply_Keep(t *testing.T) {
+	var policy *Policy
+	got := values(t, policy.Apply(families()), "cursor_spending_by_member_cents", "member_email")
+	if len(got) != 3 || got["alice@example.com"] != 2500 {
+		t.Errorf("Expected the emails unchanged, got %v", got)
+	}
+}
+
+func TestPolicy_Apply_Domain(t *testing.T) {
+	policy, _ := NewPolicy(Domain, "", nil)
+	result := policy.Apply(families())
+
+	spending := values(t, result, "cursor_spending_by_member_cents", "member_email")
+	if len(spending) != 2 || spending["example.com"] != 3000 || spending["other.org"] != 100 {
+		t.Errorf("Expected spending summed by domain, got %v", spending)
+	}
+
+	ratios := values(t, result, "cursor_budget_utilization_ratio", "member_email")
+	if len(ratios) != 2 || ratios["example.com"] != 1.25 || ratios[""] != 0.5 {
+		t.Errorf("Expected the highest ratio per domain and the team rule untouched, got %v", ratios)
+	}
+}
+
+func TestPolicy_Apply_Alias(t *testing.T) {
+	policy, _ := NewPolicy(Alias, "", map[string]string{"alice@example.com": "alice"})
+	spending := values(t, policy.Apply(families()), "cursor_spending_by_member_cents", "member_email")
+	if len(spending) != 2 || spending["alice"] != 2500 || spending[OtherAlias] != 600 {
+		t.Errorf("Expected alice aliased and the others merged, got %v", spending)
+	}
+}
+
+func TestPolicy_Apply_Hash(t *testing.T) {
+	policy, _ := NewPolicy(Hash, "salt", nil)
+	spending := values(t, policy.Apply(families()), "cursor_spending_by_member_cents", "member_email")
+	if len(spending) != 3 || spending[policy.Identity("alice@example.com")] != 2500 {
+		t.Errorf("Expected one hashed series per member, got %v", spending)
+	}
+}
+
+func TestPolicy_Apply_Drop(t *testing.T) {
+	policy, _ := NewPolicy(Drop, "", nil)
+	result := policy.Apply(families())
+
+	spending := values(t, result, "cursor_spending_by_member_cents", "member_email")
+	if len(spending) != 1 || spending["<absent>"] != 3100 {
+		t.Errorf("Expected the member label dropped and spending summed, got %v", spending)
+	}
+	ratios := values(t, result, "cursor_budget_utilization_ratio", "rule")
+	if len(ratios) != 2 || ratios["members"] != 1.25 {
+		t.Errorf("Expected one ratio per rule, got %v", ratios)
+	}
+	if got := values(t, result, "cursor_team_members_total", "member_email"); got["<absent>"] != 3 {
+		t.Errorf("Expected metrics without identities untouched, got %v", got)
+	}
+}
@@ -0,0 +1,122 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const snapshotVersion = 1
+
+// Snapshot holds the persisted state of every component, keyed by component
+// name. Each value is the component's own JSON encoding.
+type Snapshot map[string]json.RawMessage
+
+// Get decodes the value stored under key into v. It reports false if nothing
+// is stored under key.
+func (s Snapshot) Get(key string, v interface{}) (bool, error) {
+	raw, ok := s[key]
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return true, fmt.Errorf("failed to decode state %q: %w", key, err)
+	}
+	return true, nil
+}
+
+// Put encodes v and stores it under key.
+func (s Snapshot) Put(key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode state %q: %w", key, err)
+	}
+	s[key] = raw
+	return nil
+}
+
+// Store persists snapshots between restarts.
+type Store interface {
+	// Load returns the last saved snapshot, or an empty snapshot if nothing
+	// has been saved yet.
+	Load() (Snapshot, error)
+	Save(Snapshot) error
+}
+
+// FileStore keeps the snapshot in a single JSON file, for example on a
+// Kubernetes PersistentVolume. Saves are atomic: the file is written next to
+// the target and renamed over it.
+type FileStore struct {
+	Path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+type fileSnapshot struct {
+	Version int       `json:"version"`
+	SavedAt time.Time `json:"saved_at"`
+	State   Snapshot  `json:"state"`
+}
+
+func (s *FileStore) Load() (Snapshot, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var file fileSnapshot
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", s.Path, err)
+	}
+	if file.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported state file version %d in %s", file.Version, s.Path)
+	}
+	if file.State == nil {
+		file.State = Snapshot{}
+	}
+	return file.State, nil
+}
+
+func (s *FileStore) Save(snapshot Snapshot) error {
+	data, err := json.Marshal(fileSnapshot{
+		Version: snapshotVersion,
+		SavedAt: time.Now().UTC(),
+		State:   snapshot,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	dir := filepath.Dir(s.Path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.Path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary state file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to sync state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.Path); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+	return nil
+}
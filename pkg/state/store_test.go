@@ -0,0 +1,76 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStore_LoadMissingFile(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+
+	snapshot, err := store.Load()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(snapshot) != 0 {
+		t.Errorf("Expected empty snapshot, got %v", snapshot)
+	}
+}
+
+func TestFileStore_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store := NewFileStore(path)
+
+	type cursor struct {
+		HighWaterMark time.Time `json:"high_water_mark"`
+		Events        int64     `json:"events"`
+	}
+	want := cursor{HighWaterMark: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), Events: 42}
+
+	snapshot := Snapshot{}
+	if err := snapshot.Put("usage_events", want); err != nil {
+		t.Fatalf("Failed to put state: %v", err)
+	}
+	if err := store.Save(snapshot); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	loaded, err := NewFileStore(path).Load()
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+
+	var got cursor
+	ok, err := loaded.Get("usage_events", &got)
+	if err != nil || !ok {
+		t.Fatalf("Expected stored state, got ok=%v err=%v", ok, err)
+	}
+	if !got.HighWaterMark.Equal(want.HighWaterMark) || got.Events != want.Events {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	if ok, _ := loaded.Get("missing", &got); ok {
+		t.Error("Expected missing key to report false")
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("Failed to read state dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the state file to remain, got %d entries", len(entries))
+	}
+}
+
+func TestFileStore_LoadRejectsCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if _, err := NewFileStore(path).Load(); err == nil {
+		t.Error("Expected error for corrupt state file")
+	}
+}
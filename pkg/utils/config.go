@@ -1,6 +1,11 @@
 package utils
 
-import "os"
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
 
 func GetEnvWithDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -8,3 +13,64 @@ func GetEnvWithDefault(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func GetDurationEnvWithDefault(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration for %s: %w", key, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid duration for %s: must be positive, got %s", key, value)
+	}
+	return d, nil
+}
+
+func GetPositiveIntEnvWithDefault(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer for %s: %w", key, err)
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid integer for %s: must be positive, got %d", key, n)
+	}
+	return n, nil
+}
+
+func GetNonNegativeFloatEnvWithDefault(key string, defaultValue float64) (float64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number for %s: %w", key, err)
+	}
+	if f < 0 {
+		return 0, fmt.Errorf("invalid number for %s: must not be negative, got %s", key, value)
+	}
+	return f, nil
+}
+
+func GetBoolEnvWithDefault(key string, defaultValue bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid boolean for %s: %w", key, err)
+	}
+	return b, nil
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestGetEnvWithDefault(t *testing.T) {
@@ -97,3 +98,166 @@ func TestGetEnvWithDefault(t *testing.T) {
 		})
 	}
 }
+
+func TestGetDurationEnvWithDefault(t *testing.T) {
+	tests := []struct {
+		name      string
+		envValue  string
+		setEnv    bool
+		expected  time.Duration
+		expectErr bool
+	}{
+		{
+			name:     "returns default when not set",
+			setEnv:   false,
+			expected: time.Minute,
+		},
+		{
+			name:     "parses duration",
+			envValue: "90s",
+			setEnv:   true,
+			expected: 90 * time.Second,
+		},
+		{
+			name:      "rejects invalid duration",
+			envValue:  "soon",
+			setEnv:    true,
+			expectErr: true,
+		},
+		{
+			name:      "rejects non-positive duration",
+			envValue:  "0s",
+			setEnv:    true,
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.setEnv {
+				t.Setenv("TEST_DURATION_VAR", tt.envValue)
+			} else {
+				t.Setenv("TEST_DURATION_VAR", "")
+			}
+
+			result, err := GetDurationEnvWithDefault("TEST_DURATION_VAR", time.Minute)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected error for %q", tt.envValue)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("GetDurationEnvWithDefault() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetPositiveIntEnvWithDefault(t *testing.T) {
+	tests := []struct {
+		name      string
+		envValue  string
+		expected  int
+		expectErr bool
+	}{
+		{name: "returns default when not set", envValue: "", expected: 30},
+		{name: "parses integer", envValue: "90", expected: 90},
+		{name: "rejects non-integer", envValue: "ninety", expectErr: true},
+		{name: "rejects zero", envValue: "0", expectErr: true},
+		{name: "rejects negative", envValue: "-7", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_INT_VAR", tt.envValue)
+
+			result, err := GetPositiveIntEnvWithDefault("TEST_INT_VAR", 30)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected error for %q", tt.envValue)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("GetPositiveIntEnvWithDefault() = %d, want %d", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetNonNegativeFloatEnvWithDefault(t *testing.T) {
+	tests := []struct {
+		name      string
+		envValue  string
+		expected  float64
+		expectErr bool
+	}{
+		{name: "returns default when not set", envValue: "", expected: 2.5},
+		{name: "parses number", envValue: "0.5", expected: 0.5},
+		{name: "accepts zero", envValue: "0", expected: 0},
+		{name: "rejects non-number", envValue: "fast", expectErr: true},
+		{name: "rejects negative", envValue: "-1", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_FLOAT_VAR", tt.envValue)
+
+			result, err := GetNonNegativeFloatEnvWithDefault("TEST_FLOAT_VAR", 2.5)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected error for %q", tt.envValue)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("GetNonNegativeFloatEnvWithDefault() = %f, want %f", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGetBoolEnvWithDefault(t *testing.T) {
+	tests := []struct {
+		name      string
+		envValue  string
+		expected  bool
+		expectErr bool
+	}{
+		{name: "returns default when not set", envValue: "", expected: true},
+		{name: "parses false", envValue: "false", expected: false},
+		{name: "parses 0", envValue: "0", expected: false},
+		{name: "parses true", envValue: "TRUE", expected: true},
+		{name: "rejects non-boolean", envValue: "maybe", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_BOOL_VAR", tt.envValue)
+
+			result, err := GetBoolEnvWithDefault("TEST_BOOL_VAR", true)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected error for %q", tt.envValue)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("GetBoolEnvWithDefault() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
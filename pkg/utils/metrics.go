@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// LabelKey identifies a set of labels regardless of their order.
+func LabelKey(labels []*dto.LabelPair) string {
+	pairs := make([]string, 0, len(labels))
+	for _, label := range labels {
+		pairs = append(pairs, label.GetName()+"\x00"+label.GetValue())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\x01")
+}
+
+// SortLabels sorts labels by name, as the Prometheus registry does.
+func SortLabels(labels []*dto.LabelPair) {
+	sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+}
+
+// MetricValue returns the value of a counter, gauge or untyped metric, and
+// false for other metrics.
+func MetricValue(m *dto.Metric) (float64, bool) {
+	switch {
+	case m.Counter != nil:
+		return m.Counter.GetValue(), true
+	case m.Gauge != nil:
+		return m.Gauge.GetValue(), true
+	case m.Untyped != nil:
+		return m.Untyped.GetValue(), true
+	}
+	return 0, false
+}
+
+func setMetricValue(m *dto.Metric, value float64) {
+	switch {
+	case m.Counter != nil:
+		m.Counter.Value = proto.Float64(value)
+	case m.Gauge != nil:
+		m.Gauge.Value = proto.Float64(value)
+	case m.Untyped != nil:
+		m.Untyped.Value = proto.Float64(value)
+	}
+}
+
+// Sum and Max combine the values of merged metrics.
+func Sum(a, b float64) float64 { return a + b }
+func Max(a, b float64) float64 { return max(a, b) }
+
+// MergeMetrics merges metrics with identical labels and timestamps into the
+// first of them, combining their values with combine. Samples of the same
+// series at different times, as in backfill files, are kept apart. Metrics
+// that are not counters, gauges or untyped are left as they are.
+func MergeMetrics(metrics []*dto.Metric, combine func(a, b float64) float64) []*dto.Metric {
+	merged := metrics[:0]
+	index := make(map[string]*dto.Metric, len(metrics))
+	for _, metric := range metrics {
+		value, ok := MetricValue(metric)
+		if !ok {
+			merged = append(merged, metric)
+			continue
+		}
+
+		key := LabelKey(metric.Label) + "\x02" + strconv.FormatInt(metric.GetTimestampMs(), 10)
+		existing, ok := index[key]
+		if !ok {
+			index[key] = metric
+			merged = append(merged, metric)
+			continue
+		}
+
+		current, _ := MetricValue(existing)
+		setMetricValue(existing, combine(current, value))
+	}
+	return merged
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMergeMetrics(t *testing.T) {
+	gauge := func(value float64, timestamp int64, labels ...string) *dto.Metric {
+		metric := &dto.Metric{Gauge: &dto.Gauge{Value: proto.Float64(value)}, TimestampMs: proto.Int64(timestamp)}
+		for i := 0; i < len(labels); i += 2 {
+			metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(labels[i]), Value: proto.String(labels[i+1])})
+		}
+		return metric
+	}
+	metrics := func() []*dto.Metric {
+		return []*dto.Metric{
+			gauge(1, 10, "a", "x", "b", "y"),
+			gauge(4, 10, "b", "y", "a", "x"),
+			gauge(2, 20, "a", "z"),
+			gauge(3, 30, "a", "z"),
+			{Summary: &dto.Summary{SampleCount: proto.Uint64(1)}, Label: []*dto.LabelPair{{Name: proto.String("a"), Value: proto.String("x")}}},
+		}
+	}
+
+	summed := MergeMetrics(metrics(), Sum)
+	if len(summed) != 4 {
+		t.Fatalf("Expected the two metrics with the same labels and timestamp merged, the samples at other times and the summary kept, got %d metrics", len(summed))
+	}
+	if got := summed[0].GetGauge().GetValue(); got != 5 {
+		t.Errorf("Expected the values summed, got %v", got)
+	}
+	if got := summed[2].GetTimestampMs(); got != 30 {
+		t.Errorf("Expected the sample at 30 kept apart, got %d", got)
+	}
+
+	if got := MergeMetrics(metrics(), Max)[0].GetGauge().GetValue(); got != 4 {
+		t.Errorf("Expected the highest value, got %v", got)
+	}
+}